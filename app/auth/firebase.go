@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid id token")
+
+// 時計のずれを許容する幅
+const clockSkew = 5 * time.Minute
+
+type TokenVerifier interface {
+	Verify(idToken string) (*Token, error)
+}
+
+type Token struct {
+	UID       string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type firebaseClaims struct {
+	Iss      string `json:"iss"`
+	Aud      string `json:"aud"`
+	Sub      string `json:"sub"`
+	Iat      int64  `json:"iat"`
+	Exp      int64  `json:"exp"`
+	AuthTime int64  `json:"auth_time"`
+}
+
+// Firebase Authentication の ID トークンを公開鍵でオフライン検証する
+type FirebaseVerifier struct {
+	projectID string
+	keys      KeySet
+	now       func() time.Time
+}
+
+func NewFirebaseVerifier(projectID string, keys KeySet) *FirebaseVerifier {
+	return &FirebaseVerifier{projectID: projectID, keys: keys, now: time.Now}
+}
+
+func (v *FirebaseVerifier) Verify(idToken string) (*Token, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unexpected alg %q", ErrInvalidToken, header.Alg)
+	}
+	if header.Kid == "" {
+		return nil, fmt.Errorf("%w: missing kid", ErrInvalidToken)
+	}
+
+	key, err := v.keys.Key(header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims firebaseClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := v.validateClaims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	return &Token{
+		UID:       claims.Sub,
+		IssuedAt:  time.Unix(claims.Iat, 0),
+		ExpiresAt: time.Unix(claims.Exp, 0),
+	}, nil
+}
+
+func (v *FirebaseVerifier) validateClaims(claims *firebaseClaims) error {
+	now := v.now()
+	if claims.Aud != v.projectID {
+		return fmt.Errorf("unexpected aud %q", claims.Aud)
+	}
+	if claims.Iss != "https://securetoken.google.com/"+v.projectID {
+		return fmt.Errorf("unexpected iss %q", claims.Iss)
+	}
+	if claims.Sub == "" || len(claims.Sub) > 128 {
+		return fmt.Errorf("invalid sub")
+	}
+	if now.After(time.Unix(claims.Exp, 0).Add(clockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+	if time.Unix(claims.Iat, 0).After(now.Add(clockSkew)) {
+		return fmt.Errorf("token issued in the future")
+	}
+	if time.Unix(claims.AuthTime, 0).After(now.Add(clockSkew)) {
+		return fmt.Errorf("auth_time in the future")
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
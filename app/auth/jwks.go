@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Firebase の ID トークン署名用公開鍵(JWKS 形式)
+const FirebaseJWKSURL = "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
+
+const defaultKeyCacheTTL = time.Hour
+
+type KeySet interface {
+	Key(kid string) (*rsa.PublicKey, error)
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parseJWKS: %v", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.Kid == "" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("parseJWKS: invalid modulus for kid %s: %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("parseJWKS: invalid exponent for kid %s: %v", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("parseJWKS: no RSA keys found")
+	}
+	return keys, nil
+}
+
+// ローカルファイルから読み込んだ固定の鍵セット(テストやオフライン環境向け)
+type StaticKeySet struct {
+	keys map[string]*rsa.PublicKey
+}
+
+func NewFileKeySet(path string) (*StaticKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("NewFileKeySet: %v", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticKeySet{keys: keys}, nil
+}
+
+func (s *StaticKeySet) Key(kid string) (*rsa.PublicKey, error) {
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+	return key, nil
+}
+
+// JWKS を取得してキャッシュする鍵セット。Cache-Control の max-age まで再取得しない
+type RemoteKeySet struct {
+	url        string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	expiresAt time.Time
+	// 取得中は閉じられるまで他の呼び出しを待たせる。取得中でなければ nil
+	refreshing chan struct{}
+	// 鍵を一度も取得できていない間に返すエラー
+	lastErr error
+}
+
+// 取得に失敗したときに次に取得を試みるまでの間隔
+const keyRefreshBackoff = time.Minute
+
+func NewRemoteKeySet(url string, httpClient *http.Client) *RemoteKeySet {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &RemoteKeySet{url: url, httpClient: httpClient}
+}
+
+func (s *RemoteKeySet) Key(kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	if time.Now().After(s.expiresAt) {
+		if s.refreshing == nil {
+			s.refresh()
+		} else {
+			// 他の呼び出しが取得中なので終わるまで待つ
+			done := s.refreshing
+			s.mu.Unlock()
+			<-done
+			s.mu.Lock()
+		}
+	}
+	defer s.mu.Unlock()
+
+	// 取得に失敗しても古い鍵が残っていればそれで検証を続ける
+	if s.keys == nil {
+		return nil, s.lastErr
+	}
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+	return key, nil
+}
+
+// s.mu を持った状態で呼ぶ。HTTP の取得中はロックを外し、結果の差し替えだけをロック内で行う
+func (s *RemoteKeySet) refresh() {
+	done := make(chan struct{})
+	s.refreshing = done
+	s.mu.Unlock()
+	keys, ttl, err := s.fetch()
+	s.mu.Lock()
+
+	if err != nil {
+		log.Printf("RemoteKeySet.refresh: %v", err)
+		s.lastErr = err
+		s.expiresAt = time.Now().Add(keyRefreshBackoff)
+	} else {
+		s.keys = keys
+		s.lastErr = nil
+		s.expiresAt = time.Now().Add(ttl)
+	}
+	s.refreshing = nil
+	close(done)
+}
+
+func (s *RemoteKeySet) fetch() (map[string]*rsa.PublicKey, time.Duration, error) {
+	resp, err := s.httpClient.Get(s.url)
+	if err != nil {
+		return nil, 0, fmt.Errorf("RemoteKeySet.fetch: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("RemoteKeySet.fetch: unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("RemoteKeySet.fetch: %v", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return keys, maxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultKeyCacheTTL
+}
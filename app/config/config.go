@@ -3,16 +3,441 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type AppConfig struct {
-	AppInfo *AppInfo
+	AppInfo     *AppInfo
+	Firebase    *Firebase
+	Connection  *Connection
+	Session     *Session
+	Capacity    *Capacity
+	Matchmaking *Matchmaking
+	Game        *Game
+	Persistence *Persistence
+	Voice       *Voice
+	SFU         *SFU
+	ICE         *ICE
+	Chat        *Chat
+	Lifecycle   *Lifecycle
+	TileClaim   *TileClaim
 }
 
 type AppInfo struct {
 	DatabaseURL string
 }
 
+type Firebase struct {
+	ProjectID string
+	// 指定された場合はリモートから取得せずローカルの JWKS ファイルを使う
+	JWKSFile string
+}
+
+func loadFirebase() *Firebase {
+	return &Firebase{
+		ProjectID: os.Getenv("FIREBASE_PROJECT_ID"),
+		JWKSFile:  os.Getenv("FIREBASE_JWKS_FILE"),
+	}
+}
+
+// WebSocket 接続ごとの送信キューの設定
+type Connection struct {
+	SendQueueSize  int
+	OverflowPolicy string
+	PongWait       time.Duration
+}
+
+func loadConnection() (*Connection, error) {
+	sendQueueSize, err := getEnvInt("WS_SEND_QUEUE_SIZE", 64)
+	if err != nil {
+		return nil, err
+	}
+	pongWaitSeconds, err := getEnvInt("WS_PONG_WAIT_SECONDS", 20)
+	if err != nil {
+		return nil, err
+	}
+	return &Connection{
+		SendQueueSize:  sendQueueSize,
+		OverflowPolicy: getEnv("WS_OVERFLOW_POLICY", "coalesce-move"),
+		PongWait:       time.Duration(pongWaitSeconds) * time.Second,
+	}, nil
+}
+
+// 切断後に再接続を待つ猶予期間。0 の場合は即座に退出扱いにする
+type Session struct {
+	GracePeriod time.Duration
+}
+
+func loadSession() (*Session, error) {
+	graceSeconds, err := getEnvInt("SESSION_GRACE_SECONDS", 30)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{GracePeriod: time.Duration(graceSeconds) * time.Second}, nil
+}
+
+// 定員に達したルームへの参加の扱い
+type Capacity struct {
+	// true の場合は拒否せずに待ち行列に並べ、空きができたら順に参加させる
+	WaitingQueue bool
+}
+
+func loadCapacity() (*Capacity, error) {
+	waitingQueue, err := getEnvBool("ROOM_WAITING_QUEUE", false)
+	if err != nil {
+		return nil, err
+	}
+	return &Capacity{WaitingQueue: waitingQueue}, nil
+}
+
+type Matchmaking struct {
+	// この時間内にルームが見つからなければ待ち行列から外す。0 の場合は無期限
+	Timeout time.Duration
+	// 新しいルームを作るのに必要な人数
+	MinPlayers int
+}
+
+func loadMatchmaking() (*Matchmaking, error) {
+	timeoutSeconds, err := getEnvInt("MATCH_TIMEOUT_SECONDS", 60)
+	if err != nil {
+		return nil, err
+	}
+	minPlayers, err := getEnvInt("MATCH_MIN_PLAYERS", 2)
+	if err != nil {
+		return nil, err
+	}
+	return &Matchmaking{
+		Timeout:    time.Duration(timeoutSeconds) * time.Second,
+		MinPlayers: minPlayers,
+	}, nil
+}
+
+type Game struct {
+	// ルームごとのゲームループが 1 秒間に状態を配信する回数。0 の場合は move を受け取るたびに配信する
+	TickRate int
+	// false の場合はエリア内の move のたびに全員の位置を送る
+	AreaDeltaUpdates bool
+	// エリア内でこの距離以内にいるユーザーにだけ位置を送る。0 の場合はエリア全員に送る
+	AreaViewRadius int
+	// ミニゲームの OnTick を 1 秒間に呼ぶ回数
+	MinigameTickRate int
+}
+
+// tick の間隔が 0 になって time.NewTicker が panic しないようにする上限
+const maxTickRate = 1000
+
+func loadGame() (*Game, error) {
+	tickRate, err := getEnvInt("GAME_TICK_RATE", 20)
+	if err != nil {
+		return nil, err
+	}
+	if tickRate < 0 || tickRate > maxTickRate {
+		return nil, fmt.Errorf("環境変数 GAME_TICK_RATE は 0 以上 %d 以下にしてください: %d", maxTickRate, tickRate)
+	}
+	areaDeltaUpdates, err := getEnvBool("AREA_DELTA_UPDATES", true)
+	if err != nil {
+		return nil, err
+	}
+	areaViewRadius, err := getEnvInt("AREA_VIEW_RADIUS", 0)
+	if err != nil {
+		return nil, err
+	}
+	if areaViewRadius < 0 {
+		return nil, fmt.Errorf("環境変数 AREA_VIEW_RADIUS は 0 以上にしてください: %d", areaViewRadius)
+	}
+	if areaViewRadius > 0 && !areaDeltaUpdates {
+		return nil, fmt.Errorf("環境変数 AREA_VIEW_RADIUS を使う場合は AREA_DELTA_UPDATES を有効にしてください")
+	}
+	minigameTickRate, err := getEnvInt("MINIGAME_TICK_RATE", 10)
+	if err != nil {
+		return nil, err
+	}
+	if minigameTickRate < 1 || minigameTickRate > maxTickRate {
+		return nil, fmt.Errorf("環境変数 MINIGAME_TICK_RATE は 1 以上 %d 以下にしてください: %d", maxTickRate, minigameTickRate)
+	}
+	return &Game{TickRate: tickRate, AreaDeltaUpdates: areaDeltaUpdates, AreaViewRadius: areaViewRadius, MinigameTickRate: minigameTickRate}, nil
+}
+
+// 位置の DB への書き込み
+type Persistence struct {
+	// 位置をまとめて書き込む間隔。異常終了した場合に失われるのはこの間の移動まで。0 の場合は move のたびに書き込む
+	FlushInterval time.Duration
+}
+
+func loadPersistence() (*Persistence, error) {
+	flushIntervalMillis, err := getEnvInt("POSITION_FLUSH_INTERVAL_MS", 1000)
+	if err != nil {
+		return nil, err
+	}
+	if flushIntervalMillis < 0 {
+		return nil, fmt.Errorf("環境変数 POSITION_FLUSH_INTERVAL_MS は 0 以上にしてください: %d", flushIntervalMillis)
+	}
+	return &Persistence{FlushInterval: time.Duration(flushIntervalMillis) * time.Millisecond}, nil
+}
+
+// エリア内の距離に応じた音声の設定
+type Voice struct {
+	// この距離以内に近づいたユーザー同士を音声でつなぐ。0 の場合は join-audio でのみつなぐ
+	ProximityRadius int
+	// つないだ後は ProximityRadius よりこの分だけ離れるまで切らない。境界付近での揺れでつなぎ直さないようにする
+	ExitMargin int
+}
+
+func loadVoice() (*Voice, error) {
+	proximityRadius, err := getEnvInt("PROXIMITY_VOICE_RADIUS", 0)
+	if err != nil {
+		return nil, err
+	}
+	exitMargin, err := getEnvInt("PROXIMITY_VOICE_EXIT_MARGIN", 32)
+	if err != nil {
+		return nil, err
+	}
+	if proximityRadius < 0 || exitMargin < 0 {
+		return nil, fmt.Errorf("環境変数 PROXIMITY_VOICE_RADIUS と PROXIMITY_VOICE_EXIT_MARGIN は 0 以上にしてください: %d, %d", proximityRadius, exitMargin)
+	}
+	return &Voice{ProximityRadius: proximityRadius, ExitMargin: exitMargin}, nil
+}
+
+// ルームタイプで voiceMode を sfu にしたルームの音声を中継するサーバー側の設定
+type SFU struct {
+	Enabled bool
+	// NAT の内側で動かす場合の外側のアドレス
+	PublicIPs  []string
+	STUNURLs   []string
+	UDPPortMin int
+	UDPPortMax int
+}
+
+func loadSFU() (*SFU, error) {
+	enabled, err := getEnvBool("SFU_ENABLED", true)
+	if err != nil {
+		return nil, err
+	}
+	portMin, err := getEnvInt("SFU_UDP_PORT_MIN", 0)
+	if err != nil {
+		return nil, err
+	}
+	portMax, err := getEnvInt("SFU_UDP_PORT_MAX", 0)
+	if err != nil {
+		return nil, err
+	}
+	if portMin < 0 || portMax > 65535 || portMin > portMax {
+		return nil, fmt.Errorf("環境変数 SFU_UDP_PORT_MIN と SFU_UDP_PORT_MAX の範囲が不正です: %d - %d", portMin, portMax)
+	}
+	return &SFU{
+		Enabled:    enabled,
+		PublicIPs:  getEnvList("SFU_PUBLIC_IPS"),
+		STUNURLs:   getEnvList("SFU_STUN_URLS"),
+		UDPPortMin: portMin,
+		UDPPortMax: portMax,
+	}, nil
+}
+
+// クライアントに配る STUN / TURN サーバー
+type ICE struct {
+	STUNURLs []string
+	TURNURLs []string
+	// TURN サーバーと共有する秘密鍵。TURN REST API の方式で期限付きの認証情報を作る
+	TURNSecret        string
+	TURNCredentialTTL time.Duration
+}
+
+func loadICE() (*ICE, error) {
+	ttlSeconds, err := getEnvInt("TURN_CREDENTIAL_TTL_SECONDS", 3600)
+	if err != nil {
+		return nil, err
+	}
+	if ttlSeconds <= 0 {
+		return nil, fmt.Errorf("環境変数 TURN_CREDENTIAL_TTL_SECONDS は 1 以上にしてください: %d", ttlSeconds)
+	}
+	ice := &ICE{
+		STUNURLs:          getEnvList("ICE_STUN_URLS"),
+		TURNURLs:          getEnvList("ICE_TURN_URLS"),
+		TURNSecret:        os.Getenv("TURN_SECRET"),
+		TURNCredentialTTL: time.Duration(ttlSeconds) * time.Second,
+	}
+	if len(ice.TURNURLs) > 0 && ice.TURNSecret == "" {
+		return nil, fmt.Errorf("環境変数 ICE_TURN_URLS を使う場合は TURN_SECRET も設定してください")
+	}
+	return ice, nil
+}
+
+type Chat struct {
+	// 1 件のメッセージの最大の文字数
+	MaxLength int
+	// 参加時に送る履歴の件数
+	HistoryLimit int
+	// ユーザーごとに続けて送れる件数。使い切った後は RateInterval ごとに 1 件ずつ送れるようになる
+	RateBurst    int
+	RateInterval time.Duration
+}
+
+func loadChat() (*Chat, error) {
+	maxLength, err := getEnvInt("CHAT_MAX_LENGTH", 500)
+	if err != nil {
+		return nil, err
+	}
+	historyLimit, err := getEnvInt("CHAT_HISTORY_LIMIT", 50)
+	if err != nil {
+		return nil, err
+	}
+	rateBurst, err := getEnvInt("CHAT_RATE_BURST", 5)
+	if err != nil {
+		return nil, err
+	}
+	rateIntervalMillis, err := getEnvInt("CHAT_RATE_INTERVAL_MS", 1000)
+	if err != nil {
+		return nil, err
+	}
+	if maxLength <= 0 || rateBurst <= 0 || rateIntervalMillis <= 0 {
+		return nil, fmt.Errorf("環境変数 CHAT_MAX_LENGTH と CHAT_RATE_BURST と CHAT_RATE_INTERVAL_MS は 1 以上にしてください: %d, %d, %d", maxLength, rateBurst, rateIntervalMillis)
+	}
+	if historyLimit < 0 {
+		return nil, fmt.Errorf("環境変数 CHAT_HISTORY_LIMIT は 0 以上にしてください: %d", historyLimit)
+	}
+	return &Chat{
+		MaxLength:    maxLength,
+		HistoryLimit: historyLimit,
+		RateBurst:    rateBurst,
+		RateInterval: time.Duration(rateIntervalMillis) * time.Millisecond,
+	}, nil
+}
+
+// ルームの状態の遷移に使う時間
+type Lifecycle struct {
+	// ready-check で全員の準備が揃うのを待つ時間。過ぎた場合は waiting に戻す
+	ReadyCheckTimeout time.Duration
+	// countdown から playing になるまでの時間
+	Countdown time.Duration
+	// results を表示してから waiting に戻すまでの時間
+	Results time.Duration
+	// 誰もいなくなったルームを閉じて削除するまでの時間。0 の場合は削除しない
+	EmptyRoomTimeout time.Duration
+}
+
+func loadLifecycle() (*Lifecycle, error) {
+	readyCheckTimeoutSeconds, err := getEnvInt("ROOM_READY_CHECK_TIMEOUT_SECONDS", 30)
+	if err != nil {
+		return nil, err
+	}
+	countdownSeconds, err := getEnvInt("ROOM_COUNTDOWN_SECONDS", 3)
+	if err != nil {
+		return nil, err
+	}
+	resultsSeconds, err := getEnvInt("ROOM_RESULTS_SECONDS", 10)
+	if err != nil {
+		return nil, err
+	}
+	emptyRoomTimeoutSeconds, err := getEnvInt("ROOM_EMPTY_TIMEOUT_SECONDS", 300)
+	if err != nil {
+		return nil, err
+	}
+	if readyCheckTimeoutSeconds <= 0 || countdownSeconds < 0 || resultsSeconds < 0 {
+		return nil, fmt.Errorf("環境変数 ROOM_READY_CHECK_TIMEOUT_SECONDS は 1 以上、ROOM_COUNTDOWN_SECONDS と ROOM_RESULTS_SECONDS は 0 以上にしてください: %d, %d, %d", readyCheckTimeoutSeconds, countdownSeconds, resultsSeconds)
+	}
+	if emptyRoomTimeoutSeconds < 0 {
+		return nil, fmt.Errorf("環境変数 ROOM_EMPTY_TIMEOUT_SECONDS は 0 以上にしてください: %d", emptyRoomTimeoutSeconds)
+	}
+	return &Lifecycle{
+		ReadyCheckTimeout: time.Duration(readyCheckTimeoutSeconds) * time.Second,
+		Countdown:         time.Duration(countdownSeconds) * time.Second,
+		Results:           time.Duration(resultsSeconds) * time.Second,
+		EmptyRoomTimeout:  time.Duration(emptyRoomTimeoutSeconds) * time.Second,
+	}, nil
+}
+
+// ミニゲーム tile-claim の設定
+type TileClaim struct {
+	// 1 マスの大きさ。座標をこの大きさで割ったものがマスになる
+	TileSize int
+	// 1 ラウンドの長さ
+	RoundDuration time.Duration
+	// チームの数
+	Teams int
+}
+
+func loadTileClaim() (*TileClaim, error) {
+	tileSize, err := getEnvInt("TILE_CLAIM_TILE_SIZE", 32)
+	if err != nil {
+		return nil, err
+	}
+	roundSeconds, err := getEnvInt("TILE_CLAIM_ROUND_SECONDS", 60)
+	if err != nil {
+		return nil, err
+	}
+	teams, err := getEnvInt("TILE_CLAIM_TEAMS", 2)
+	if err != nil {
+		return nil, err
+	}
+	if tileSize <= 0 || roundSeconds <= 0 {
+		return nil, fmt.Errorf("環境変数 TILE_CLAIM_TILE_SIZE と TILE_CLAIM_ROUND_SECONDS は 1 以上にしてください: %d, %d", tileSize, roundSeconds)
+	}
+	if teams < 2 {
+		return nil, fmt.Errorf("環境変数 TILE_CLAIM_TEAMS は 2 以上にしてください: %d", teams)
+	}
+	return &TileClaim{
+		TileSize:      tileSize,
+		RoundDuration: time.Duration(roundSeconds) * time.Second,
+		Teams:         teams,
+	}, nil
+}
+
+func getEnv(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("環境変数 %s が数値ではありません: %s", key, value)
+	}
+	return n, nil
+}
+
+// カンマ区切りの値を返す。未設定の場合は nil
+func getEnvList(key string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func getEnvBool(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("環境変数 %s が真偽値ではありません: %s", key, value)
+	}
+	return b, nil
+}
+
+// API サーバーの起動に必要な設定が揃っているかを確認する
+func (c *AppConfig) ValidateForAPI() error {
+	if c.Firebase.ProjectID == "" {
+		return fmt.Errorf("環境変数が不足しています。FIREBASE_PROJECT_ID: %s", c.Firebase.ProjectID)
+	}
+	return nil
+}
+
 func loadDatabaseURL(dbName string) (string, error) {
 	mysqlHost := os.Getenv("MYSQL_HOST")
 	mysqlUser := os.Getenv("MYSQL_USER")
@@ -34,8 +459,80 @@ func LoadConfig() (*AppConfig, error) {
 		DatabaseURL: databaseURL,
 	}
 
+	connection, err := loadConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := loadSession()
+	if err != nil {
+		return nil, err
+	}
+
+	capacity, err := loadCapacity()
+	if err != nil {
+		return nil, err
+	}
+
+	matchmaking, err := loadMatchmaking()
+	if err != nil {
+		return nil, err
+	}
+
+	game, err := loadGame()
+	if err != nil {
+		return nil, err
+	}
+
+	persistence, err := loadPersistence()
+	if err != nil {
+		return nil, err
+	}
+
+	voice, err := loadVoice()
+	if err != nil {
+		return nil, err
+	}
+
+	sfu, err := loadSFU()
+	if err != nil {
+		return nil, err
+	}
+
+	ice, err := loadICE()
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := loadChat()
+	if err != nil {
+		return nil, err
+	}
+
+	lifecycle, err := loadLifecycle()
+	if err != nil {
+		return nil, err
+	}
+	tileClaim, err := loadTileClaim()
+	if err != nil {
+		return nil, err
+	}
+
 	config := AppConfig{
-		AppInfo: appInfo,
+		AppInfo:     appInfo,
+		Firebase:    loadFirebase(),
+		Connection:  connection,
+		Session:     session,
+		Capacity:    capacity,
+		Matchmaking: matchmaking,
+		Game:        game,
+		Persistence: persistence,
+		Voice:       voice,
+		SFU:         sfu,
+		ICE:         ice,
+		Chat:        chat,
+		Lifecycle:   lifecycle,
+		TileClaim:   tileClaim,
 	}
 
 	return &config, nil
@@ -51,8 +548,80 @@ func LoadTestConfig() (*AppConfig, error) {
 		DatabaseURL: databaseURL,
 	}
 
+	connection, err := loadConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := loadSession()
+	if err != nil {
+		return nil, err
+	}
+
+	capacity, err := loadCapacity()
+	if err != nil {
+		return nil, err
+	}
+
+	matchmaking, err := loadMatchmaking()
+	if err != nil {
+		return nil, err
+	}
+
+	game, err := loadGame()
+	if err != nil {
+		return nil, err
+	}
+
+	persistence, err := loadPersistence()
+	if err != nil {
+		return nil, err
+	}
+
+	voice, err := loadVoice()
+	if err != nil {
+		return nil, err
+	}
+
+	sfu, err := loadSFU()
+	if err != nil {
+		return nil, err
+	}
+
+	ice, err := loadICE()
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := loadChat()
+	if err != nil {
+		return nil, err
+	}
+
+	lifecycle, err := loadLifecycle()
+	if err != nil {
+		return nil, err
+	}
+	tileClaim, err := loadTileClaim()
+	if err != nil {
+		return nil, err
+	}
+
 	config := AppConfig{
-		AppInfo: appInfo,
+		AppInfo:     appInfo,
+		Firebase:    loadFirebase(),
+		Connection:  connection,
+		Session:     session,
+		Capacity:    capacity,
+		Matchmaking: matchmaking,
+		Game:        game,
+		Persistence: persistence,
+		Voice:       voice,
+		SFU:         sfu,
+		ICE:         ice,
+		Chat:        chat,
+		Lifecycle:   lifecycle,
+		TileClaim:   tileClaim,
 	}
 
 	return &config, nil
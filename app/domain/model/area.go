@@ -11,6 +11,7 @@ type Area struct {
 	RoomCount      int
 	Status         string
 	Description    string
+	MovementRule   MovementRule `gorm:"embedded;embeddedPrefix:movement_"`
 	Rooms          []Room
 	UserLocations  []UserLocation
 }
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ユーザーへの制限。RoomID が指定されていればそのルーム、AreaID だけならそのエリア、どちらも 0 ならすべてに適用する
+type Ban struct {
+	gorm.Model
+	UserID uint   `gorm:"index"`
+	Kind   string `gorm:"size:16"`
+	AreaID uint   `gorm:"index"`
+	RoomID uint   `gorm:"index"`
+	Reason string
+	// 制限したユーザー
+	IssuedBy uint
+	// nil の場合は無期限
+	ExpiresAt *time.Time
+}
+
+const (
+	// 参加できない
+	BanKindBan = "ban"
+	// 音声に参加できない
+	BanKindMuteAudio = "mute-audio"
+	// チャットを送れない
+	BanKindMuteChat = "mute-chat"
+)
+
+func (b *Ban) Active(now time.Time) bool {
+	return b.ExpiresAt == nil || b.ExpiresAt.After(now)
+}
@@ -0,0 +1,23 @@
+package model
+
+import "gorm.io/gorm"
+
+type ChatMessage struct {
+	gorm.Model
+	Scope      string `gorm:"size:16;index"`
+	AreaID     uint   `gorm:"index"`
+	RoomID     uint   `gorm:"index"`
+	FromUserID uint   `gorm:"index"`
+	// scope が direct の場合の宛先
+	ToUserID uint   `gorm:"index"`
+	Text     string `gorm:"type:text"`
+}
+
+const (
+	// 送信者が参加中のルームの全員に送る
+	ChatScopeRoom = "room"
+	// 送信者が参加中のエリアの全員に送る
+	ChatScopeArea = "area"
+	// toUserID のユーザーにだけ送る
+	ChatScopeDirect = "direct"
+)
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/wsconn"
+)
+
+const (
+	UserGameLocationStatusConnected    = "connected"
+	UserGameLocationStatusReconnecting = "reconnecting"
+)
+
+// 再接続待ちの間に溜めておくイベントの上限
+const maxMissedEvents = 256
+
+// join-game 時に発行する再接続用のセッション
+// 接続が切れてから猶予期間内に同じトークンで再接続すれば、同じ UserGameLocation に復帰できる
+type GameSession struct {
+	Token  string
+	UserID uint
+	RoomID uint
+
+	mu        sync.Mutex
+	suspended bool
+	expired   bool
+	timer     *time.Timer
+	missed    []interface{}
+}
+
+func NewGameSession(token string, userID uint, roomID uint) *GameSession {
+	return &GameSession{Token: token, UserID: userID, RoomID: roomID}
+}
+
+// 接続が切れたことを記録し、猶予期間が過ぎたら onExpire を呼ぶ
+func (s *GameSession) Suspend(grace time.Duration, onExpire func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.suspended || s.expired {
+		return
+	}
+	s.suspended = true
+	s.timer = time.AfterFunc(grace, func() {
+		s.mu.Lock()
+		if !s.suspended {
+			s.mu.Unlock()
+			return
+		}
+		s.expired = true
+		s.missed = nil
+		s.mu.Unlock()
+		onExpire()
+	})
+}
+
+// 再接続を受け付け、切断中に溜まったイベントを返す。猶予期間を過ぎていた場合は false を返す
+func (s *GameSession) Resume() ([]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expired {
+		return nil, false
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.suspended = false
+	missed := s.missed
+	s.missed = nil
+	return missed, true
+}
+
+func (s *GameSession) IsSuspended() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.suspended
+}
+
+// 切断中であればイベントを溜めて true を返す
+func (s *GameSession) Buffer(msg interface{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.suspended {
+		return false
+	}
+	// move などは最新の状態だけ分かればよいので置き換える
+	if coalescer, ok := msg.(wsconn.Coalescer); ok {
+		for i, missed := range s.missed {
+			if other, ok := missed.(wsconn.Coalescer); ok && other.CoalesceKey() == coalescer.CoalesceKey() {
+				s.missed = append(s.missed[:i], s.missed[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(s.missed) >= maxMissedEvents {
+		s.missed = s.missed[1:]
+	}
+	s.missed = append(s.missed, msg)
+	return true
+}
+
+// 猶予期間のタイマーを止めて以降の再接続を受け付けないようにする
+func (s *GameSession) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.suspended = false
+	s.expired = true
+	s.missed = nil
+}
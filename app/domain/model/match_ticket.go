@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// マッチング待ちのユーザー。同じエリア・ルームタイプのチケット同士で同じルームに割り当てる
+type MatchTicket struct {
+	UserGameLocation *UserGameLocation
+	AreaID           uint
+	RoomTypeID       uint
+	CreatedAt        time.Time
+	// 割り当てたルーム。Matched で接続のゴルーチンに渡してから参加させる
+	RoomID uint
+	// UserGameLocation の RoomID は接続のゴルーチンでしか書き換えないため、割り当ては接続のゴルーチンに渡す
+	Matched chan<- *MatchTicket
+}
+
+func NewMatchTicket(userGameLocation *UserGameLocation, areaID uint, roomTypeID uint, matched chan<- *MatchTicket) *MatchTicket {
+	return &MatchTicket{
+		UserGameLocation: userGameLocation,
+		AreaID:           areaID,
+		RoomTypeID:       roomTypeID,
+		CreatedAt:        time.Now(),
+		Matched:          matched,
+	}
+}
@@ -1,9 +1,9 @@
 package model
 
 type Message struct {
-	Payload map[string]interface{}
+	Payload interface{}
 }
 
-func NewMessage(payload map[string]interface{}) *Message {
+func NewMessage(payload interface{}) *Message {
 	return &Message{Payload: payload}
 }
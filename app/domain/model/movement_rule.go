@@ -0,0 +1,203 @@
+package model
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	MoveCorrectionOutOfBounds = "out-of-bounds"
+	MoveCorrectionTooFast     = "too-fast"
+	MoveCorrectionBlocked     = "blocked"
+)
+
+// 移動量の判定で通信の揺らぎを許容する割合
+const moveSpeedTolerance = 1.2
+
+type Tile struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// エリアやルームタイプごとの移動のルール。0 の項目は制限しない
+type MovementRule struct {
+	MapWidth  int
+	MapHeight int
+	// 1 秒あたりに移動できる距離
+	MaxSpeed int
+	// BlockedTiles の 1 マスの大きさ。0 の場合は 1
+	TileSize     int
+	BlockedTiles []Tile `gorm:"serializer:json;type:text"`
+
+	blocked map[Tile]bool
+}
+
+// 通れないマスを引きやすいように索引を作ったコピーを返す
+func (r MovementRule) Indexed() MovementRule {
+	r.blocked = make(map[Tile]bool, len(r.BlockedTiles))
+	for _, tile := range r.BlockedTiles {
+		r.blocked[tile] = true
+	}
+	return r
+}
+
+func (r *MovementRule) tileSize() int {
+	if r.TileSize <= 0 {
+		return 1
+	}
+	return r.TileSize
+}
+
+func (r *MovementRule) tileAt(x int, y int) Tile {
+	size := r.tileSize()
+	return Tile{
+		X: int(math.Floor(float64(x) / float64(size))),
+		Y: int(math.Floor(float64(y) / float64(size))),
+	}
+}
+
+func (r *MovementRule) IsBlocked(x int, y int) bool {
+	return r.isTileBlocked(r.tileAt(x, y))
+}
+
+func (r *MovementRule) isTileBlocked(tile Tile) bool {
+	if r.blocked != nil {
+		return r.blocked[tile]
+	}
+	for _, blocked := range r.BlockedTiles {
+		if blocked == tile {
+			return true
+		}
+	}
+	return false
+}
+
+// from から to までの線分が通るマスに通れないものがあれば true を返す。出発したマスは見ない
+// マスの角をちょうど通る場合は、角を挟む 2 つのマスのどちらかが通れなければ通れないとする
+func (r *MovementRule) IsPathBlocked(fromX int, fromY int, toX int, toY int) bool {
+	if len(r.BlockedTiles) == 0 {
+		return false
+	}
+	current := r.tileAt(fromX, fromY)
+	target := r.tileAt(toX, toY)
+	size := float64(r.tileSize())
+	stepX, nextX, deltaX := traverseAxis(fromX, toX, current.X, size)
+	stepY, nextY, deltaY := traverseAxis(fromY, toY, current.Y, size)
+
+	// 誤差で target を通り過ぎないよう、target に揃った軸はそれ以上進めない
+	remaining := abs(target.X-current.X) + abs(target.Y-current.Y)
+	for remaining > 0 {
+		switch {
+		case current.Y == target.Y || nextX < nextY:
+			current.X += stepX
+			nextX += deltaX
+			remaining--
+		case current.X == target.X || nextY < nextX:
+			current.Y += stepY
+			nextY += deltaY
+			remaining--
+		default:
+			if r.isTileBlocked(Tile{X: current.X + stepX, Y: current.Y}) || r.isTileBlocked(Tile{X: current.X, Y: current.Y + stepY}) {
+				return true
+			}
+			current.X += stepX
+			current.Y += stepY
+			nextX += deltaX
+			nextY += deltaY
+			remaining -= 2
+		}
+		if r.isTileBlocked(current) {
+			return true
+		}
+	}
+	return false
+}
+
+// 1 つの軸について、進む向き、次のマスの境界に着く位置 (線分の長さを 1 とした割合)、1 マス進むのにかかる割合を返す
+func traverseAxis(from int, to int, tile int, size float64) (int, float64, float64) {
+	d := float64(to - from)
+	switch {
+	case d > 0:
+		return 1, (float64(tile+1)*size - float64(from)) / d, size / d
+	case d < 0:
+		return -1, (float64(tile)*size - float64(from)) / d, -size / d
+	default:
+		return 0, math.Inf(1), math.Inf(1)
+	}
+}
+
+// 移動できる距離の残り。MaxSpeed の速さで回復し、1 秒分まで溜められる
+type MovementState struct {
+	budget    float64
+	updatedAt time.Time
+}
+
+// 参加した時点では 1 秒分動ける状態にする
+func (s *MovementState) Reset(rule *MovementRule, now time.Time) {
+	s.budget = float64(rule.MaxSpeed)
+	s.updatedAt = now
+}
+
+// from から to への移動をルールに合わせて補正する
+// 補正した場合は補正後の位置と理由を返す。理由が空の場合は to をそのまま受け入れている
+func (r *MovementRule) Apply(state *MovementState, fromX int, fromY int, toX int, toY int, now time.Time) (int, int, string) {
+	x, y := toX, toY
+	reason := ""
+
+	if r.MaxSpeed > 0 {
+		if !state.updatedAt.IsZero() {
+			state.budget += now.Sub(state.updatedAt).Seconds() * float64(r.MaxSpeed)
+		}
+		if state.budget > float64(r.MaxSpeed) {
+			state.budget = float64(r.MaxSpeed)
+		}
+		state.updatedAt = now
+
+		dx, dy := float64(toX-fromX), float64(toY-fromY)
+		distance := math.Hypot(dx, dy)
+		allowed := state.budget * moveSpeedTolerance
+		if distance > allowed {
+			scale := allowed / distance
+			x = fromX + int(dx*scale)
+			y = fromY + int(dy*scale)
+			reason = MoveCorrectionTooFast
+			distance = math.Hypot(float64(x-fromX), float64(y-fromY))
+		}
+		state.budget -= distance / moveSpeedTolerance
+		if state.budget < 0 {
+			state.budget = 0
+		}
+	}
+
+	if r.MapWidth > 0 && (x < 0 || x > r.MapWidth) {
+		x = clamp(x, 0, r.MapWidth)
+		reason = MoveCorrectionOutOfBounds
+	}
+	if r.MapHeight > 0 && (y < 0 || y > r.MapHeight) {
+		y = clamp(y, 0, r.MapHeight)
+		reason = MoveCorrectionOutOfBounds
+	}
+
+	// 通れないマスを飛び越えられないよう、途中のマスも見る
+	if r.IsBlocked(x, y) || r.IsPathBlocked(fromX, fromY, x, y) {
+		return fromX, fromY, MoveCorrectionBlocked
+	}
+	return x, y, reason
+}
+
+func clamp(value int, lower int, upper int) int {
+	if value < lower {
+		return lower
+	}
+	if value > upper {
+		return upper
+	}
+	return value
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
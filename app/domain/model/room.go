@@ -6,10 +6,107 @@ import (
 
 type Room struct {
 	gorm.Model
-	AreaID        uint
-	Area          Area
-	RoomTypeID    uint
-	RoomType      RoomType
-	Status        int
+	AreaID     uint
+	Area       Area
+	RoomTypeID uint
+	RoomType   RoomType
+	// ルームを作成したユーザー。0 の場合は所有者なし
+	OwnerID uint `gorm:"index"`
+	// public / private / invite-only
+	Visibility string `gorm:"size:16;default:public"`
+	// bcrypt のハッシュ。空の場合はパスコードなし
+	PasscodeHash string
+	// ルームの状態。遷移は CanTransitionTo で確認してから行う
+	Status        RoomStatus
 	UserLocations []UserLocation
 }
+
+const (
+	// ロビーに表示され、パスコードが無ければ誰でも参加できる
+	RoomVisibilityPublic = "public"
+	// ロビーに表示されず、パスコードか招待コードで参加する
+	RoomVisibilityPrivate = "private"
+	// ロビーに表示されるが、招待コードが無いと参加できない
+	RoomVisibilityInviteOnly = "invite-only"
+)
+
+func IsRoomVisibility(visibility string) bool {
+	switch visibility {
+	case RoomVisibilityPublic, RoomVisibilityPrivate, RoomVisibilityInviteOnly:
+		return true
+	default:
+		return false
+	}
+}
+
+// ロビーのルーム一覧に表示するか
+func (r *Room) Listed() bool {
+	return r.Visibility != RoomVisibilityPrivate
+}
+
+// パスコードも招待コードも無しで誰でも参加できるか
+func (r *Room) Open() bool {
+	return (r.Visibility == RoomVisibilityPublic || r.Visibility == "") && r.PasscodeHash == ""
+}
+
+type RoomStatus int
+
+// DB には数値で保存するため、値の順番は変更しないこと
+const (
+	// 参加者を待っている
+	RoomStatusWaiting RoomStatus = iota
+	// 参加者の準備が揃うのを待っている
+	RoomStatusReadyCheck
+	// ゲームの開始までのカウントダウン
+	RoomStatusCountdown
+	// ゲーム中
+	RoomStatusPlaying
+	// 結果の表示中
+	RoomStatusResults
+	// 閉じられたルーム。以降は遷移しない
+	RoomStatusClosed
+)
+
+var roomStatusNames = map[RoomStatus]string{
+	RoomStatusWaiting:    "waiting",
+	RoomStatusReadyCheck: "ready-check",
+	RoomStatusCountdown:  "countdown",
+	RoomStatusPlaying:    "playing",
+	RoomStatusResults:    "results",
+	RoomStatusClosed:     "closed",
+}
+
+// 状態ごとに遷移できる次の状態。どの状態からでも閉じることができる
+var roomStatusTransitions = map[RoomStatus][]RoomStatus{
+	RoomStatusWaiting:    {RoomStatusReadyCheck, RoomStatusClosed},
+	RoomStatusReadyCheck: {RoomStatusCountdown, RoomStatusWaiting, RoomStatusClosed},
+	RoomStatusCountdown:  {RoomStatusPlaying, RoomStatusWaiting, RoomStatusClosed},
+	RoomStatusPlaying:    {RoomStatusResults, RoomStatusWaiting, RoomStatusClosed},
+	RoomStatusResults:    {RoomStatusWaiting, RoomStatusClosed},
+}
+
+func (s RoomStatus) String() string {
+	if name, ok := roomStatusNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+func (s RoomStatus) Valid() bool {
+	_, ok := roomStatusNames[s]
+	return ok
+}
+
+func (s RoomStatus) CanTransitionTo(next RoomStatus) bool {
+	for _, status := range roomStatusTransitions[s] {
+		if status == next {
+			return true
+		}
+	}
+	return false
+}
+
+// 新しいユーザーが参加できる状態か。ゲームが始まった後は結果の表示が終わるまで参加できない
+func (s RoomStatus) AcceptsPlayers() bool {
+	return s == RoomStatusWaiting || s == RoomStatusReadyCheck
+}
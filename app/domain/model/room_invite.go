@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 共有用の招待コード。private と invite-only のルームにはこのコードで参加できる
+type RoomInvite struct {
+	gorm.Model
+	RoomID uint   `gorm:"index"`
+	Code   string `gorm:"size:32;uniqueIndex"`
+	// 発行したユーザー
+	CreatedBy uint
+	// nil の場合は無期限
+	ExpiresAt *time.Time
+	// 0 の場合は回数の制限なし
+	MaxUses int
+	Uses    int
+}
+
+func (i *RoomInvite) Usable(now time.Time) bool {
+	if i.ExpiresAt != nil && !i.ExpiresAt.After(now) {
+		return false
+	}
+	return i.MaxUses == 0 || i.Uses < i.MaxUses
+}
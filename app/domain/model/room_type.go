@@ -9,5 +9,21 @@ type RoomType struct {
 	Name           string
 	MaxParticipant int
 	Description    string
-	Rooms          []Room
+	MovementRule   MovementRule `gorm:"embedded;embeddedPrefix:movement_"`
+	// 音声のつなぎ方。空の場合は mesh として扱う
+	VoiceMode string
+	// playing の間に動かすミニゲームの名前。空の場合は位置の中継だけを行う
+	Minigame string
+	Rooms    []Room
+}
+
+const (
+	// クライアント同士が直接つなぐ
+	VoiceModeMesh = "mesh"
+	// サーバーが各クライアントと 1 本ずつつなぎ、音声を転送する
+	VoiceModeSFU = "sfu"
+)
+
+func (r *RoomType) UsesSFU() bool {
+	return r.VoiceMode == VoiceModeSFU
 }
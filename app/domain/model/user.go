@@ -9,6 +9,8 @@ type User struct {
 	FirebaseUID string `gorm:"type:varchar(255);uniqueIndex"`
 	Username    string
 	AvatarID    uint
+	// true の場合は管理用 API を使え、どのルームのユーザーも制限できる
+	IsAdmin bool `gorm:"default:false"`
 }
 
 func NewUser(firebaseUID string) *User {
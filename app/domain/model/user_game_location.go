@@ -4,25 +4,46 @@ import (
 	"encoding/json"
 	"sync"
 
-	"github.com/gorilla/websocket"
+	"github.com/sako0/minigame-space-api/app/wsconn"
 	"gorm.io/gorm"
 )
 
 type UserGameLocation struct {
 	gorm.Model
-	UserID uint
+	UserID uint `gorm:"uniqueIndex"`
 	User   *User
 	RoomID uint
 	Room   *Room
 	XAxis  int
 	YAxis  int
 	Status string
-	Conn   *websocket.Conn `gorm:"-"`
-	Mutex  sync.Mutex      `gorm:"-"`
+	Conn   *wsconn.Conn `gorm:"-"`
+	connMu sync.RWMutex
+	// 参加中のルームの移動のルールと、移動量の判定に使う状態
+	MovementRule  MovementRule  `gorm:"-"`
+	MovementState MovementState `gorm:"-"`
+	// パスコードや招待コードを確認済みのルーム。private などのルームに参加し直す時は確認を省く
+	AdmittedRoomID uint `gorm:"-"`
 }
 
-func NewUserGameLocationByConn(conn *websocket.Conn) *UserGameLocation {
-	return &UserGameLocation{Conn: conn}
+func NewUserGameLocationByConn(conn *wsconn.Conn) *UserGameLocation {
+	return &UserGameLocation{Conn: conn, Status: UserGameLocationStatusConnected}
+}
+
+// 再接続で Conn が差し替わることがあるため、他のゴルーチンからはこちらを使う
+func (u *UserGameLocation) Connection() *wsconn.Conn {
+	u.connMu.RLock()
+	defer u.connMu.RUnlock()
+	return u.Conn
+}
+
+// 新しい接続に差し替え、それまでの接続を返す
+func (u *UserGameLocation) Rebind(conn *wsconn.Conn) *wsconn.Conn {
+	u.connMu.Lock()
+	defer u.connMu.Unlock()
+	old := u.Conn
+	u.Conn = conn
+	return old
 }
 
 func (u *UserGameLocation) MarshalJSON() ([]byte, error) {
@@ -2,15 +2,14 @@ package model
 
 import (
 	"encoding/json"
-	"sync"
 
-	"github.com/gorilla/websocket"
+	"github.com/sako0/minigame-space-api/app/wsconn"
 	"gorm.io/gorm"
 )
 
 type UserLocation struct {
 	gorm.Model
-	UserID uint
+	UserID uint `gorm:"uniqueIndex"`
 	User   *User
 	AreaID uint `gorm:"default:null"`
 	Area   *Area
@@ -18,11 +17,15 @@ type UserLocation struct {
 	Room   *Room
 	XAxis  int
 	YAxis  int
-	Conn   *websocket.Conn `gorm:"-"`
-	Mutex  sync.Mutex      `gorm:"-"`
+	Conn   *wsconn.Conn `gorm:"-"`
+	// 参加中のエリアの移動のルールと、移動量の判定に使う状態
+	MovementRule  MovementRule  `gorm:"-"`
+	MovementState MovementState `gorm:"-"`
+	// パスコードや招待コードを確認済みのルーム。private などのルームに参加し直す時は確認を省く
+	AdmittedRoomID uint `gorm:"-"`
 }
 
-func NewUserLocationByConn(conn *websocket.Conn) *UserLocation {
+func NewUserLocationByConn(conn *wsconn.Conn) *UserLocation {
 	return &UserLocation{Conn: conn}
 }
 
@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+type AreaRepository interface {
+	GetArea(areaId uint) (*model.Area, bool, error)
+	GetAreas(offset int, limit int) ([]*model.Area, int64, error)
+	AddArea(area *model.Area) error
+	UpdateArea(area *model.Area) error
+	RemoveArea(areaId uint) error
+}
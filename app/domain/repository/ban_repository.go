@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+type BanRepository interface {
+	GetBan(banId uint) (*model.Ban, bool, error)
+	GetBans(offset int, limit int) ([]*model.Ban, int64, error)
+	AddBan(ban *model.Ban) error
+	RemoveBan(banId uint) error
+	// areaId と roomId に適用される kind の制限のうち now の時点で有効なもの
+	FindActiveBan(userId uint, kind string, areaId uint, roomId uint, now time.Time) (*model.Ban, bool, error)
+}
@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+// 履歴の取得はいずれも新しいものから limit 件を古い順に並べて返す
+type ChatMessageRepository interface {
+	AddChatMessage(chatMessage *model.ChatMessage) error
+	GetRecentChatMessagesByAreaId(areaId uint, limit int) ([]*model.ChatMessage, error)
+	GetRecentChatMessagesByRoomId(roomId uint, limit int) ([]*model.ChatMessage, error)
+	// userId が送信元か宛先の direct のメッセージ
+	GetRecentDirectChatMessages(userId uint, limit int) ([]*model.ChatMessage, error)
+}
@@ -0,0 +1,12 @@
+package repository
+
+import (
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+type InMemoryGameSessionRepository interface {
+	Store(session *model.GameSession)
+	FindByToken(token string) (*model.GameSession, bool)
+	FindByUserID(userID uint) (*model.GameSession, bool)
+	Delete(token string)
+}
@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/wsconn"
+)
+
+// エリアのルーム一覧の更新を購読している接続
+type InMemoryLobbySubscriptionRepository interface {
+	Subscribe(areaID uint, userLocation *model.UserLocation)
+	Unsubscribe(conn *wsconn.Conn)
+	GetSubscribersByAreaId(areaID uint) []*model.UserLocation
+	Count() int
+}
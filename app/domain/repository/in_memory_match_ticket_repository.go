@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+type InMemoryMatchTicketRepository interface {
+	Store(ticket *model.MatchTicket)
+	Find(userID uint) (*model.MatchTicket, bool)
+	Delete(userID uint) (*model.MatchTicket, bool)
+	// 古い順に返す
+	GetAllMatchTickets() []*model.MatchTicket
+}
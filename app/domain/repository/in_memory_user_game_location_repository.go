@@ -6,6 +6,7 @@ import (
 
 type InMemoryUserGameLocationRepository interface {
 	Store(userGameLocation *model.UserGameLocation)
+	StoreIfRoomNotFull(userGameLocation *model.UserGameLocation, maxParticipant int) bool
 	Find(userID uint) (*model.UserGameLocation, bool)
 	Delete(userID uint)
 	Update(userGameLocation *model.UserGameLocation)
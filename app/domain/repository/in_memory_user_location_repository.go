@@ -6,9 +6,13 @@ import (
 
 type InMemoryUserLocationRepository interface {
 	Store(userLocation *model.UserLocation)
+	StoreIfAreaNotFull(userLocation *model.UserLocation, maxParticipant int) bool
+	StoreIfRoomNotFull(userLocation *model.UserLocation, maxParticipant int) bool
 	Find(userID uint) (*model.UserLocation, bool)
 	Delete(userID uint)
 	Update(userLocation *model.UserLocation)
 	GetAllUserLocationsByAreaId(areaId uint) []*model.UserLocation
+	// エリア内で (x, y) からの距離が radius 以下のユーザーを返す
+	GetUserLocationsInRange(areaId uint, x int, y int, radius int) []*model.UserLocation
 	GetAllUserLocationsByRoomId(roomId uint) []*model.UserLocation
 }
@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+// 満員のルームに参加しようとしたユーザーの待ち行列
+type InMemoryWaitingQueueRepository interface {
+	Enqueue(roomID uint, userGameLocation *model.UserGameLocation) int
+	PushFront(roomID uint, userGameLocation *model.UserGameLocation)
+	Dequeue(roomID uint) (*model.UserGameLocation, bool)
+	Remove(userID uint) bool
+}
@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+type RoomInviteRepository interface {
+	GetRoomInvite(inviteId uint) (*model.RoomInvite, bool, error)
+	GetRoomInvitesByRoomId(roomId uint) ([]*model.RoomInvite, error)
+	AddRoomInvite(invite *model.RoomInvite) error
+	RemoveRoomInvite(inviteId uint) error
+	// roomId の code が now の時点で使える場合だけ使用回数を 1 増やす。使えたかを返す
+	UseRoomInvite(roomId uint, code string, now time.Time) (*model.RoomInvite, bool, error)
+	// 参加できなかった場合に UseRoomInvite で増やした使用回数を戻す
+	ReleaseRoomInvite(inviteId uint) error
+}
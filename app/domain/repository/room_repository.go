@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+type RoomRepository interface {
+	GetRoom(roomId uint) (*model.Room, bool, error)
+	GetRooms(offset int, limit int) ([]*model.Room, int64, error)
+	GetRoomsByAreaId(areaId uint, offset int, limit int) ([]*model.Room, int64, error)
+	GetAllRoomsByAreaId(areaId uint) ([]*model.Room, error)
+	GetAllRoomsByAreaIdAndRoomTypeId(areaId uint, roomTypeId uint) ([]*model.Room, error)
+	CountRoomsByAreaId(areaId uint) (int64, error)
+	CountRoomsByRoomTypeId(roomTypeId uint) (int64, error)
+	AddRoom(room *model.Room) error
+	// status は更新しない。状態の変更には UpdateRoomStatus を使う
+	UpdateRoom(room *model.Room) error
+	// 現在の状態が from の場合だけ to に変更する。変更できたかを返す
+	UpdateRoomStatus(roomId uint, from model.RoomStatus, to model.RoomStatus) (bool, error)
+	RemoveRoom(roomId uint) error
+}
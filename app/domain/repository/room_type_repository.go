@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+type RoomTypeRepository interface {
+	GetRoomType(roomTypeId uint) (*model.RoomType, bool, error)
+	GetRoomTypes(offset int, limit int) ([]*model.RoomType, int64, error)
+	AddRoomType(roomType *model.RoomType) error
+	UpdateRoomType(roomType *model.RoomType) error
+	RemoveRoomType(roomTypeId uint) error
+}
@@ -8,6 +8,8 @@ type UserGameLocationRepository interface {
 	GetUserGameLocation(userId uint) (*model.UserGameLocation, bool, error)
 	AddUserGameLocation(userLocation *model.UserGameLocation) error
 	RemoveUserGameLocation(userId uint) error
+	// user_id が同じ行があれば位置を更新し、無ければ追加する
+	UpsertUserGameLocations(userGameLocations []*model.UserGameLocation) error
 	UpdateUserGameLocation(userGameLocation *model.UserGameLocation) error
 	GetAllUserGameLocationsByRoomId(roomId uint) ([]*model.UserGameLocation, bool, error)
 }
@@ -8,6 +8,8 @@ type UserLocationRepository interface {
 	GetUserLocation(userId uint) (*model.UserLocation, bool, error)
 	AddUserLocation(userLocation *model.UserLocation) error
 	RemoveUserLocation(userId uint) error
+	// user_id が同じ行があれば位置を更新し、無ければ追加する
+	UpsertUserLocations(userLocations []*model.UserLocation) error
 	UpdateUserLocation(userLocation *model.UserLocation) error
 	GetAllUserLocationsByAreaId(areaId uint) ([]*model.UserLocation, bool, error)
 	GetAllUserLocationsByRoomId(roomId uint) ([]*model.UserLocation, bool, error)
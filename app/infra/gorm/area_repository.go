@@ -0,0 +1,73 @@
+package gorm
+
+import (
+	"fmt"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AreaRepository struct {
+	db *gorm.DB
+}
+
+func NewAreaRepository(db *gorm.DB) repository.AreaRepository {
+	return &AreaRepository{db: db}
+}
+
+func (r *AreaRepository) GetArea(areaId uint) (*model.Area, bool, error) {
+	area := &model.Area{}
+	result := r.db.First(area, areaId)
+
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil, false, nil
+	}
+
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("GetArea: %v", result.Error)
+	}
+
+	return area, true, nil
+}
+
+func (r *AreaRepository) GetAreas(offset int, limit int) ([]*model.Area, int64, error) {
+	var total int64
+	result := r.db.Model(&model.Area{}).Count(&total)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("GetAreas: %v", result.Error)
+	}
+
+	areas := []*model.Area{}
+	result = r.db.Order("id").Offset(offset).Limit(limit).Find(&areas)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("GetAreas: %v", result.Error)
+	}
+
+	return areas, total, nil
+}
+
+func (r *AreaRepository) AddArea(area *model.Area) error {
+	result := r.db.Create(area)
+	if result.Error != nil {
+		return fmt.Errorf("AddArea: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *AreaRepository) UpdateArea(area *model.Area) error {
+	result := r.db.Omit(clause.Associations).Save(area)
+	if result.Error != nil {
+		return fmt.Errorf("UpdateArea: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *AreaRepository) RemoveArea(areaId uint) error {
+	result := r.db.Delete(&model.Area{}, areaId)
+	if result.Error != nil {
+		return fmt.Errorf("RemoveArea: %v", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package gorm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"gorm.io/gorm"
+)
+
+type BanRepository struct {
+	db *gorm.DB
+}
+
+func NewBanRepository(db *gorm.DB) repository.BanRepository {
+	return &BanRepository{db: db}
+}
+
+func (r *BanRepository) GetBan(banId uint) (*model.Ban, bool, error) {
+	ban := &model.Ban{}
+	result := r.db.First(ban, banId)
+
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil, false, nil
+	}
+
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("GetBan: %v", result.Error)
+	}
+
+	return ban, true, nil
+}
+
+func (r *BanRepository) GetBans(offset int, limit int) ([]*model.Ban, int64, error) {
+	var total int64
+	result := r.db.Model(&model.Ban{}).Count(&total)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("GetBans: %v", result.Error)
+	}
+
+	bans := []*model.Ban{}
+	result = r.db.Order("id DESC").Offset(offset).Limit(limit).Find(&bans)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("GetBans: %v", result.Error)
+	}
+
+	return bans, total, nil
+}
+
+func (r *BanRepository) AddBan(ban *model.Ban) error {
+	result := r.db.Create(ban)
+	if result.Error != nil {
+		return fmt.Errorf("AddBan: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *BanRepository) RemoveBan(banId uint) error {
+	result := r.db.Delete(&model.Ban{}, banId)
+	if result.Error != nil {
+		return fmt.Errorf("RemoveBan: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *BanRepository) FindActiveBan(userId uint, kind string, areaId uint, roomId uint, now time.Time) (*model.Ban, bool, error) {
+	// すべてに適用される制限と、エリア・ルームを指定した制限
+	scope := r.db.Where("area_id = 0 AND room_id = 0")
+	if areaId != 0 {
+		scope = scope.Or("area_id = ? AND room_id = 0", areaId)
+	}
+	if roomId != 0 {
+		scope = scope.Or("room_id = ?", roomId)
+	}
+	ban := &model.Ban{}
+	result := r.db.Where("user_id = ? AND kind = ?", userId, kind).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Where(scope).
+		Order("id DESC").
+		First(ban)
+
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil, false, nil
+	}
+
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("FindActiveBan: %v", result.Error)
+	}
+
+	return ban, true, nil
+}
@@ -0,0 +1,62 @@
+package gorm
+
+import (
+	"fmt"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"gorm.io/gorm"
+)
+
+type ChatMessageRepository struct {
+	db *gorm.DB
+}
+
+func NewChatMessageRepository(db *gorm.DB) repository.ChatMessageRepository {
+	return &ChatMessageRepository{db: db}
+}
+
+func (r *ChatMessageRepository) AddChatMessage(chatMessage *model.ChatMessage) error {
+	result := r.db.Create(chatMessage)
+	if result.Error != nil {
+		return fmt.Errorf("AddChatMessage: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *ChatMessageRepository) GetRecentChatMessagesByAreaId(areaId uint, limit int) ([]*model.ChatMessage, error) {
+	chatMessages, err := r.findRecent(r.db.Where("scope = ? AND area_id = ?", model.ChatScopeArea, areaId), limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetRecentChatMessagesByAreaId: %v", err)
+	}
+	return chatMessages, nil
+}
+
+func (r *ChatMessageRepository) GetRecentChatMessagesByRoomId(roomId uint, limit int) ([]*model.ChatMessage, error) {
+	chatMessages, err := r.findRecent(r.db.Where("scope = ? AND room_id = ?", model.ChatScopeRoom, roomId), limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetRecentChatMessagesByRoomId: %v", err)
+	}
+	return chatMessages, nil
+}
+
+func (r *ChatMessageRepository) GetRecentDirectChatMessages(userId uint, limit int) ([]*model.ChatMessage, error) {
+	chatMessages, err := r.findRecent(r.db.Where("scope = ? AND (from_user_id = ? OR to_user_id = ?)", model.ChatScopeDirect, userId, userId), limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetRecentDirectChatMessages: %v", err)
+	}
+	return chatMessages, nil
+}
+
+// 新しい順に limit 件を取得して古い順に並べ替える
+func (r *ChatMessageRepository) findRecent(query *gorm.DB, limit int) ([]*model.ChatMessage, error) {
+	chatMessages := []*model.ChatMessage{}
+	result := query.Order("id DESC").Limit(limit).Find(&chatMessages)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for i, j := 0, len(chatMessages)-1; i < j; i, j = i+1, j-1 {
+		chatMessages[i], chatMessages[j] = chatMessages[j], chatMessages[i]
+	}
+	return chatMessages, nil
+}
@@ -0,0 +1,90 @@
+package gorm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"gorm.io/gorm"
+)
+
+type RoomInviteRepository struct {
+	db *gorm.DB
+}
+
+func NewRoomInviteRepository(db *gorm.DB) repository.RoomInviteRepository {
+	return &RoomInviteRepository{db: db}
+}
+
+func (r *RoomInviteRepository) GetRoomInvite(inviteId uint) (*model.RoomInvite, bool, error) {
+	invite := &model.RoomInvite{}
+	result := r.db.First(invite, inviteId)
+
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil, false, nil
+	}
+
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("GetRoomInvite: %v", result.Error)
+	}
+
+	return invite, true, nil
+}
+
+func (r *RoomInviteRepository) GetRoomInvitesByRoomId(roomId uint) ([]*model.RoomInvite, error) {
+	invites := []*model.RoomInvite{}
+	result := r.db.Where("room_id = ?", roomId).Order("id").Find(&invites)
+	if result.Error != nil {
+		return nil, fmt.Errorf("GetRoomInvitesByRoomId: %v", result.Error)
+	}
+	return invites, nil
+}
+
+func (r *RoomInviteRepository) AddRoomInvite(invite *model.RoomInvite) error {
+	result := r.db.Create(invite)
+	if result.Error != nil {
+		return fmt.Errorf("AddRoomInvite: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *RoomInviteRepository) RemoveRoomInvite(inviteId uint) error {
+	result := r.db.Delete(&model.RoomInvite{}, inviteId)
+	if result.Error != nil {
+		return fmt.Errorf("RemoveRoomInvite: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *RoomInviteRepository) UseRoomInvite(roomId uint, code string, now time.Time) (*model.RoomInvite, bool, error) {
+	// 同時に使われても max_uses を超えないよう、条件付きの UPDATE で回数を増やす
+	result := r.db.Model(&model.RoomInvite{}).
+		Where("room_id = ? AND code = ?", roomId, code).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Where("max_uses = 0 OR uses < max_uses").
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("UseRoomInvite: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, false, nil
+	}
+
+	invite := &model.RoomInvite{}
+	result = r.db.Where("room_id = ? AND code = ?", roomId, code).First(invite)
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("UseRoomInvite: %v", result.Error)
+	}
+	return invite, true, nil
+}
+
+func (r *RoomInviteRepository) ReleaseRoomInvite(inviteId uint) error {
+	result := r.db.Model(&model.RoomInvite{}).
+		Where("id = ? AND uses > 0", inviteId).
+		Update("uses", gorm.Expr("uses - 1"))
+	if result.Error != nil {
+		return fmt.Errorf("ReleaseRoomInvite: %v", result.Error)
+	}
+	return nil
+}
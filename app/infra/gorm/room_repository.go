@@ -0,0 +1,133 @@
+package gorm
+
+import (
+	"fmt"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RoomRepository struct {
+	db *gorm.DB
+}
+
+func NewRoomRepository(db *gorm.DB) repository.RoomRepository {
+	return &RoomRepository{db: db}
+}
+
+func (r *RoomRepository) GetRoom(roomId uint) (*model.Room, bool, error) {
+	room := &model.Room{}
+	result := r.db.Preload("RoomType").First(room, roomId)
+
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil, false, nil
+	}
+
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("GetRoom: %v", result.Error)
+	}
+
+	return room, true, nil
+}
+
+func (r *RoomRepository) GetRooms(offset int, limit int) ([]*model.Room, int64, error) {
+	var total int64
+	result := r.db.Model(&model.Room{}).Count(&total)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("GetRooms: %v", result.Error)
+	}
+
+	rooms := []*model.Room{}
+	result = r.db.Preload("RoomType").Order("id").Offset(offset).Limit(limit).Find(&rooms)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("GetRooms: %v", result.Error)
+	}
+
+	return rooms, total, nil
+}
+
+func (r *RoomRepository) GetRoomsByAreaId(areaId uint, offset int, limit int) ([]*model.Room, int64, error) {
+	total, err := r.CountRoomsByAreaId(areaId)
+	if err != nil {
+		return nil, 0, fmt.Errorf("GetRoomsByAreaId: %v", err)
+	}
+
+	rooms := []*model.Room{}
+	result := r.db.Preload("RoomType").Where("area_id = ?", areaId).Order("id").Offset(offset).Limit(limit).Find(&rooms)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("GetRoomsByAreaId: %v", result.Error)
+	}
+
+	return rooms, total, nil
+}
+
+func (r *RoomRepository) GetAllRoomsByAreaId(areaId uint) ([]*model.Room, error) {
+	rooms := []*model.Room{}
+	result := r.db.Preload("RoomType").Where("area_id = ?", areaId).Order("id").Find(&rooms)
+	if result.Error != nil {
+		return nil, fmt.Errorf("GetAllRoomsByAreaId: %v", result.Error)
+	}
+	return rooms, nil
+}
+
+func (r *RoomRepository) GetAllRoomsByAreaIdAndRoomTypeId(areaId uint, roomTypeId uint) ([]*model.Room, error) {
+	rooms := []*model.Room{}
+	result := r.db.Preload("RoomType").Where("area_id = ? AND room_type_id = ?", areaId, roomTypeId).Order("id").Find(&rooms)
+	if result.Error != nil {
+		return nil, fmt.Errorf("GetAllRoomsByAreaIdAndRoomTypeId: %v", result.Error)
+	}
+	return rooms, nil
+}
+
+func (r *RoomRepository) CountRoomsByAreaId(areaId uint) (int64, error) {
+	var count int64
+	result := r.db.Model(&model.Room{}).Where("area_id = ?", areaId).Count(&count)
+	if result.Error != nil {
+		return 0, fmt.Errorf("CountRoomsByAreaId: %v", result.Error)
+	}
+	return count, nil
+}
+
+func (r *RoomRepository) CountRoomsByRoomTypeId(roomTypeId uint) (int64, error) {
+	var count int64
+	result := r.db.Model(&model.Room{}).Where("room_type_id = ?", roomTypeId).Count(&count)
+	if result.Error != nil {
+		return 0, fmt.Errorf("CountRoomsByRoomTypeId: %v", result.Error)
+	}
+	return count, nil
+}
+
+func (r *RoomRepository) AddRoom(room *model.Room) error {
+	result := r.db.Omit(clause.Associations).Create(room)
+	if result.Error != nil {
+		return fmt.Errorf("AddRoom: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *RoomRepository) UpdateRoom(room *model.Room) error {
+	// 読み込んでから保存するまでの間に遷移した状態を古い値で上書きしないよう、status は書き込まない
+	result := r.db.Omit(clause.Associations, "Status").Save(room)
+	if result.Error != nil {
+		return fmt.Errorf("UpdateRoom: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *RoomRepository) UpdateRoomStatus(roomId uint, from model.RoomStatus, to model.RoomStatus) (bool, error) {
+	result := r.db.Model(&model.Room{}).Where("id = ? AND status = ?", roomId, from).Update("status", to)
+	if result.Error != nil {
+		return false, fmt.Errorf("UpdateRoomStatus: %v", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *RoomRepository) RemoveRoom(roomId uint) error {
+	result := r.db.Delete(&model.Room{}, roomId)
+	if result.Error != nil {
+		return fmt.Errorf("RemoveRoom: %v", result.Error)
+	}
+	return nil
+}
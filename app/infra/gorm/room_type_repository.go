@@ -0,0 +1,73 @@
+package gorm
+
+import (
+	"fmt"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RoomTypeRepository struct {
+	db *gorm.DB
+}
+
+func NewRoomTypeRepository(db *gorm.DB) repository.RoomTypeRepository {
+	return &RoomTypeRepository{db: db}
+}
+
+func (r *RoomTypeRepository) GetRoomType(roomTypeId uint) (*model.RoomType, bool, error) {
+	roomType := &model.RoomType{}
+	result := r.db.First(roomType, roomTypeId)
+
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil, false, nil
+	}
+
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("GetRoomType: %v", result.Error)
+	}
+
+	return roomType, true, nil
+}
+
+func (r *RoomTypeRepository) GetRoomTypes(offset int, limit int) ([]*model.RoomType, int64, error) {
+	var total int64
+	result := r.db.Model(&model.RoomType{}).Count(&total)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("GetRoomTypes: %v", result.Error)
+	}
+
+	roomTypes := []*model.RoomType{}
+	result = r.db.Order("id").Offset(offset).Limit(limit).Find(&roomTypes)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("GetRoomTypes: %v", result.Error)
+	}
+
+	return roomTypes, total, nil
+}
+
+func (r *RoomTypeRepository) AddRoomType(roomType *model.RoomType) error {
+	result := r.db.Create(roomType)
+	if result.Error != nil {
+		return fmt.Errorf("AddRoomType: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *RoomTypeRepository) UpdateRoomType(roomType *model.RoomType) error {
+	result := r.db.Omit(clause.Associations).Save(roomType)
+	if result.Error != nil {
+		return fmt.Errorf("UpdateRoomType: %v", result.Error)
+	}
+	return nil
+}
+
+func (r *RoomTypeRepository) RemoveRoomType(roomTypeId uint) error {
+	result := r.db.Delete(&model.RoomType{}, roomTypeId)
+	if result.Error != nil {
+		return fmt.Errorf("RemoveRoomType: %v", result.Error)
+	}
+	return nil
+}
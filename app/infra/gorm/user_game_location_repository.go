@@ -6,8 +6,12 @@ import (
 	"github.com/sako0/minigame-space-api/app/domain/model"
 	"github.com/sako0/minigame-space-api/app/domain/repository"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// 一度の INSERT でまとめて書き込む行数
+const upsertBatchSize = 100
+
 type UserGameLocationRepository struct {
 	db *gorm.DB
 }
@@ -69,3 +73,15 @@ func (r *UserGameLocationRepository) GetAllUserGameLocationsByRoomId(roomId uint
 
 	return userGameLocations, true, nil
 }
+
+// 位置だけをまとめて書き込む。行が無いユーザーは追加する
+func (r *UserGameLocationRepository) UpsertUserGameLocations(userGameLocations []*model.UserGameLocation) error {
+	result := r.db.Omit(clause.Associations).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"room_id", "x_axis", "y_axis", "status", "updated_at"}),
+	}).CreateInBatches(userGameLocations, upsertBatchSize)
+	if result.Error != nil {
+		return fmt.Errorf("UpsertUserGameLocations: %v", result.Error)
+	}
+	return nil
+}
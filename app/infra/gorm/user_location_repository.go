@@ -6,6 +6,7 @@ import (
 	"github.com/sako0/minigame-space-api/app/domain/model"
 	"github.com/sako0/minigame-space-api/app/domain/repository"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserLocationRepository struct {
@@ -84,3 +85,15 @@ func (r *UserLocationRepository) GetAllUserLocationsByRoomId(roomId uint) ([]*mo
 
 	return userLocations, true, nil
 }
+
+// 位置だけをまとめて書き込む。行が無いユーザーは追加する
+func (r *UserLocationRepository) UpsertUserLocations(userLocations []*model.UserLocation) error {
+	result := r.db.Omit(clause.Associations).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"x_axis", "y_axis", "updated_at"}),
+	}).CreateInBatches(userLocations, upsertBatchSize)
+	if result.Error != nil {
+		return fmt.Errorf("UpsertUserLocations: %v", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package in_memory
+
+import (
+	"sync"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+)
+
+type InMemoryGameSessionRepository struct {
+	store  map[string]*model.GameSession // Key: token, Value: GameSession
+	byUser map[uint]string               // Key: userID, Value: token
+	mu     sync.Mutex
+}
+
+func NewInMemoryGameSessionRepository() repository.InMemoryGameSessionRepository {
+	return &InMemoryGameSessionRepository{
+		store:  make(map[string]*model.GameSession),
+		byUser: make(map[uint]string),
+	}
+}
+
+func (r *InMemoryGameSessionRepository) Store(session *model.GameSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 1 ユーザーにつき有効なセッションは 1 つだけ
+	if token, ok := r.byUser[session.UserID]; ok {
+		delete(r.store, token)
+	}
+	r.store[session.Token] = session
+	r.byUser[session.UserID] = session.Token
+}
+
+func (r *InMemoryGameSessionRepository) FindByToken(token string) (*model.GameSession, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.store[token]
+	return session, ok
+}
+
+func (r *InMemoryGameSessionRepository) FindByUserID(userID uint) (*model.GameSession, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.byUser[userID]
+	if !ok {
+		return nil, false
+	}
+	session, ok := r.store[token]
+	return session, ok
+}
+
+func (r *InMemoryGameSessionRepository) Delete(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.store[token]
+	if !ok {
+		return
+	}
+	delete(r.store, token)
+	if r.byUser[session.UserID] == token {
+		delete(r.byUser, session.UserID)
+	}
+}
@@ -0,0 +1,59 @@
+package in_memory
+
+import (
+	"sync"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"github.com/sako0/minigame-space-api/app/wsconn"
+)
+
+type lobbySubscription struct {
+	areaID       uint
+	userLocation *model.UserLocation
+}
+
+type InMemoryLobbySubscriptionRepository struct {
+	store map[*wsconn.Conn]*lobbySubscription // Key: 接続。同じユーザーでも接続ごとに別のエリアを購読できる
+	mu    sync.Mutex
+}
+
+func NewInMemoryLobbySubscriptionRepository() repository.InMemoryLobbySubscriptionRepository {
+	return &InMemoryLobbySubscriptionRepository{
+		store: make(map[*wsconn.Conn]*lobbySubscription),
+	}
+}
+
+func (r *InMemoryLobbySubscriptionRepository) Subscribe(areaID uint, userLocation *model.UserLocation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.store[userLocation.Conn] = &lobbySubscription{areaID: areaID, userLocation: userLocation}
+}
+
+func (r *InMemoryLobbySubscriptionRepository) Unsubscribe(conn *wsconn.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.store, conn)
+}
+
+func (r *InMemoryLobbySubscriptionRepository) GetSubscribersByAreaId(areaID uint) []*model.UserLocation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	userLocations := make([]*model.UserLocation, 0, len(r.store))
+	for _, subscription := range r.store {
+		if subscription.areaID == areaID {
+			userLocations = append(userLocations, subscription.userLocation)
+		}
+	}
+	return userLocations
+}
+
+func (r *InMemoryLobbySubscriptionRepository) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.store)
+}
@@ -0,0 +1,57 @@
+package in_memory
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+)
+
+type InMemoryMatchTicketRepository struct {
+	store map[uint]*model.MatchTicket // Key: userID, Value: MatchTicket
+	mu    sync.Mutex
+}
+
+func NewInMemoryMatchTicketRepository() repository.InMemoryMatchTicketRepository {
+	return &InMemoryMatchTicketRepository{
+		store: make(map[uint]*model.MatchTicket),
+	}
+}
+
+func (r *InMemoryMatchTicketRepository) Store(ticket *model.MatchTicket) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store[ticket.UserGameLocation.UserID] = ticket
+}
+
+func (r *InMemoryMatchTicketRepository) Find(userID uint) (*model.MatchTicket, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ticket, ok := r.store[userID]
+	return ticket, ok
+}
+
+func (r *InMemoryMatchTicketRepository) Delete(userID uint) (*model.MatchTicket, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ticket, ok := r.store[userID]
+	delete(r.store, userID)
+	return ticket, ok
+}
+
+func (r *InMemoryMatchTicketRepository) GetAllMatchTickets() []*model.MatchTicket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tickets := make([]*model.MatchTicket, 0, len(r.store))
+	for _, ticket := range r.store {
+		tickets = append(tickets, ticket)
+	}
+	sort.Slice(tickets, func(i, j int) bool {
+		return tickets[i].CreatedAt.Before(tickets[j].CreatedAt)
+	})
+	return tickets
+}
@@ -24,6 +24,26 @@ func (r *InMemoryUserRoomLocationRepository) Store(userRoomLocation *model.UserG
 	r.store[userRoomLocation.UserID] = userRoomLocation
 }
 
+// 同じルームにいる他のユーザーが maxParticipant 未満の場合だけ保存する。maxParticipant が 0 以下なら上限なし
+func (r *InMemoryUserRoomLocationRepository) StoreIfRoomNotFull(userRoomLocation *model.UserGameLocation, maxParticipant int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if maxParticipant > 0 {
+		count := 0
+		for userID, other := range r.store {
+			if userID != userRoomLocation.UserID && other.RoomID == userRoomLocation.RoomID {
+				count++
+			}
+		}
+		if count >= maxParticipant {
+			return false
+		}
+	}
+	r.store[userRoomLocation.UserID] = userRoomLocation
+	return true
+}
+
 func (r *InMemoryUserRoomLocationRepository) Find(userID uint) (*model.UserGameLocation, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -7,21 +7,148 @@ import (
 	"github.com/sako0/minigame-space-api/app/domain/repository"
 )
 
+// 範囲検索に使うグリッドの 1 マスの大きさ
+const gridCellSize = 128
+
+type gridCell struct {
+	x int
+	y int
+}
+
+func cellOf(x int, y int) gridCell {
+	return gridCell{x: floorDiv(x, gridCellSize), y: floorDiv(y, gridCellSize)}
+}
+
+// 負の座標でも同じ幅のマスになるよう切り捨てで割る
+func floorDiv(a int, b int) int {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// 索引に登録した時点のエリアと位置
+type indexEntry struct {
+	areaID uint
+	x      int
+	y      int
+	cell   gridCell
+}
+
 type InMemoryUserLocationRepository struct {
 	store map[uint]*model.UserLocation // Key: userID, Value: UserLocation
-	mu    sync.Mutex
+	// エリアごとの索引。エリア全体の取得と範囲検索でストア全体を走査しないようにする
+	byArea  map[uint]map[uint]*model.UserLocation              // Key: areaID, userID
+	grid    map[uint]map[gridCell]map[uint]*model.UserLocation // Key: areaID, マス, userID
+	indexed map[uint]indexEntry                                // Key: userID
+	mu      sync.Mutex
 }
 
 func NewInMemoryUserLocationRepository() repository.InMemoryUserLocationRepository {
 	return &InMemoryUserLocationRepository{
-		store: make(map[uint]*model.UserLocation),
+		store:   make(map[uint]*model.UserLocation),
+		byArea:  make(map[uint]map[uint]*model.UserLocation),
+		grid:    make(map[uint]map[gridCell]map[uint]*model.UserLocation),
+		indexed: make(map[uint]indexEntry),
 	}
 }
 
 func (r *InMemoryUserLocationRepository) Store(userLocation *model.UserLocation) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.storeLocked(userLocation)
+}
+
+func (r *InMemoryUserLocationRepository) storeLocked(userLocation *model.UserLocation) {
 	r.store[userLocation.UserID] = userLocation
+	r.unindexLocked(userLocation.UserID)
+	if userLocation.AreaID == 0 {
+		return
+	}
+	entry := indexEntry{areaID: userLocation.AreaID, x: userLocation.XAxis, y: userLocation.YAxis, cell: cellOf(userLocation.XAxis, userLocation.YAxis)}
+	r.indexed[userLocation.UserID] = entry
+	area, ok := r.byArea[entry.areaID]
+	if !ok {
+		area = make(map[uint]*model.UserLocation)
+		r.byArea[entry.areaID] = area
+	}
+	area[userLocation.UserID] = userLocation
+	cells, ok := r.grid[entry.areaID]
+	if !ok {
+		cells = make(map[gridCell]map[uint]*model.UserLocation)
+		r.grid[entry.areaID] = cells
+	}
+	cell, ok := cells[entry.cell]
+	if !ok {
+		cell = make(map[uint]*model.UserLocation)
+		cells[entry.cell] = cell
+	}
+	cell[userLocation.UserID] = userLocation
+}
+
+func (r *InMemoryUserLocationRepository) unindexLocked(userID uint) {
+	entry, ok := r.indexed[userID]
+	if !ok {
+		return
+	}
+	delete(r.indexed, userID)
+	if area, ok := r.byArea[entry.areaID]; ok {
+		delete(area, userID)
+		if len(area) == 0 {
+			delete(r.byArea, entry.areaID)
+		}
+	}
+	if cells, ok := r.grid[entry.areaID]; ok {
+		if cell, ok := cells[entry.cell]; ok {
+			delete(cell, userID)
+			if len(cell) == 0 {
+				delete(cells, entry.cell)
+			}
+		}
+		if len(cells) == 0 {
+			delete(r.grid, entry.areaID)
+		}
+	}
+}
+
+// 同じエリアにいる他のユーザーが maxParticipant 未満の場合だけ保存する。maxParticipant が 0 以下なら上限なし
+func (r *InMemoryUserLocationRepository) StoreIfAreaNotFull(userLocation *model.UserLocation, maxParticipant int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if maxParticipant > 0 {
+		area := r.byArea[userLocation.AreaID]
+		count := len(area)
+		if _, ok := area[userLocation.UserID]; ok {
+			count--
+		}
+		if count >= maxParticipant {
+			return false
+		}
+	}
+	r.storeLocked(userLocation)
+	return true
+}
+
+// 同じルームにいる他のユーザーが maxParticipant 未満の場合だけ保存する。maxParticipant が 0 以下なら上限なし
+func (r *InMemoryUserLocationRepository) StoreIfRoomNotFull(userLocation *model.UserLocation, maxParticipant int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if maxParticipant > 0 {
+		count := 0
+		for userID, other := range r.store {
+			if userID != userLocation.UserID && other.RoomID == userLocation.RoomID {
+				count++
+			}
+		}
+		if count >= maxParticipant {
+			return false
+		}
+	}
+	r.storeLocked(userLocation)
+	return true
 }
 
 func (r *InMemoryUserLocationRepository) Find(userID uint) (*model.UserLocation, bool) {
@@ -37,23 +164,66 @@ func (r *InMemoryUserLocationRepository) Delete(userID uint) {
 	defer r.mu.Unlock()
 
 	delete(r.store, userID)
+	r.unindexLocked(userID)
 }
 
 func (r *InMemoryUserLocationRepository) Update(userLocation *model.UserLocation) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.store[userLocation.UserID] = userLocation
+	r.storeLocked(userLocation)
 }
 
 func (r *InMemoryUserLocationRepository) GetAllUserLocationsByAreaId(areaId uint) []*model.UserLocation {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	userLocations := make([]*model.UserLocation, 0, len(r.store))
-	for _, userLocation := range r.store {
-		if userLocation.AreaID == areaId {
-			userLocations = append(userLocations, userLocation)
+	area := r.byArea[areaId]
+	userLocations := make([]*model.UserLocation, 0, len(area))
+	for _, userLocation := range area {
+		userLocations = append(userLocations, userLocation)
+	}
+	return userLocations
+}
+
+// エリア内で (x, y) からの距離が radius 以下のユーザーを返す。保存された時点の位置で判定する
+func (r *InMemoryUserLocationRepository) GetUserLocationsInRange(areaId uint, x int, y int, radius int) []*model.UserLocation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cells := r.grid[areaId]
+	userLocations := []*model.UserLocation{}
+	if len(cells) == 0 {
+		return userLocations
+	}
+	minCell := cellOf(x-radius, y-radius)
+	maxCell := cellOf(x+radius, y+radius)
+	// 大きな座標でも桁あふれしないよう int64 で比べる
+	radiusSquared := int64(radius) * int64(radius)
+	inRange := func(cell gridCell, users map[uint]*model.UserLocation) {
+		if cell.x < minCell.x || cell.x > maxCell.x || cell.y < minCell.y || cell.y > maxCell.y {
+			return
+		}
+		for userID, userLocation := range users {
+			entry := r.indexed[userID]
+			dx := int64(entry.x) - int64(x)
+			dy := int64(entry.y) - int64(y)
+			if dx*dx+dy*dy <= radiusSquared {
+				userLocations = append(userLocations, userLocation)
+			}
+		}
+	}
+	// 範囲のマスの数よりユーザーのいるマスの方が少なければ、そちらを走査する
+	if (maxCell.x-minCell.x+1)*(maxCell.y-minCell.y+1) > len(cells) {
+		for cell, users := range cells {
+			inRange(cell, users)
+		}
+		return userLocations
+	}
+	for cx := minCell.x; cx <= maxCell.x; cx++ {
+		for cy := minCell.y; cy <= maxCell.y; cy++ {
+			cell := gridCell{x: cx, y: cy}
+			inRange(cell, cells[cell])
 		}
 	}
 	return userLocations
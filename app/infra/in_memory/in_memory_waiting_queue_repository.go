@@ -0,0 +1,79 @@
+package in_memory
+
+import (
+	"sync"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+)
+
+type InMemoryWaitingQueueRepository struct {
+	queues map[uint][]*model.UserGameLocation // Key: roomID, Value: 待っている順の UserGameLocation
+	mu     sync.Mutex
+}
+
+func NewInMemoryWaitingQueueRepository() repository.InMemoryWaitingQueueRepository {
+	return &InMemoryWaitingQueueRepository{
+		queues: make(map[uint][]*model.UserGameLocation),
+	}
+}
+
+// 末尾に追加し、待ち順 (1 始まり) を返す。既に並んでいる場合はその順番を返す
+func (r *InMemoryWaitingQueueRepository) Enqueue(roomID uint, userGameLocation *model.UserGameLocation) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeLocked(userGameLocation.UserID)
+	r.queues[roomID] = append(r.queues[roomID], userGameLocation)
+	return len(r.queues[roomID])
+}
+
+func (r *InMemoryWaitingQueueRepository) PushFront(roomID uint, userGameLocation *model.UserGameLocation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeLocked(userGameLocation.UserID)
+	r.queues[roomID] = append([]*model.UserGameLocation{userGameLocation}, r.queues[roomID]...)
+}
+
+func (r *InMemoryWaitingQueueRepository) Dequeue(roomID uint) (*model.UserGameLocation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queue := r.queues[roomID]
+	if len(queue) == 0 {
+		return nil, false
+	}
+	next := queue[0]
+	if len(queue) == 1 {
+		delete(r.queues, roomID)
+	} else {
+		r.queues[roomID] = queue[1:]
+	}
+	return next, true
+}
+
+func (r *InMemoryWaitingQueueRepository) Remove(userID uint) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.removeLocked(userID)
+}
+
+func (r *InMemoryWaitingQueueRepository) removeLocked(userID uint) bool {
+	for roomID, queue := range r.queues {
+		for i, userGameLocation := range queue {
+			if userGameLocation.UserID != userID {
+				continue
+			}
+			queue = append(queue[:i:i], queue[i+1:]...)
+			if len(queue) == 0 {
+				delete(r.queues, roomID)
+			} else {
+				r.queues[roomID] = queue
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package minigame
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OnInput で受け付けられない入力の場合に返す。クライアントには invalid_argument として返る
+var ErrInvalidInput = errors.New("invalid game input")
+
+// ゲームに参加しているユーザーと現在の位置
+type Player struct {
+	UserID uint
+	XAxis  int
+	YAxis  int
+}
+
+// クライアントから game-input で届いた入力。data の形はゲームごとに決める
+type Input struct {
+	Action string
+	Data   json.RawMessage
+}
+
+// ゲームから見たルーム。送信したイベントは game-event としてクライアントに届く
+type Room interface {
+	ID() uint
+	// 今ルームにいるユーザー。UserID の昇順に並ぶ
+	Players() []Player
+	Broadcast(event string, data interface{})
+	Send(userID uint, event string, data interface{})
+	// ゲームを終えて結果の表示に移る。OnEnd は呼び出し元に戻った後に呼ばれる
+	End()
+}
+
+// ルームタイプごとのミニゲーム。ルームが playing の間だけ動く
+// フックは 1 つのゲームについて同時に呼ばれることはないため、ゲーム側でロックする必要はない
+type Minigame interface {
+	// ルームが playing になった時
+	OnStart(room Room, now time.Time)
+	// playing の間にユーザーが接続し直した時
+	OnPlayerJoin(room Room, player Player)
+	OnInput(room Room, userID uint, input Input) error
+	// ゲームごとの一定間隔で呼ばれる。位置は Players から読む
+	OnTick(room Room, now time.Time)
+	OnPlayerLeave(room Room, userID uint)
+	// playing が終わった時。途中で全員が抜けた場合にも呼ばれる
+	OnEnd(room Room)
+}
+
+// ルームごとに新しいゲームを作る
+type Factory func() Minigame
+
+// ルームタイプの minigame に指定できるゲーム
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+}
+
+func NewRegistry() *Registry {
+	return &Registry{factories: make(map[string]Factory)}
+}
+
+func (r *Registry) Register(name string, factory Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = factory
+}
+
+func (r *Registry) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.factories[name]
+	return ok
+}
+
+func (r *Registry) New(name string) (Minigame, bool) {
+	r.mu.RLock()
+	factory, ok := r.factories[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
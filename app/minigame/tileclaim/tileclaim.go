@@ -0,0 +1,258 @@
+package tileclaim
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/minigame"
+)
+
+// ルームタイプの minigame に指定する名前
+const Name = "tile-claim"
+
+// 送るイベント
+const (
+	EventStart   = "start"
+	EventState   = "state"
+	EventJoined  = "joined"
+	EventClaimed = "claimed"
+	EventResult  = "result"
+)
+
+// 受け付ける入力。state を送ると本人に今の状態を返す
+const ActionState = "state"
+
+// 複数のチームが同じマスにいることを表す
+const contested = -1
+
+type Options struct {
+	TileSize      int
+	RoundDuration time.Duration
+	Teams         int
+}
+
+// マスの位置。座標を TileSize で割って切り捨てたもの
+type Tile struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type ClaimedTile struct {
+	Tile
+	Team int `json:"team"`
+}
+
+type stateEvent struct {
+	TileSize int `json:"tileSize"`
+	// Key: userID
+	Teams  map[uint]int  `json:"teams"`
+	Tiles  []ClaimedTile `json:"tiles"`
+	Scores []int         `json:"scores"`
+	EndsAt time.Time     `json:"endsAt"`
+}
+
+type joinedEvent struct {
+	UserID uint `json:"userID"`
+	Team   int  `json:"team"`
+}
+
+type claimedEvent struct {
+	Tiles  []ClaimedTile `json:"tiles"`
+	Scores []int         `json:"scores"`
+}
+
+type resultEvent struct {
+	Scores []int `json:"scores"`
+	// 同点の場合は複数のチームが入る。誰もマスを取れなかった場合は空
+	Winners []int `json:"winners"`
+}
+
+// プレイヤーは立っているマスを自分のチームのものにできる。ただし別のチームのプレイヤーも同じマスにいる間はどのチームも取れない
+// 制限時間が過ぎた時点で多くのマスを持っているチームの勝ち
+// 位置はクライアントから move で届いたものを tick ごとに読むため、tick の間に通り過ぎたマスは取れない
+type Game struct {
+	options Options
+	endsAt  time.Time
+	// Key: userID
+	teams  map[uint]int
+	tiles  map[Tile]int
+	scores []int
+	ending bool
+}
+
+func New(options Options) minigame.Factory {
+	return func() minigame.Minigame {
+		return &Game{options: options}
+	}
+}
+
+func (g *Game) OnStart(room minigame.Room, now time.Time) {
+	g.endsAt = now.Add(g.options.RoundDuration)
+	g.teams = make(map[uint]int)
+	g.tiles = make(map[Tile]int)
+	g.scores = make([]int, g.options.Teams)
+	// UserID の順に交互に振り分ける
+	players := room.Players()
+	for i, player := range players {
+		g.teams[player.UserID] = i % g.options.Teams
+	}
+	g.claim(players)
+	room.Broadcast(EventStart, g.state())
+}
+
+func (g *Game) OnPlayerJoin(room minigame.Room, player minigame.Player) {
+	if _, ok := g.teams[player.UserID]; !ok {
+		g.teams[player.UserID] = g.smallestTeam()
+		room.Broadcast(EventJoined, &joinedEvent{UserID: player.UserID, Team: g.teams[player.UserID]})
+	}
+	room.Send(player.UserID, EventState, g.state())
+}
+
+func (g *Game) OnInput(room minigame.Room, userID uint, input minigame.Input) error {
+	switch input.Action {
+	case ActionState:
+		room.Send(userID, EventState, g.state())
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported action %q", minigame.ErrInvalidInput, input.Action)
+	}
+}
+
+func (g *Game) OnTick(room minigame.Room, now time.Time) {
+	if g.ending {
+		return
+	}
+	claimed := g.claim(room.Players())
+	if len(claimed) > 0 {
+		room.Broadcast(EventClaimed, &claimedEvent{Tiles: claimed, Scores: g.Scores()})
+	}
+	if !now.Before(g.endsAt) {
+		g.ending = true
+		room.End()
+	}
+}
+
+// 抜けたユーザーが取ったマスはチームに残す
+func (g *Game) OnPlayerLeave(room minigame.Room, userID uint) {
+	delete(g.teams, userID)
+}
+
+func (g *Game) OnEnd(room minigame.Room) {
+	room.Broadcast(EventResult, &resultEvent{Scores: g.Scores(), Winners: g.Winners()})
+}
+
+// 各プレイヤーが今いるマスをそのチームのものにし、持ち主が変わったマスを返す
+// 複数のチームが同じマスにいる場合はどのチームも取れない
+func (g *Game) claim(players []minigame.Player) []ClaimedTile {
+	standing := make(map[Tile]int)
+	order := []Tile{}
+	for _, player := range players {
+		team, ok := g.teams[player.UserID]
+		if !ok {
+			continue
+		}
+		tile := g.TileAt(player.XAxis, player.YAxis)
+		current, seen := standing[tile]
+		switch {
+		case !seen:
+			standing[tile] = team
+			order = append(order, tile)
+		case current != team:
+			standing[tile] = contested
+		}
+	}
+	claimed := []ClaimedTile{}
+	for _, tile := range order {
+		team := standing[tile]
+		if team == contested {
+			continue
+		}
+		owner, owned := g.tiles[tile]
+		if owned && owner == team {
+			continue
+		}
+		if owned {
+			g.scores[owner]--
+		}
+		g.tiles[tile] = team
+		g.scores[team]++
+		claimed = append(claimed, ClaimedTile{Tile: tile, Team: team})
+	}
+	return claimed
+}
+
+func (g *Game) TileAt(xAxis int, yAxis int) Tile {
+	return Tile{X: floorDiv(xAxis, g.options.TileSize), Y: floorDiv(yAxis, g.options.TileSize)}
+}
+
+// チームごとの持っているマスの数
+func (g *Game) Scores() []int {
+	scores := make([]int, len(g.scores))
+	copy(scores, g.scores)
+	return scores
+}
+
+// 最も多くのマスを持っているチーム
+func (g *Game) Winners() []int {
+	best := 0
+	winners := []int{}
+	for team, score := range g.scores {
+		switch {
+		case score == 0 || score < best:
+		case score > best:
+			best = score
+			winners = []int{team}
+		default:
+			winners = append(winners, team)
+		}
+	}
+	return winners
+}
+
+func (g *Game) smallestTeam() int {
+	members := make([]int, g.options.Teams)
+	for _, team := range g.teams {
+		members[team]++
+	}
+	smallest := 0
+	for team, count := range members {
+		if count < members[smallest] {
+			smallest = team
+		}
+	}
+	return smallest
+}
+
+func (g *Game) state() *stateEvent {
+	tiles := make([]ClaimedTile, 0, len(g.tiles))
+	for tile, team := range g.tiles {
+		tiles = append(tiles, ClaimedTile{Tile: tile, Team: team})
+	}
+	sort.Slice(tiles, func(i, j int) bool {
+		if tiles[i].Y != tiles[j].Y {
+			return tiles[i].Y < tiles[j].Y
+		}
+		return tiles[i].X < tiles[j].X
+	})
+	teams := make(map[uint]int, len(g.teams))
+	for userID, team := range g.teams {
+		teams[userID] = team
+	}
+	return &stateEvent{
+		TileSize: g.options.TileSize,
+		Teams:    teams,
+		Tiles:    tiles,
+		Scores:   g.Scores(),
+		EndsAt:   g.endsAt,
+	}
+}
+
+// 負の座標も左上のマスに含める
+func floorDiv(a int, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,315 @@
+package tileclaim
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/minigame"
+)
+
+const testRoomID = 1
+
+type sentEvent struct {
+	// 0 の場合は Broadcast
+	userID uint
+	event  string
+	data   interface{}
+}
+
+// 送ったイベントと End の回数を記録するルーム
+type fakeRoom struct {
+	players []minigame.Player
+	sent    []sentEvent
+	ended   int
+}
+
+func (r *fakeRoom) ID() uint {
+	return testRoomID
+}
+
+func (r *fakeRoom) Players() []minigame.Player {
+	players := make([]minigame.Player, len(r.players))
+	copy(players, r.players)
+	return players
+}
+
+func (r *fakeRoom) Broadcast(event string, data interface{}) {
+	r.sent = append(r.sent, sentEvent{event: event, data: data})
+}
+
+func (r *fakeRoom) Send(userID uint, event string, data interface{}) {
+	r.sent = append(r.sent, sentEvent{userID: userID, event: event, data: data})
+}
+
+func (r *fakeRoom) End() {
+	r.ended++
+}
+
+// UserID の順に位置を並べたルーム
+func newFakeRoom(positions ...[2]int) *fakeRoom {
+	room := &fakeRoom{}
+	for i, position := range positions {
+		room.players = append(room.players, minigame.Player{UserID: uint(i + 1), XAxis: position[0], YAxis: position[1]})
+	}
+	return room
+}
+
+func (r *fakeRoom) move(userID uint, x int, y int) {
+	for i := range r.players {
+		if r.players[i].UserID == userID {
+			r.players[i].XAxis = x
+			r.players[i].YAxis = y
+		}
+	}
+}
+
+func (r *fakeRoom) leave(userID uint) {
+	for i := range r.players {
+		if r.players[i].UserID == userID {
+			r.players = append(r.players[:i], r.players[i+1:]...)
+			return
+		}
+	}
+}
+
+// 前回から送ったイベントを取り出す
+func (r *fakeRoom) takeSent() []sentEvent {
+	sent := r.sent
+	r.sent = nil
+	return sent
+}
+
+func newTestGame(teams int) *Game {
+	return New(Options{TileSize: 10, RoundDuration: 30 * time.Second, Teams: teams})().(*Game)
+}
+
+func expectScores(t *testing.T, game *Game, want []int) {
+	t.Helper()
+	if got := game.Scores(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("scores = %v, want %v", got, want)
+	}
+}
+
+// 1 つだけ Broadcast された claimed を返す
+func expectClaimed(t *testing.T, room *fakeRoom) *claimedEvent {
+	t.Helper()
+	sent := room.takeSent()
+	if len(sent) != 1 || sent[0].userID != 0 || sent[0].event != EventClaimed {
+		t.Fatalf("sent = %+v, want one broadcast %s", sent, EventClaimed)
+	}
+	return sent[0].data.(*claimedEvent)
+}
+
+func TestClaimsStandingTiles(t *testing.T) {
+	game := newTestGame(2)
+	// UserID の順にチーム 0, 1 に分かれる
+	room := newFakeRoom([2]int{5, 5}, [2]int{25, -5})
+
+	now := time.Now()
+	game.OnStart(room, now)
+	expectScores(t, game, []int{1, 1})
+	if sent := room.takeSent(); len(sent) != 1 || sent[0].event != EventStart {
+		t.Fatalf("sent = %+v, want one %s", sent, EventStart)
+	}
+
+	room.move(1, 15, 5)
+	game.OnTick(room, now.Add(time.Second))
+	claimed := expectClaimed(t, room)
+	want := &claimedEvent{Tiles: []ClaimedTile{{Tile: Tile{X: 1, Y: 0}, Team: 0}}, Scores: []int{2, 1}}
+	if !reflect.DeepEqual(claimed, want) {
+		t.Fatalf("claimed = %+v, want %+v", claimed, want)
+	}
+
+	// 同じマスに留まっている間は何も送らない
+	game.OnTick(room, now.Add(2*time.Second))
+	if sent := room.takeSent(); len(sent) != 0 {
+		t.Fatalf("sent = %+v, want nothing", sent)
+	}
+	expectScores(t, game, []int{2, 1})
+	if room.ended != 0 {
+		t.Fatal("room was ended")
+	}
+}
+
+func TestContestedTile(t *testing.T) {
+	game := newTestGame(2)
+	// チーム 0, 1, 0
+	room := newFakeRoom([2]int{5, 5}, [2]int{6, 6}, [2]int{45, 45})
+
+	now := time.Now()
+	game.OnStart(room, now)
+	// 1 と 2 が同じマスにいるのでどちらも取れない
+	expectScores(t, game, []int{1, 0})
+	room.takeSent()
+
+	// 同じチーム同士なら取れる
+	room.move(3, 7, 7)
+	room.move(2, 25, 25)
+	game.OnTick(room, now.Add(time.Second))
+	claimed := expectClaimed(t, room)
+	want := &claimedEvent{
+		Tiles:  []ClaimedTile{{Tile: Tile{X: 0, Y: 0}, Team: 0}, {Tile: Tile{X: 2, Y: 2}, Team: 1}},
+		Scores: []int{2, 1},
+	}
+	if !reflect.DeepEqual(claimed, want) {
+		t.Fatalf("claimed = %+v, want %+v", claimed, want)
+	}
+
+	// 別のチームが入ってきた持ち主のいるマスはそのまま残る
+	room.move(2, 5, 5)
+	game.OnTick(room, now.Add(2*time.Second))
+	if sent := room.takeSent(); len(sent) != 0 {
+		t.Fatalf("sent = %+v, want nothing", sent)
+	}
+	expectScores(t, game, []int{2, 1})
+}
+
+func TestTransfersTiles(t *testing.T) {
+	game := newTestGame(2)
+	room := newFakeRoom([2]int{5, 5}, [2]int{25, 5})
+
+	now := time.Now()
+	game.OnStart(room, now)
+	room.takeSent()
+
+	// チーム 1 がチーム 0 のマスを取ると、チーム 0 の点が減る
+	room.move(1, 45, 5)
+	room.move(2, 5, 5)
+	game.OnTick(room, now.Add(time.Second))
+	claimed := expectClaimed(t, room)
+	want := &claimedEvent{
+		Tiles:  []ClaimedTile{{Tile: Tile{X: 4, Y: 0}, Team: 0}, {Tile: Tile{X: 0, Y: 0}, Team: 1}},
+		Scores: []int{1, 2},
+	}
+	if !reflect.DeepEqual(claimed, want) {
+		t.Fatalf("claimed = %+v, want %+v", claimed, want)
+	}
+
+	// 抜けたユーザーが取ったマスはチームに残る
+	game.OnPlayerLeave(room, 1)
+	room.leave(1)
+	game.OnTick(room, now.Add(2*time.Second))
+	expectScores(t, game, []int{1, 2})
+}
+
+func TestEndsAtRoundTimer(t *testing.T) {
+	game := newTestGame(2)
+	room := newFakeRoom([2]int{5, 5}, [2]int{25, 5})
+
+	now := time.Now()
+	game.OnStart(room, now)
+	room.takeSent()
+
+	game.OnTick(room, now.Add(30*time.Second-time.Millisecond))
+	if room.ended != 0 {
+		t.Fatal("room was ended before the round timer")
+	}
+
+	room.move(1, 15, 5)
+	game.OnTick(room, now.Add(30*time.Second))
+	if room.ended != 1 {
+		t.Fatalf("End called %d times, want 1", room.ended)
+	}
+	// 終了を決めた tick で取ったマスは数える
+	expectScores(t, game, []int{2, 1})
+	expectClaimed(t, room)
+
+	// 終了を決めた後の tick では取れず、End も呼ばない
+	room.move(1, 35, 5)
+	game.OnTick(room, now.Add(31*time.Second))
+	if room.ended != 1 {
+		t.Fatalf("End called %d times, want 1", room.ended)
+	}
+	expectScores(t, game, []int{2, 1})
+
+	game.OnEnd(room)
+	sent := room.takeSent()
+	if len(sent) != 1 || sent[0].event != EventResult {
+		t.Fatalf("sent = %+v, want one %s", sent, EventResult)
+	}
+	want := &resultEvent{Scores: []int{2, 1}, Winners: []int{0}}
+	if result := sent[0].data.(*resultEvent); !reflect.DeepEqual(result, want) {
+		t.Fatalf("result = %+v, want %+v", result, want)
+	}
+}
+
+func TestWinners(t *testing.T) {
+	tests := []struct {
+		name      string
+		teams     int
+		positions [][2]int // UserID の順の位置
+		want      []int
+	}{
+		{
+			name:      "single winner",
+			teams:     2,
+			positions: [][2]int{{5, 5}, {25, 5}, {15, 5}},
+			want:      []int{0},
+		},
+		{
+			name:      "teams without tiles do not win",
+			teams:     3,
+			positions: [][2]int{{5, 5}, {25, 5}, {6, 6}},
+			want:      []int{1},
+		},
+		{
+			name:      "tie",
+			teams:     3,
+			positions: [][2]int{{5, 5}, {25, 5}},
+			want:      []int{0, 1},
+		},
+		{
+			name:  "no tiles claimed",
+			teams: 2,
+			want:  []int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game := newTestGame(tt.teams)
+			room := newFakeRoom(tt.positions...)
+			game.OnStart(room, time.Now())
+			if got := game.Winners(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Winners() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTileAt(t *testing.T) {
+	game := newTestGame(2)
+	tests := []struct {
+		x, y int
+		want Tile
+	}{
+		{x: 0, y: 0, want: Tile{X: 0, Y: 0}},
+		{x: 9, y: 10, want: Tile{X: 0, Y: 1}},
+		{x: 25, y: -5, want: Tile{X: 2, Y: -1}},
+		{x: -10, y: -11, want: Tile{X: -1, Y: -2}},
+		{x: -1, y: -20, want: Tile{X: -1, Y: -2}},
+	}
+	for _, tt := range tests {
+		if got := game.TileAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("TileAt(%d, %d) = %+v, want %+v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestRejectsUnknownInput(t *testing.T) {
+	game := newTestGame(2)
+	room := newFakeRoom([2]int{5, 5})
+	game.OnStart(room, time.Now())
+	room.takeSent()
+
+	if err := game.OnInput(room, 1, minigame.Input{Action: "jump"}); !errors.Is(err, minigame.ErrInvalidInput) {
+		t.Fatalf("OnInput(jump) = %v, want ErrInvalidInput", err)
+	}
+	if err := game.OnInput(room, 1, minigame.Input{Action: ActionState}); err != nil {
+		t.Fatalf("OnInput(state) = %v", err)
+	}
+	if sent := room.takeSent(); len(sent) != 1 || sent[0].userID != 1 || sent[0].event != EventState {
+		t.Fatalf("sent = %+v, want %s to user 1", sent, EventState)
+	}
+}
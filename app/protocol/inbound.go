@@ -0,0 +1,375 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// 座標。クライアントは小数を送ってくることがあるため整数に切り捨てて受け取る
+type Coordinate int
+
+func (c *Coordinate) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if math.IsNaN(value) || value > math.MaxInt32 || value < math.MinInt32 {
+		return fmt.Errorf("coordinate out of range: %v", value)
+	}
+	*c = Coordinate(value)
+	return nil
+}
+
+type JoinArea struct {
+	Envelope
+	AreaID uint `json:"areaID"`
+}
+
+func (m *JoinArea) validate() error {
+	return requireID("areaID", m.AreaID)
+}
+
+// private / invite-only のルームに参加する時のパスコードと招待コード
+type RoomCredentials struct {
+	Passcode   string `json:"passcode,omitempty"`
+	InviteCode string `json:"inviteCode,omitempty"`
+}
+
+type JoinGame struct {
+	Envelope
+	RoomID uint `json:"roomID"`
+	RoomCredentials
+}
+
+func (m *JoinGame) validate() error {
+	return requireID("roomID", m.RoomID)
+}
+
+type JoinAudio struct {
+	Envelope
+	RoomID uint `json:"roomID"`
+	// /ws でルームに参加する時に使う。/game では join-game の時に確認する
+	RoomCredentials
+}
+
+func (m *JoinAudio) validate() error {
+	return requireID("roomID", m.RoomID)
+}
+
+type LeaveArea struct {
+	Envelope
+}
+
+type LeaveGame struct {
+	Envelope
+}
+
+type LeaveAudio struct {
+	Envelope
+	RoomID uint `json:"roomID"`
+}
+
+func (m *LeaveAudio) validate() error {
+	return requireID("roomID", m.RoomID)
+}
+
+type MoveInArea struct {
+	Envelope
+	AreaID uint       `json:"areaID"`
+	XAxis  Coordinate `json:"xAxis"`
+	YAxis  Coordinate `json:"yAxis"`
+}
+
+func (m *MoveInArea) validate() error {
+	return requireID("areaID", m.AreaID)
+}
+
+type MoveInGame struct {
+	Envelope
+	RoomID uint       `json:"roomID"`
+	XAxis  Coordinate `json:"xAxis"`
+	YAxis  Coordinate `json:"yAxis"`
+}
+
+func (m *MoveInGame) validate() error {
+	return requireID("roomID", m.RoomID)
+}
+
+// offer / answer / ice-candidate。SDP などの中身は解釈せずそのまま相手に転送する
+// target が "server" の場合は toUserID の代わりに SFU が相手になる
+const SignalTargetServer = "server"
+
+// 相手にそのまま転送するため、SDP などを含めたメッセージ全体の大きさを制限する
+const MaxSignalPayloadSize = 16 * 1024
+
+type Signal struct {
+	Envelope
+	ToUserID uint                       `json:"toUserID"`
+	Target   string                     `json:"target,omitempty"`
+	Payload  map[string]json.RawMessage `json:"-"`
+}
+
+func (m *Signal) validate() error {
+	size := 0
+	for key, value := range m.Payload {
+		size += len(key) + len(value)
+	}
+	if size > MaxSignalPayloadSize {
+		return fmt.Errorf("payload must be at most %d bytes", MaxSignalPayloadSize)
+	}
+	// sdp が RTCSessionDescription の形で送られてきた場合は type がメッセージの種類と一致すること
+	if value, ok := m.Payload["sdp"]; ok {
+		var description struct {
+			Type *string `json:"type"`
+		}
+		if json.Unmarshal(value, &description) == nil && description.Type != nil && *description.Type != m.Type {
+			return fmt.Errorf("sdp type %q does not match message type %q", *description.Type, m.Type)
+		}
+	}
+	switch m.Target {
+	case "":
+		return requireID("toUserID", m.ToUserID)
+	case SignalTargetServer:
+		// SFU への answer には sdp、ice-candidate には candidate が必要
+		field := "sdp"
+		if m.Type == TypeICECandidate {
+			field = "candidate"
+		}
+		if value, ok := m.Payload[field]; !ok || string(value) == "null" {
+			return fmt.Errorf("%s is required", field)
+		}
+		return nil
+	default:
+		return fmt.Errorf("target must be %q or omitted", SignalTargetServer)
+	}
+}
+
+// scope は room / area / direct。direct の場合は toUserID に送る
+type Chat struct {
+	Envelope
+	Scope    string `json:"scope"`
+	ToUserID uint   `json:"toUserID"`
+	Text     string `json:"text"`
+}
+
+func (m *Chat) validate() error {
+	if strings.TrimSpace(m.Text) == "" {
+		return fmt.Errorf("text must not be empty")
+	}
+	return nil
+}
+
+// ルームの所有者が同じルームのユーザーに行う kick / mute-audio / mute-chat / ban
+// durationSeconds は kick 以外で使い、0 の場合は無期限
+type Moderate struct {
+	Envelope
+	TargetUserID    uint   `json:"targetUserID"`
+	DurationSeconds int    `json:"durationSeconds"`
+	Reason          string `json:"reason"`
+}
+
+// 理由の最大の長さ (バイト)
+const MaxModerationReasonSize = 255
+
+func (m *Moderate) validate() error {
+	if err := requireID("targetUserID", m.TargetUserID); err != nil {
+		return err
+	}
+	if m.DurationSeconds < 0 {
+		return fmt.Errorf("durationSeconds must not be negative")
+	}
+	if len(m.Reason) > MaxModerationReasonSize {
+		return fmt.Errorf("reason must be at most %d bytes", MaxModerationReasonSize)
+	}
+	return nil
+}
+
+// 参加中のルームで準備の確認を始める。所有者のいるルームでは所有者だけが送れる
+type ReadyCheck struct {
+	Envelope
+}
+
+// ready-check 中に準備ができたかどうかを知らせる
+type Ready struct {
+	Envelope
+	Ready bool `json:"ready"`
+}
+
+// 参加中のルームのゲームを終えて結果の表示に移る。所有者のいるルームでは所有者だけが送れる
+type EndGame struct {
+	Envelope
+}
+
+// playing 中のルームのミニゲームへの入力。action と data の形はミニゲームごとに決まる
+type GameInput struct {
+	Envelope
+	Action string          `json:"action"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+func (m *GameInput) validate() error {
+	if strings.TrimSpace(m.Action) == "" {
+		return fmt.Errorf("action must not be empty")
+	}
+	return nil
+}
+
+type Ping struct {
+	Envelope
+}
+
+// 切断前に発行された resumeToken で同じ UserGameLocation に復帰する
+type Resume struct {
+	Envelope
+	ResumeToken string `json:"resumeToken"`
+}
+
+func (m *Resume) validate() error {
+	if m.ResumeToken == "" {
+		return fmt.Errorf("resumeToken must not be empty")
+	}
+	return nil
+}
+
+// エリア内のルーム一覧と参加人数の変化を購読する
+type SubscribeLobby struct {
+	Envelope
+	AreaID uint `json:"areaID"`
+}
+
+func (m *SubscribeLobby) validate() error {
+	return requireID("areaID", m.AreaID)
+}
+
+type UnsubscribeLobby struct {
+	Envelope
+}
+
+// ルームタイプを指定してマッチングの待ち行列に並ぶ。新しく作るルームは areaID のエリアに置く
+type FindMatch struct {
+	Envelope
+	AreaID     uint `json:"areaID"`
+	RoomTypeID uint `json:"roomTypeID"`
+}
+
+func (m *FindMatch) validate() error {
+	if err := requireID("areaID", m.AreaID); err != nil {
+		return err
+	}
+	return requireID("roomTypeID", m.RoomTypeID)
+}
+
+type CancelMatch struct {
+	Envelope
+}
+
+// 受け取った state の seq を知らせる。以降の state はこの seq からの差分になる
+type Ack struct {
+	Envelope
+	Seq uint64 `json:"seq"`
+}
+
+func (m *Ack) validate() error {
+	if m.Seq == 0 {
+		return fmt.Errorf("seq must be greater than 0")
+	}
+	return nil
+}
+
+// 差分を適用できなくなった場合などに全員分の位置を要求する
+type RequestKeyframe struct {
+	Envelope
+}
+
+func requireID(field string, id uint) error {
+	if id == 0 {
+		return fmt.Errorf("%s must be greater than 0", field)
+	}
+	return nil
+}
+
+// /ws エンドポイントで受信したメッセージをデコードする
+func DecodeAreaMessage(data []byte) (Inbound, error) {
+	fields, msgType, err := decodeEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	switch msgType {
+	case TypeJoinArea:
+		return decodeMessage(data, fields, msgType, &JoinArea{}, "areaID")
+	case TypeJoinAudio:
+		return decodeMessage(data, fields, msgType, &JoinAudio{}, "roomID")
+	case TypeLeaveArea:
+		return decodeMessage(data, fields, msgType, &LeaveArea{})
+	case TypeLeaveAudio:
+		return decodeMessage(data, fields, msgType, &LeaveAudio{}, "roomID")
+	case TypeMove:
+		return decodeMessage(data, fields, msgType, &MoveInArea{}, "areaID", "xAxis", "yAxis")
+	case TypeOffer, TypeAnswer, TypeICECandidate:
+		return decodeMessage(data, fields, msgType, &Signal{Payload: fields})
+	case TypeSubscribeLobby:
+		return decodeMessage(data, fields, msgType, &SubscribeLobby{}, "areaID")
+	case TypeUnsubscribeLobby:
+		return decodeMessage(data, fields, msgType, &UnsubscribeLobby{})
+	case TypeAck:
+		return decodeMessage(data, fields, msgType, &Ack{}, "seq")
+	case TypeRequestKeyframe:
+		return decodeMessage(data, fields, msgType, &RequestKeyframe{})
+	case TypeChat:
+		return decodeMessage(data, fields, msgType, &Chat{}, "scope", "text")
+	case TypeKick, TypeMuteAudio, TypeMuteChat, TypeBan:
+		return decodeMessage(data, fields, msgType, &Moderate{}, "targetUserID")
+	default:
+		return nil, unknownType(msgType)
+	}
+}
+
+// /game エンドポイントで受信したメッセージをデコードする
+func DecodeGameMessage(data []byte) (Inbound, error) {
+	fields, msgType, err := decodeEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	switch msgType {
+	case TypeJoinGame:
+		return decodeMessage(data, fields, msgType, &JoinGame{}, "roomID")
+	case TypeJoinAudio:
+		return decodeMessage(data, fields, msgType, &JoinAudio{}, "roomID")
+	case TypeLeaveGame:
+		return decodeMessage(data, fields, msgType, &LeaveGame{})
+	case TypeLeaveAudio:
+		return decodeMessage(data, fields, msgType, &LeaveAudio{}, "roomID")
+	case TypeMove:
+		return decodeMessage(data, fields, msgType, &MoveInGame{}, "roomID", "xAxis", "yAxis")
+	case TypeOffer, TypeAnswer, TypeICECandidate:
+		return decodeMessage(data, fields, msgType, &Signal{Payload: fields})
+	case TypePing:
+		return decodeMessage(data, fields, msgType, &Ping{})
+	case TypeResume:
+		return decodeMessage(data, fields, msgType, &Resume{}, "resumeToken")
+	case TypeFindMatch:
+		return decodeMessage(data, fields, msgType, &FindMatch{}, "areaID", "roomTypeID")
+	case TypeCancelMatch:
+		return decodeMessage(data, fields, msgType, &CancelMatch{})
+	case TypeAck:
+		return decodeMessage(data, fields, msgType, &Ack{}, "seq")
+	case TypeRequestKeyframe:
+		return decodeMessage(data, fields, msgType, &RequestKeyframe{})
+	case TypeChat:
+		return decodeMessage(data, fields, msgType, &Chat{}, "scope", "text")
+	case TypeKick, TypeMuteAudio, TypeMuteChat, TypeBan:
+		return decodeMessage(data, fields, msgType, &Moderate{}, "targetUserID")
+	case TypeReadyCheck:
+		return decodeMessage(data, fields, msgType, &ReadyCheck{})
+	case TypeReady:
+		return decodeMessage(data, fields, msgType, &Ready{}, "ready")
+	case TypeEndGame:
+		return decodeMessage(data, fields, msgType, &EndGame{})
+	case TypeGameInput:
+		return decodeMessage(data, fields, msgType, &GameInput{}, "action")
+	default:
+		return nil, unknownType(msgType)
+	}
+}
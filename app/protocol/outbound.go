@@ -0,0 +1,406 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type UserLocation struct {
+	UserID uint `json:"userID"`
+	AreaID uint `json:"areaID"`
+	RoomID uint `json:"roomID"`
+	XAxis  int  `json:"xAxis"`
+	YAxis  int  `json:"yAxis"`
+}
+
+type UserGameLocation struct {
+	UserID uint `json:"userID"`
+	RoomID uint `json:"roomID"`
+	XAxis  int  `json:"xAxis"`
+	YAxis  int  `json:"yAxis"`
+}
+
+// joined-area
+type AreaJoined struct {
+	Type          string         `json:"type"`
+	FromUserID    uint           `json:"fromUserID"`
+	AreaID        uint           `json:"areaID"`
+	XAxis         int            `json:"xAxis"`
+	YAxis         int            `json:"yAxis"`
+	UserLocations []UserLocation `json:"userLocations"`
+}
+
+// move (/ws)
+type AreaMoved struct {
+	Type          string         `json:"type"`
+	FromUserID    uint           `json:"fromUserID"`
+	AreaID        uint           `json:"areaID"`
+	XAxis         int            `json:"xAxis"`
+	YAxis         int            `json:"yAxis"`
+	UserLocations []UserLocation `json:"userLocations"`
+}
+
+// 送信待ちの move は最新の状態だけ送ればよい
+func (m *AreaMoved) CoalesceKey() string {
+	return TypeMove
+}
+
+// leave-area
+type AreaLeft struct {
+	Type          string         `json:"type"`
+	FromUserID    uint           `json:"fromUserID"`
+	AreaID        uint           `json:"areaID"`
+	RoomID        uint           `json:"roomID"`
+	UserLocations []UserLocation `json:"userLocations"`
+}
+
+// leave-room / disconnect-room
+type RoomLeft struct {
+	Type       string `json:"type"`
+	FromUserID uint   `json:"fromUserID"`
+	ToUserID   uint   `json:"toUserID"`
+	AreaID     uint   `json:"areaID"`
+	RoomID     uint   `json:"roomID"`
+}
+
+// join-audio。voiceMode が sfu の場合は他のクライアントに offer を送らず、サーバーからの offer を待つ
+type AudioJoined struct {
+	Type             string `json:"type"`
+	FromUserID       uint   `json:"fromUserID"`
+	RoomID           uint   `json:"roomID"`
+	ConnectedUserIDs []uint `json:"connectedUserIds"`
+	VoiceMode        string `json:"voiceMode"`
+}
+
+// disconnect-audio
+type AudioDisconnected struct {
+	Type       string `json:"type"`
+	FromUserID uint   `json:"fromUserID"`
+	RoomID     uint   `json:"roomID"`
+}
+
+// join-game
+type GameJoined struct {
+	Type              string             `json:"type"`
+	FromUserID        uint               `json:"fromUserID"`
+	RoomID            uint               `json:"roomID"`
+	XAxis             int                `json:"xAxis"`
+	YAxis             int                `json:"yAxis"`
+	ConnectedUserIDs  []uint             `json:"connectedUserIds"`
+	UserGameLocations []UserGameLocation `json:"userGameLocations"`
+}
+
+// move (/game)
+type GameMoved struct {
+	Type              string             `json:"type"`
+	FromUserID        uint               `json:"fromUserID"`
+	RoomID            uint               `json:"roomID"`
+	UserGameLocations []UserGameLocation `json:"userGameLocations"`
+}
+
+func (m *GameMoved) CoalesceKey() string {
+	return TypeMove
+}
+
+// leave-game / leave-audio / disconnect-game
+type GameLeft struct {
+	Type       string `json:"type"`
+	FromUserID uint   `json:"fromUserID"`
+	ToUserID   uint   `json:"toUserID"`
+	RoomID     uint   `json:"roomID"`
+}
+
+type Pong struct {
+	Type       string `json:"type"`
+	FromUserID uint   `json:"fromUserID"`
+	ToUserID   uint   `json:"toUserID"`
+	RoomID     uint   `json:"roomID"`
+}
+
+// join-game 成功時に本人にだけ送る再接続用のトークン
+type SessionIssued struct {
+	Type         string `json:"type"`
+	RoomID       uint   `json:"roomID"`
+	ResumeToken  string `json:"resumeToken"`
+	GraceSeconds int    `json:"graceSeconds"`
+}
+
+// resume 成功時に本人に送る現在のルームの状態
+type Resumed struct {
+	Type              string             `json:"type"`
+	FromUserID        uint               `json:"fromUserID"`
+	RoomID            uint               `json:"roomID"`
+	XAxis             int                `json:"xAxis"`
+	YAxis             int                `json:"yAxis"`
+	UserGameLocations []UserGameLocation `json:"userGameLocations"`
+}
+
+// 他のプレイヤーの接続状態 (connected / reconnecting)
+type PlayerStatus struct {
+	Type       string `json:"type"`
+	FromUserID uint   `json:"fromUserID"`
+	RoomID     uint   `json:"roomID"`
+	Status     string `json:"status"`
+}
+
+// 満員のルームの待ち行列に並んだことを知らせる。position は 1 始まりの待ち順
+type Waiting struct {
+	Type     string `json:"type"`
+	RoomID   uint   `json:"roomID"`
+	Position int    `json:"position"`
+}
+
+type LobbyRoom struct {
+	RoomID         uint   `json:"roomID"`
+	RoomTypeName   string `json:"roomTypeName"`
+	MaxParticipant int    `json:"maxParticipant"`
+	Status         int    `json:"status"`
+	State          string `json:"state"`
+	Occupancy      int    `json:"occupancy"`
+	Visibility     string `json:"visibility"`
+	HasPasscode    bool   `json:"hasPasscode"`
+}
+
+// subscribe-lobby への応答。エリア内の全ルーム
+type Lobby struct {
+	Type   string      `json:"type"`
+	AreaID uint        `json:"areaID"`
+	Rooms  []LobbyRoom `json:"rooms"`
+}
+
+// 変化のあったルーム 1 件分の差分。removed が true の場合は一覧から消す
+type LobbyUpdate struct {
+	Type    string    `json:"type"`
+	AreaID  uint      `json:"areaID"`
+	Room    LobbyRoom `json:"room"`
+	Removed bool      `json:"removed,omitempty"`
+}
+
+// find-match を受け付けたことを知らせる
+type MatchSearching struct {
+	Type       string `json:"type"`
+	AreaID     uint   `json:"areaID"`
+	RoomTypeID uint   `json:"roomTypeID"`
+}
+
+// ルームが割り当てられた。続けて join-game と同じイベントが届く
+type MatchFound struct {
+	Type       string `json:"type"`
+	AreaID     uint   `json:"areaID"`
+	RoomTypeID uint   `json:"roomTypeID"`
+	RoomID     uint   `json:"roomID"`
+}
+
+const (
+	MatchCancelReasonCancelled = "cancelled"
+	MatchCancelReasonTimeout   = "timeout"
+)
+
+type MatchCancelled struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// 移動のルールに合わない move を送ってきたクライアントに、サーバーが採用した位置を知らせる
+type PositionCorrection struct {
+	Type   string `json:"type"`
+	AreaID uint   `json:"areaID,omitempty"`
+	RoomID uint   `json:"roomID,omitempty"`
+	XAxis  int    `json:"xAxis"`
+	YAxis  int    `json:"yAxis"`
+	Reason string `json:"reason"`
+}
+
+// 他のユーザーが視界に入った。以降はこのユーザーの位置も state に含まれる
+type EnterView struct {
+	Type   string `json:"type"`
+	AreaID uint   `json:"areaID"`
+	UserID uint   `json:"userID"`
+	XAxis  int    `json:"xAxis"`
+	YAxis  int    `json:"yAxis"`
+}
+
+// 他のユーザーが視界から外れた。以降はこのユーザーの位置は state に含まれない
+type LeaveView struct {
+	Type   string `json:"type"`
+	AreaID uint   `json:"areaID"`
+	UserID uint   `json:"userID"`
+}
+
+// エリア内で音声の範囲に入ったユーザー。initiator が true の側から offer を送る
+type PeerInRange struct {
+	Type      string `json:"type"`
+	AreaID    uint   `json:"areaID"`
+	UserID    uint   `json:"userID"`
+	Initiator bool   `json:"initiator"`
+}
+
+// 音声の範囲から外れたユーザー。このユーザーとの WebRTC 接続は閉じる
+type PeerOutOfRange struct {
+	Type   string `json:"type"`
+	AreaID uint   `json:"areaID"`
+	UserID uint   `json:"userID"`
+}
+
+type PlayerPosition struct {
+	UserID uint `json:"userID"`
+	XAxis  int  `json:"xAxis"`
+	YAxis  int  `json:"yAxis"`
+}
+
+// 位置の更新。クライアントが ack した baseSeq のスナップショットからの差分で、keyframe の場合は全員分
+// removed は baseSeq の時点にはいたがいなくなったユーザー
+type State struct {
+	Type     string           `json:"type"`
+	AreaID   uint             `json:"areaID,omitempty"`
+	RoomID   uint             `json:"roomID,omitempty"`
+	Seq      uint64           `json:"seq"`
+	BaseSeq  uint64           `json:"baseSeq"`
+	Keyframe bool             `json:"keyframe"`
+	Players  []PlayerPosition `json:"players"`
+	Removed  []uint           `json:"removed,omitempty"`
+}
+
+// SFU のルームでサーバーから送る offer / ice-candidate。from は常に "server"
+type ServerSignal struct {
+	Type      string          `json:"type"`
+	From      string          `json:"from"`
+	RoomID    uint            `json:"roomID"`
+	SDP       string          `json:"sdp,omitempty"`
+	Candidate json.RawMessage `json:"candidate,omitempty"`
+}
+
+// RTCIceServer と同じ形
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// join-audio の後に本人にだけ送る。TURN の認証情報は ttl 秒後に使えなくなる
+type ICEConfig struct {
+	Type       string      `json:"type"`
+	ICEServers []ICEServer `json:"iceServers"`
+	TTL        int         `json:"ttl"`
+}
+
+// chat。送信者本人にも同じものが届く
+type ChatMessage struct {
+	Type       string    `json:"type"`
+	ID         uint      `json:"id"`
+	Scope      string    `json:"scope"`
+	FromUserID uint      `json:"fromUserID"`
+	ToUserID   uint      `json:"toUserID,omitempty"`
+	AreaID     uint      `json:"areaID,omitempty"`
+	RoomID     uint      `json:"roomID,omitempty"`
+	Text       string    `json:"text"`
+	SentAt     time.Time `json:"sentAt"`
+}
+
+// エリアやルームへの参加時に送る最近のメッセージ。古い順
+type ChatHistory struct {
+	Type     string        `json:"type"`
+	Scope    string        `json:"scope"`
+	AreaID   uint          `json:"areaID,omitempty"`
+	RoomID   uint          `json:"roomID,omitempty"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+// 本人にだけ送り、送った後に接続を閉じる。banned が true の場合は expiresAt まで (nil なら無期限) 参加し直せない
+type Kicked struct {
+	Type      string     `json:"type"`
+	AreaID    uint       `json:"areaID,omitempty"`
+	RoomID    uint       `json:"roomID,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Banned    bool       `json:"banned"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// mute-audio / mute-chat を受けたことを本人に知らせる。kind は mute-audio か mute-chat
+type Muted struct {
+	Type      string     `json:"type"`
+	Kind      string     `json:"kind"`
+	AreaID    uint       `json:"areaID,omitempty"`
+	RoomID    uint       `json:"roomID,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// ルームの状態が変わった時にルームの全員に、参加した時に本人に送る
+// state は waiting / ready-check / countdown / playing / results / closed
+// deadline は ready-check / countdown / results の状態が終わる時刻
+type RoomState struct {
+	Type          string     `json:"type"`
+	RoomID        uint       `json:"roomID"`
+	State         string     `json:"state"`
+	PreviousState string     `json:"previousState,omitempty"`
+	ReadyUserIDs  []uint     `json:"readyUserIDs,omitempty"`
+	Deadline      *time.Time `json:"deadline,omitempty"`
+}
+
+// ミニゲームから送られるイベント。event と data の形はミニゲームごとに決まる
+type GameEvent struct {
+	Type   string      `json:"type"`
+	RoomID uint        `json:"roomID"`
+	Game   string      `json:"game"`
+	Event  string      `json:"event"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// ルームの所有者が変わったことをルームの全員に知らせる
+type OwnerChanged struct {
+	Type            string `json:"type"`
+	RoomID          uint   `json:"roomID"`
+	OwnerID         uint   `json:"ownerID"`
+	PreviousOwnerID uint   `json:"previousOwnerID,omitempty"`
+}
+
+type ErrorCode string
+
+// クライアントが分岐に使うため値は変更しないこと
+const (
+	CodeInvalidPayload ErrorCode = "invalid_payload"
+	CodeRoomFull       ErrorCode = "room_full"
+	CodeNotInRoom      ErrorCode = "not_in_room"
+	CodeUnauthorized   ErrorCode = "unauthorized"
+	CodeRateLimited    ErrorCode = "rate_limited"
+	CodeBanned         ErrorCode = "banned"
+	CodeAccessDenied   ErrorCode = "access_denied"
+	CodeRoomBusy       ErrorCode = "room_busy"
+	CodeConflict       ErrorCode = "conflict"
+	CodeInternal       ErrorCode = "internal"
+)
+
+type Error struct {
+	Type        string    `json:"type"`
+	Code        ErrorCode `json:"code"`
+	RequestType string    `json:"requestType,omitempty"`
+	Message     string    `json:"message"`
+}
+
+func NewError(code ErrorCode, requestType string, message string) *Error {
+	return &Error{Type: TypeError, Code: code, RequestType: requestType, Message: message}
+}
+
+// 相手に転送する offer / answer / ice-candidate。送信元などはサーバー側で上書きする
+type SignalForward struct {
+	Signal     *Signal
+	FromUserID uint
+	ToUserID   uint
+	AreaID     uint
+	RoomID     uint
+}
+
+func (s *SignalForward) MarshalJSON() ([]byte, error) {
+	payload := make(map[string]interface{}, len(s.Signal.Payload)+4)
+	for key, value := range s.Signal.Payload {
+		payload[key] = value
+	}
+	payload["fromUserID"] = s.FromUserID
+	payload["toUserID"] = s.ToUserID
+	payload["roomID"] = s.RoomID
+	if s.AreaID != 0 {
+		payload["areaID"] = s.AreaID
+	}
+	return json.Marshal(payload)
+}
@@ -0,0 +1,146 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	TypeJoinArea           = "join-area"
+	TypeJoinedArea         = "joined-area"
+	TypeJoinAudio          = "join-audio"
+	TypeJoinGame           = "join-game"
+	TypeLeaveArea          = "leave-area"
+	TypeLeaveAudio         = "leave-audio"
+	TypeLeaveGame          = "leave-game"
+	TypeLeaveRoom          = "leave-room"
+	TypeMove               = "move"
+	TypeOffer              = "offer"
+	TypeAnswer             = "answer"
+	TypeICECandidate       = "ice-candidate"
+	TypePing               = "ping"
+	TypePong               = "pong"
+	TypeDisconnectRoom     = "disconnect-room"
+	TypeDisconnectGame     = "disconnect-game"
+	TypeDisconnectAudio    = "disconnect-audio"
+	TypeError              = "error"
+	TypeSession            = "session"
+	TypeResume             = "resume"
+	TypeResumed            = "resumed"
+	TypePlayerStatus       = "player-status"
+	TypeWaiting            = "waiting"
+	TypeSubscribeLobby     = "subscribe-lobby"
+	TypeUnsubscribeLobby   = "unsubscribe-lobby"
+	TypeLobby              = "lobby"
+	TypeLobbyUpdate        = "lobby-update"
+	TypeFindMatch          = "find-match"
+	TypeCancelMatch        = "cancel-match"
+	TypeMatchSearching     = "match-searching"
+	TypeMatchFound         = "match-found"
+	TypeMatchCancelled     = "match-cancelled"
+	TypePositionCorrection = "position-correction"
+	TypeState              = "state"
+	TypeAck                = "ack"
+	TypeRequestKeyframe    = "request-keyframe"
+	TypeEnterView          = "enter-view"
+	TypeLeaveView          = "leave-view"
+	TypePeerInRange        = "peer-in-range"
+	TypePeerOutOfRange     = "peer-out-of-range"
+	TypeICEConfig          = "ice-config"
+	TypeChat               = "chat"
+	TypeChatHistory        = "chat-history"
+	TypeKick               = "kick"
+	TypeMuteAudio          = "mute-audio"
+	TypeMuteChat           = "mute-chat"
+	TypeBan                = "ban"
+	TypeKicked             = "kicked"
+	TypeMuted              = "muted"
+	TypeOwnerChanged       = "owner-changed"
+	TypeReadyCheck         = "ready-check"
+	TypeReady              = "ready"
+	TypeEndGame            = "end-game"
+	TypeRoomState          = "room-state"
+	TypeGameInput          = "game-input"
+	TypeGameEvent          = "game-event"
+)
+
+// 全メッセージ共通のフィールド
+type Envelope struct {
+	Type       string `json:"type"`
+	FromUserID *uint  `json:"fromUserID,omitempty"`
+}
+
+func (e *Envelope) Header() *Envelope {
+	return e
+}
+
+// クライアントから受信するメッセージ
+type Inbound interface {
+	Header() *Envelope
+}
+
+type validator interface {
+	validate() error
+}
+
+// 受信メッセージの形式が不正な場合のエラー
+type DecodeError struct {
+	RequestType string
+	Field       string
+	Reason      string
+}
+
+func (e *DecodeError) Error() string {
+	prefix := "invalid message"
+	if e.RequestType != "" {
+		prefix = fmt.Sprintf("invalid %q message", e.RequestType)
+	}
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", prefix, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s %s", prefix, e.Field, e.Reason)
+}
+
+func decodeEnvelope(data []byte) (map[string]json.RawMessage, string, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, "", &DecodeError{Reason: "message must be a JSON object"}
+	}
+	rawType, ok := fields["type"]
+	if !ok {
+		return nil, "", &DecodeError{Field: "type", Reason: "is required"}
+	}
+	var msgType string
+	if err := json.Unmarshal(rawType, &msgType); err != nil || msgType == "" {
+		return nil, "", &DecodeError{Field: "type", Reason: "must be a non-empty string"}
+	}
+	return fields, msgType, nil
+}
+
+// 必須フィールドの存在と型を確認してから msg にデコードする
+func decodeMessage(data []byte, fields map[string]json.RawMessage, msgType string, msg Inbound, required ...string) (Inbound, error) {
+	for _, name := range required {
+		value, ok := fields[name]
+		if !ok || string(value) == "null" {
+			return nil, &DecodeError{RequestType: msgType, Field: name, Reason: "is required"}
+		}
+	}
+	if err := json.Unmarshal(data, msg); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, &DecodeError{RequestType: msgType, Field: typeErr.Field, Reason: "must be " + typeErr.Type.String()}
+		}
+		return nil, &DecodeError{RequestType: msgType, Reason: err.Error()}
+	}
+	if v, ok := msg.(validator); ok {
+		if err := v.validate(); err != nil {
+			return nil, &DecodeError{RequestType: msgType, Reason: err.Error()}
+		}
+	}
+	return msg, nil
+}
+
+func unknownType(msgType string) error {
+	return &DecodeError{RequestType: msgType, Field: "type", Reason: "is not supported"}
+}
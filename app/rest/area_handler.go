@@ -0,0 +1,159 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/usecase"
+)
+
+type AreaHandler struct {
+	areaUsecase *usecase.AreaUsecase
+	roomUsecase *usecase.RoomUsecase
+}
+
+func NewAreaHandler(areaUsecase *usecase.AreaUsecase, roomUsecase *usecase.RoomUsecase) *AreaHandler {
+	return &AreaHandler{areaUsecase: areaUsecase, roomUsecase: roomUsecase}
+}
+
+// 参照は誰でもできるが、作成・更新・削除は管理者だけが行える
+func (h *AreaHandler) Register(g *echo.Group, auth echo.MiddlewareFunc) {
+	admin := RequireAdmin()
+	g.GET("/areas", h.List)
+	g.GET("/areas/:id", h.Get)
+	g.GET("/areas/:id/rooms", h.ListRooms)
+	g.POST("/areas", h.Create, auth, admin)
+	g.PUT("/areas/:id", h.Update, auth, admin)
+	g.DELETE("/areas/:id", h.Delete, auth, admin)
+}
+
+type areaRequest struct {
+	Name           string       `json:"name"`
+	MaxParticipant int          `json:"maxParticipant"`
+	RoomCount      int          `json:"roomCount"`
+	Status         string       `json:"status"`
+	Description    string       `json:"description"`
+	Movement       movementRule `json:"movement"`
+}
+
+func (req *areaRequest) toModel() *model.Area {
+	return &model.Area{
+		Name:           req.Name,
+		MaxParticipant: req.MaxParticipant,
+		RoomCount:      req.RoomCount,
+		Status:         req.Status,
+		Description:    req.Description,
+		MovementRule:   req.Movement.toModel(),
+	}
+}
+
+type areaResponse struct {
+	ID             uint         `json:"id"`
+	Name           string       `json:"name"`
+	MaxParticipant int          `json:"maxParticipant"`
+	RoomCount      int          `json:"roomCount"`
+	Status         string       `json:"status"`
+	Description    string       `json:"description"`
+	Movement       movementRule `json:"movement"`
+	timestamps
+}
+
+func newAreaResponse(area *model.Area) *areaResponse {
+	return &areaResponse{
+		ID:             area.ID,
+		Name:           area.Name,
+		MaxParticipant: area.MaxParticipant,
+		RoomCount:      area.RoomCount,
+		Status:         area.Status,
+		Description:    area.Description,
+		Movement:       newMovementRule(area.MovementRule),
+		timestamps:     timestamps{CreatedAt: area.CreatedAt, UpdatedAt: area.UpdatedAt},
+	}
+}
+
+func (h *AreaHandler) List(c echo.Context) error {
+	pagination, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+	areas, total, err := h.areaUsecase.ListAreas(pagination)
+	if err != nil {
+		return httpError(err)
+	}
+	items := make([]*areaResponse, 0, len(areas))
+	for _, area := range areas {
+		items = append(items, newAreaResponse(area))
+	}
+	return c.JSON(http.StatusOK, &listResponse{Items: items, Total: total, Page: pagination.Page, PerPage: pagination.PerPage})
+}
+
+func (h *AreaHandler) Get(c echo.Context) error {
+	areaID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	area, err := h.areaUsecase.GetArea(areaID)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusOK, newAreaResponse(area))
+}
+
+// エリア内のルームを現在の参加人数付きで返す
+func (h *AreaHandler) ListRooms(c echo.Context) error {
+	areaID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	pagination, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+	rooms, total, err := h.roomUsecase.ListRoomsInArea(areaID, pagination)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusOK, &listResponse{Items: newRoomResponses(rooms), Total: total, Page: pagination.Page, PerPage: pagination.PerPage})
+}
+
+func (h *AreaHandler) Create(c echo.Context) error {
+	req := &areaRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	area := req.toModel()
+	err := h.areaUsecase.CreateArea(area)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusCreated, newAreaResponse(area))
+}
+
+func (h *AreaHandler) Update(c echo.Context) error {
+	areaID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	req := &areaRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	area, err := h.areaUsecase.UpdateArea(areaID, req.toModel())
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusOK, newAreaResponse(area))
+}
+
+func (h *AreaHandler) Delete(c echo.Context) error {
+	areaID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	err = h.areaUsecase.DeleteArea(areaID)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,36 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/protocol"
+	"github.com/sako0/minigame-space-api/app/usecase"
+)
+
+type ICEServerHandler struct {
+	iceServerUsecase *usecase.ICEServerUsecase
+}
+
+func NewICEServerHandler(iceServerUsecase *usecase.ICEServerUsecase) *ICEServerHandler {
+	return &ICEServerHandler{iceServerUsecase: iceServerUsecase}
+}
+
+// TURN の認証情報はユーザーごとに発行するため認証が必要
+func (h *ICEServerHandler) Register(g *echo.Group, auth echo.MiddlewareFunc) {
+	g.GET("/ice-servers", h.Get, auth)
+}
+
+type iceServersResponse struct {
+	ICEServers []protocol.ICEServer `json:"iceServers"`
+	TTL        int                  `json:"ttl"`
+}
+
+func (h *ICEServerHandler) Get(c echo.Context) error {
+	user := c.Get(contextKeyUser).(*model.User)
+	iceConfig := h.iceServerUsecase.GetICEConfig(user.ID)
+	// 一時的な認証情報なのでキャッシュさせない
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(http.StatusOK, &iceServersResponse{ICEServers: iceConfig.ICEServers, TTL: iceConfig.TTL})
+}
@@ -0,0 +1,131 @@
+package rest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/usecase"
+)
+
+type ModerationHandler struct {
+	moderationUsecase *usecase.ModerationUsecase
+}
+
+func NewModerationHandler(moderationUsecase *usecase.ModerationUsecase) *ModerationHandler {
+	return &ModerationHandler{moderationUsecase: moderationUsecase}
+}
+
+// 管理者用。ルームの所有者は WebSocket の kick / mute-audio / mute-chat / ban を使う
+func (h *ModerationHandler) Register(g *echo.Group, auth echo.MiddlewareFunc) {
+	admin := RequireAdmin()
+	g.GET("/admin/bans", h.ListBans, auth, admin)
+	g.POST("/admin/bans", h.CreateBan, auth, admin)
+	g.DELETE("/admin/bans/:id", h.DeleteBan, auth, admin)
+	g.POST("/admin/kicks", h.Kick, auth, admin)
+}
+
+// areaID と roomID を省略した場合はすべてのエリア・ルームが対象になる
+type moderationTarget struct {
+	UserID uint `json:"userID"`
+	AreaID uint `json:"areaID"`
+	RoomID uint `json:"roomID"`
+}
+
+func (t moderationTarget) toUsecase() usecase.ModerationTarget {
+	return usecase.ModerationTarget{UserID: t.UserID, AreaID: t.AreaID, RoomID: t.RoomID}
+}
+
+type banRequest struct {
+	moderationTarget
+	Kind string `json:"kind"`
+	// 0 の場合は無期限
+	DurationSeconds int    `json:"durationSeconds"`
+	Reason          string `json:"reason"`
+}
+
+type kickRequest struct {
+	moderationTarget
+	Reason string `json:"reason"`
+}
+
+type banResponse struct {
+	ID        uint       `json:"id"`
+	UserID    uint       `json:"userID"`
+	Kind      string     `json:"kind"`
+	AreaID    uint       `json:"areaID"`
+	RoomID    uint       `json:"roomID"`
+	Reason    string     `json:"reason"`
+	IssuedBy  uint       `json:"issuedBy"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	timestamps
+}
+
+func newBanResponse(ban *model.Ban) *banResponse {
+	return &banResponse{
+		ID:         ban.ID,
+		UserID:     ban.UserID,
+		Kind:       ban.Kind,
+		AreaID:     ban.AreaID,
+		RoomID:     ban.RoomID,
+		Reason:     ban.Reason,
+		IssuedBy:   ban.IssuedBy,
+		ExpiresAt:  ban.ExpiresAt,
+		timestamps: timestamps{CreatedAt: ban.CreatedAt, UpdatedAt: ban.UpdatedAt},
+	}
+}
+
+func (h *ModerationHandler) ListBans(c echo.Context) error {
+	pagination, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+	bans, total, err := h.moderationUsecase.ListBans(pagination)
+	if err != nil {
+		return httpError(err)
+	}
+	items := make([]*banResponse, 0, len(bans))
+	for _, ban := range bans {
+		items = append(items, newBanResponse(ban))
+	}
+	return c.JSON(http.StatusOK, &listResponse{Items: items, Total: total, Page: pagination.Page, PerPage: pagination.PerPage})
+}
+
+func (h *ModerationHandler) CreateBan(c echo.Context) error {
+	req := &banRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	user := c.Get(contextKeyUser).(*model.User)
+	ban, err := h.moderationUsecase.Restrict(user.ID, req.toUsecase(), req.Kind, time.Duration(req.DurationSeconds)*time.Second, req.Reason)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusCreated, newBanResponse(ban))
+}
+
+func (h *ModerationHandler) DeleteBan(c echo.Context) error {
+	banID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	err = h.moderationUsecase.RemoveBan(banID)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *ModerationHandler) Kick(c echo.Context) error {
+	req := &kickRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	user := c.Get(contextKeyUser).(*model.User)
+	err := h.moderationUsecase.Kick(user.ID, req.toUsecase(), req.Reason)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
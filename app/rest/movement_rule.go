@@ -0,0 +1,38 @@
+package rest
+
+import (
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+// エリアとルームタイプで共通の移動のルール
+type movementRule struct {
+	MapWidth     int          `json:"mapWidth"`
+	MapHeight    int          `json:"mapHeight"`
+	MaxSpeed     int          `json:"maxSpeed"`
+	TileSize     int          `json:"tileSize"`
+	BlockedTiles []model.Tile `json:"blockedTiles"`
+}
+
+func (m *movementRule) toModel() model.MovementRule {
+	return model.MovementRule{
+		MapWidth:     m.MapWidth,
+		MapHeight:    m.MapHeight,
+		MaxSpeed:     m.MaxSpeed,
+		TileSize:     m.TileSize,
+		BlockedTiles: m.BlockedTiles,
+	}
+}
+
+func newMovementRule(rule model.MovementRule) movementRule {
+	blockedTiles := rule.BlockedTiles
+	if blockedTiles == nil {
+		blockedTiles = []model.Tile{}
+	}
+	return movementRule{
+		MapWidth:     rule.MapWidth,
+		MapHeight:    rule.MapHeight,
+		MaxSpeed:     rule.MaxSpeed,
+		TileSize:     rule.TileSize,
+		BlockedTiles: blockedTiles,
+	}
+}
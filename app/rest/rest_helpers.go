@@ -0,0 +1,113 @@
+package rest
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/usecase"
+)
+
+const defaultPerPage = 20
+
+// 認証済みユーザーを echo.Context に入れる時のキー
+const contextKeyUser = "user"
+
+// 一覧のレスポンス
+type listResponse struct {
+	Items   interface{} `json:"items"`
+	Total   int64       `json:"total"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"perPage"`
+}
+
+type timestamps struct {
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Authorization: Bearer <Firebase ID トークン> を検証する
+func RequireAuth(authUsecase *usecase.AuthUsecase) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			idToken := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			user, err := authUsecase.Authenticate(idToken)
+			if err != nil {
+				log.Printf("Error authenticating request: %v", err)
+				return echo.NewHTTPError(http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			}
+			c.Set(contextKeyUser, user)
+			return next(c)
+		}
+	}
+}
+
+// RequireAuth の後に使う。管理者以外は 403 にする
+func RequireAdmin() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := c.Get(contextKeyUser).(*model.User)
+			if !ok || !user.IsAdmin {
+				return echo.NewHTTPError(http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			}
+			return next(c)
+		}
+	}
+}
+
+// クエリパラメータ page と perPage を読み取る。範囲の確認はユースケースで行う
+func parsePagination(c echo.Context) (usecase.Pagination, error) {
+	pagination := usecase.Pagination{Page: 1, PerPage: defaultPerPage}
+	if value := c.QueryParam("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil {
+			return pagination, echo.NewHTTPError(http.StatusBadRequest, "page must be a number")
+		}
+		pagination.Page = page
+	}
+	if value := c.QueryParam("perPage"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil {
+			return pagination, echo.NewHTTPError(http.StatusBadRequest, "perPage must be a number")
+		}
+		pagination.PerPage = perPage
+	}
+	return pagination, nil
+}
+
+func parseID(c echo.Context, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil || id == 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, name+" must be a positive number")
+	}
+	return uint(id), nil
+}
+
+func bind(c echo.Context, req interface{}) error {
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	return nil
+}
+
+// ユースケースのエラーを HTTP のステータスに変換する
+func httpError(err error) error {
+	switch {
+	case errors.Is(err, usecase.ErrInvalidArgument):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, usecase.ErrNotFound), errors.Is(err, usecase.ErrNotInRoom):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, usecase.ErrConflict), errors.Is(err, usecase.ErrRoomBusy):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, usecase.ErrUnauthorized), errors.Is(err, usecase.ErrBanned), errors.Is(err, usecase.ErrAccessDenied):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	default:
+		log.Printf("Error handling request: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+	}
+}
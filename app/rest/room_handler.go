@@ -0,0 +1,284 @@
+package rest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/usecase"
+)
+
+type RoomHandler struct {
+	roomUsecase       *usecase.RoomUsecase
+	roomAccessUsecase *usecase.RoomAccessUsecase
+}
+
+func NewRoomHandler(roomUsecase *usecase.RoomUsecase, roomAccessUsecase *usecase.RoomAccessUsecase) *RoomHandler {
+	return &RoomHandler{roomUsecase: roomUsecase, roomAccessUsecase: roomAccessUsecase}
+}
+
+func (h *RoomHandler) Register(g *echo.Group, auth echo.MiddlewareFunc) {
+	g.GET("/rooms", h.List)
+	g.GET("/rooms/:id", h.Get)
+	g.POST("/rooms", h.Create, auth)
+	// 変更と削除はルームの所有者と管理者だけが行える
+	g.PUT("/rooms/:id", h.Update, auth)
+	g.DELETE("/rooms/:id", h.Delete, auth)
+	// 公開範囲・パスコード・招待コード・所有者の変更はルームの所有者と管理者だけが行える
+	g.PUT("/rooms/:id/access", h.UpdateAccess, auth)
+	g.PUT("/rooms/:id/owner", h.TransferOwnership, auth)
+	g.GET("/rooms/:id/invites", h.ListInvites, auth)
+	g.POST("/rooms/:id/invites", h.CreateInvite, auth)
+	g.DELETE("/rooms/:id/invites/:inviteId", h.DeleteInvite, auth)
+}
+
+// status はルームの状態の遷移でだけ変わるため受け付けない
+type roomRequest struct {
+	AreaID     uint `json:"areaID"`
+	RoomTypeID uint `json:"roomTypeID"`
+	// 作成時だけ使う。変更は /rooms/:id/access で行う
+	Visibility string `json:"visibility"`
+	Passcode   string `json:"passcode"`
+}
+
+func (req *roomRequest) toModel() *model.Room {
+	return &model.Room{
+		AreaID:     req.AreaID,
+		RoomTypeID: req.RoomTypeID,
+	}
+}
+
+type roomResponse struct {
+	ID             uint   `json:"id"`
+	AreaID         uint   `json:"areaID"`
+	RoomTypeID     uint   `json:"roomTypeID"`
+	OwnerID        uint   `json:"ownerID"`
+	Visibility     string `json:"visibility"`
+	HasPasscode    bool   `json:"hasPasscode"`
+	Status         int    `json:"status"`
+	State          string `json:"state"`
+	MaxParticipant int    `json:"maxParticipant"`
+	Occupancy      int    `json:"occupancy"`
+	timestamps
+}
+
+func newRoomResponse(room *usecase.RoomWithOccupancy) *roomResponse {
+	return &roomResponse{
+		ID:             room.Room.ID,
+		AreaID:         room.Room.AreaID,
+		RoomTypeID:     room.Room.RoomTypeID,
+		OwnerID:        room.Room.OwnerID,
+		Visibility:     room.Room.Visibility,
+		HasPasscode:    room.Room.PasscodeHash != "",
+		Status:         int(room.Room.Status),
+		State:          room.Room.Status.String(),
+		MaxParticipant: room.Room.RoomType.MaxParticipant,
+		Occupancy:      room.Occupancy,
+		timestamps:     timestamps{CreatedAt: room.Room.CreatedAt, UpdatedAt: room.Room.UpdatedAt},
+	}
+}
+
+func newRoomResponses(rooms []*usecase.RoomWithOccupancy) []*roomResponse {
+	items := make([]*roomResponse, 0, len(rooms))
+	for _, room := range rooms {
+		items = append(items, newRoomResponse(room))
+	}
+	return items
+}
+
+func (h *RoomHandler) List(c echo.Context) error {
+	pagination, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+	rooms, total, err := h.roomUsecase.ListRooms(pagination)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusOK, &listResponse{Items: newRoomResponses(rooms), Total: total, Page: pagination.Page, PerPage: pagination.PerPage})
+}
+
+func (h *RoomHandler) Get(c echo.Context) error {
+	roomID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	room, err := h.roomUsecase.GetRoom(roomID)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusOK, newRoomResponse(room))
+}
+
+func (h *RoomHandler) Create(c echo.Context) error {
+	req := &roomRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	// 作成したユーザーがルームの所有者になる
+	input := req.toModel()
+	input.OwnerID = c.Get(contextKeyUser).(*model.User).ID
+	input.Visibility = req.Visibility
+	room, err := h.roomUsecase.CreateRoom(input, req.Passcode)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusCreated, newRoomResponse(room))
+}
+
+func (h *RoomHandler) Update(c echo.Context) error {
+	roomID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	req := &roomRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	user := c.Get(contextKeyUser).(*model.User)
+	room, err := h.roomUsecase.UpdateRoom(user.ID, roomID, req.toModel())
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusOK, newRoomResponse(room))
+}
+
+func (h *RoomHandler) Delete(c echo.Context) error {
+	roomID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	user := c.Get(contextKeyUser).(*model.User)
+	err = h.roomUsecase.DeleteRoom(user.ID, roomID)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type roomAccessRequest struct {
+	Visibility string `json:"visibility"`
+	// 省略した場合は変更せず、空文字の場合はパスコードを外す
+	Passcode *string `json:"passcode"`
+}
+
+type roomOwnerRequest struct {
+	OwnerID uint `json:"ownerID"`
+}
+
+type roomInviteRequest struct {
+	// 0 の場合は無期限
+	TTLSeconds int `json:"ttlSeconds"`
+	// 0 の場合は回数の制限なし
+	MaxUses int `json:"maxUses"`
+}
+
+type roomInviteResponse struct {
+	ID        uint       `json:"id"`
+	RoomID    uint       `json:"roomID"`
+	Code      string     `json:"code"`
+	CreatedBy uint       `json:"createdBy"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	MaxUses   int        `json:"maxUses"`
+	Uses      int        `json:"uses"`
+	timestamps
+}
+
+func newRoomInviteResponse(invite *model.RoomInvite) *roomInviteResponse {
+	return &roomInviteResponse{
+		ID:         invite.ID,
+		RoomID:     invite.RoomID,
+		Code:       invite.Code,
+		CreatedBy:  invite.CreatedBy,
+		ExpiresAt:  invite.ExpiresAt,
+		MaxUses:    invite.MaxUses,
+		Uses:       invite.Uses,
+		timestamps: timestamps{CreatedAt: invite.CreatedAt, UpdatedAt: invite.UpdatedAt},
+	}
+}
+
+func (h *RoomHandler) UpdateAccess(c echo.Context) error {
+	roomID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	req := &roomAccessRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	user := c.Get(contextKeyUser).(*model.User)
+	_, err = h.roomAccessUsecase.UpdateAccess(user.ID, roomID, req.Visibility, req.Passcode)
+	if err != nil {
+		return httpError(err)
+	}
+	return h.Get(c)
+}
+
+func (h *RoomHandler) TransferOwnership(c echo.Context) error {
+	roomID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	req := &roomOwnerRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	user := c.Get(contextKeyUser).(*model.User)
+	_, err = h.roomAccessUsecase.TransferOwnership(user.ID, roomID, req.OwnerID)
+	if err != nil {
+		return httpError(err)
+	}
+	return h.Get(c)
+}
+
+func (h *RoomHandler) ListInvites(c echo.Context) error {
+	roomID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	user := c.Get(contextKeyUser).(*model.User)
+	invites, err := h.roomAccessUsecase.ListInvites(user.ID, roomID)
+	if err != nil {
+		return httpError(err)
+	}
+	items := make([]*roomInviteResponse, 0, len(invites))
+	for _, invite := range invites {
+		items = append(items, newRoomInviteResponse(invite))
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+func (h *RoomHandler) CreateInvite(c echo.Context) error {
+	roomID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	req := &roomInviteRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	user := c.Get(contextKeyUser).(*model.User)
+	invite, err := h.roomAccessUsecase.CreateInvite(user.ID, roomID, time.Duration(req.TTLSeconds)*time.Second, req.MaxUses)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusCreated, newRoomInviteResponse(invite))
+}
+
+func (h *RoomHandler) DeleteInvite(c echo.Context) error {
+	roomID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	inviteID, err := parseID(c, "inviteId")
+	if err != nil {
+		return err
+	}
+	user := c.Get(contextKeyUser).(*model.User)
+	err = h.roomAccessUsecase.RevokeInvite(user.ID, roomID, inviteID)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
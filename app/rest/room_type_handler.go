@@ -0,0 +1,148 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/usecase"
+)
+
+type RoomTypeHandler struct {
+	roomTypeUsecase *usecase.RoomTypeUsecase
+}
+
+func NewRoomTypeHandler(roomTypeUsecase *usecase.RoomTypeUsecase) *RoomTypeHandler {
+	return &RoomTypeHandler{roomTypeUsecase: roomTypeUsecase}
+}
+
+// 参照は誰でもできるが、作成・更新・削除は管理者だけが行える
+func (h *RoomTypeHandler) Register(g *echo.Group, auth echo.MiddlewareFunc) {
+	admin := RequireAdmin()
+	g.GET("/room-types", h.List)
+	g.GET("/room-types/:id", h.Get)
+	g.POST("/room-types", h.Create, auth, admin)
+	g.PUT("/room-types/:id", h.Update, auth, admin)
+	g.DELETE("/room-types/:id", h.Delete, auth, admin)
+}
+
+type roomTypeRequest struct {
+	Name           string       `json:"name"`
+	MaxParticipant int          `json:"maxParticipant"`
+	Description    string       `json:"description"`
+	Movement       movementRule `json:"movement"`
+	VoiceMode      string       `json:"voiceMode"`
+	Minigame       string       `json:"minigame"`
+}
+
+func (req *roomTypeRequest) toModel() *model.RoomType {
+	return &model.RoomType{
+		Name:           req.Name,
+		MaxParticipant: req.MaxParticipant,
+		Description:    req.Description,
+		MovementRule:   req.Movement.toModel(),
+		VoiceMode:      req.VoiceMode,
+		Minigame:       req.Minigame,
+	}
+}
+
+type roomTypeResponse struct {
+	ID             uint         `json:"id"`
+	Name           string       `json:"name"`
+	MaxParticipant int          `json:"maxParticipant"`
+	Description    string       `json:"description"`
+	Movement       movementRule `json:"movement"`
+	VoiceMode      string       `json:"voiceMode"`
+	Minigame       string       `json:"minigame"`
+	timestamps
+}
+
+func newRoomTypeResponse(roomType *model.RoomType) *roomTypeResponse {
+	return &roomTypeResponse{
+		ID:             roomType.ID,
+		Name:           roomType.Name,
+		MaxParticipant: roomType.MaxParticipant,
+		Description:    roomType.Description,
+		Movement:       newMovementRule(roomType.MovementRule),
+		VoiceMode:      voiceModeOrDefault(roomType.VoiceMode),
+		Minigame:       roomType.Minigame,
+		timestamps:     timestamps{CreatedAt: roomType.CreatedAt, UpdatedAt: roomType.UpdatedAt},
+	}
+}
+
+// 追加前に作られたルームタイプは空になっている
+func voiceModeOrDefault(voiceMode string) string {
+	if voiceMode == "" {
+		return model.VoiceModeMesh
+	}
+	return voiceMode
+}
+
+func (h *RoomTypeHandler) List(c echo.Context) error {
+	pagination, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+	roomTypes, total, err := h.roomTypeUsecase.ListRoomTypes(pagination)
+	if err != nil {
+		return httpError(err)
+	}
+	items := make([]*roomTypeResponse, 0, len(roomTypes))
+	for _, roomType := range roomTypes {
+		items = append(items, newRoomTypeResponse(roomType))
+	}
+	return c.JSON(http.StatusOK, &listResponse{Items: items, Total: total, Page: pagination.Page, PerPage: pagination.PerPage})
+}
+
+func (h *RoomTypeHandler) Get(c echo.Context) error {
+	roomTypeID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	roomType, err := h.roomTypeUsecase.GetRoomType(roomTypeID)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusOK, newRoomTypeResponse(roomType))
+}
+
+func (h *RoomTypeHandler) Create(c echo.Context) error {
+	req := &roomTypeRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	roomType := req.toModel()
+	err := h.roomTypeUsecase.CreateRoomType(roomType)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusCreated, newRoomTypeResponse(roomType))
+}
+
+func (h *RoomTypeHandler) Update(c echo.Context) error {
+	roomTypeID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	req := &roomTypeRequest{}
+	if err := bind(c, req); err != nil {
+		return err
+	}
+	roomType, err := h.roomTypeUsecase.UpdateRoomType(roomTypeID, req.toModel())
+	if err != nil {
+		return httpError(err)
+	}
+	return c.JSON(http.StatusOK, newRoomTypeResponse(roomType))
+}
+
+func (h *RoomTypeHandler) Delete(c echo.Context) error {
+	roomTypeID, err := parseID(c, "id")
+	if err != nil {
+		return err
+	}
+	err = h.roomTypeUsecase.DeleteRoomType(roomTypeID)
+	if err != nil {
+		return httpError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,341 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// クライアントへのメッセージの送信先。再接続で接続が差し替わることがあるため、送信のたびに呼ぶ
+type Sender interface {
+	Send(msg interface{}) error
+}
+
+type Options struct {
+	ICEServers []webrtc.ICEServer
+	// NAT の外側のアドレス。指定した場合は host 候補のアドレスをこれに置き換える
+	PublicIPs []string
+	// 0 の場合は OS に任せる
+	PortMin uint16
+	PortMax uint16
+}
+
+// ルームごとに各クライアントと 1 本ずつ PeerConnection を張り、受け取った音声を同じルームの他のクライアントに転送する
+// offer は常にサーバーから送り、クライアントは answer と ice-candidate を返す
+type SFU struct {
+	api    *webrtc.API
+	config webrtc.Configuration
+
+	mu    sync.Mutex
+	rooms map[uint]*room // Key: roomID
+	peers map[uint]*peer // Key: userID
+	// 送信トラックの追加と削除が重ならないよう、再ネゴシエーションは 1 つずつ行う
+	negotiateMu sync.Mutex
+}
+
+var ErrNotJoined = errors.New("not joined to an SFU room")
+
+type room struct {
+	id     uint
+	peers  map[uint]*peer                         // Key: userID
+	tracks map[string]*webrtc.TrackLocalStaticRTP // Key: トラックID
+	owners map[string]uint                        // Key: トラックID, Value: 送信元の userID
+}
+
+type peer struct {
+	userID uint
+	roomID uint
+	pc     *webrtc.PeerConnection
+	sender Sender
+
+	// answer を待っている間に再ネゴシエーションが必要になった場合は、answer を受け取ってからやり直す
+	mu      sync.Mutex
+	pending bool
+}
+
+func New(options Options) (*SFU, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("New: %v", err)
+	}
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, fmt.Errorf("New: %v", err)
+	}
+	settingEngine := webrtc.SettingEngine{}
+	if len(options.PublicIPs) > 0 {
+		settingEngine.SetNAT1To1IPs(options.PublicIPs, webrtc.ICECandidateTypeHost)
+	}
+	if options.PortMin != 0 || options.PortMax != 0 {
+		if err := settingEngine.SetEphemeralUDPPortRange(options.PortMin, options.PortMax); err != nil {
+			return nil, fmt.Errorf("New: %v", err)
+		}
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(registry), webrtc.WithSettingEngine(settingEngine))
+	return &SFU{
+		api:    api,
+		config: webrtc.Configuration{ICEServers: options.ICEServers},
+		rooms:  make(map[uint]*room),
+		peers:  make(map[uint]*peer),
+	}, nil
+}
+
+// ルームに参加させ、サーバーからの offer を送る。別のルームに参加していた場合はそちらから抜ける
+func (s *SFU) Join(roomID uint, userID uint, sender Sender) error {
+	s.Leave(userID)
+
+	pc, err := s.api.NewPeerConnection(s.config)
+	if err != nil {
+		return fmt.Errorf("Join: %v", err)
+	}
+	// クライアントからは音声を 1 本受け取る
+	_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("Join: %v", err)
+	}
+	p := &peer{userID: userID, roomID: roomID, pc: pc, sender: sender}
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		data, err := json.Marshal(candidate.ToJSON())
+		if err != nil {
+			log.Printf("Error marshaling ICE candidate: %v", err)
+			return
+		}
+		p.send(&protocol.ServerSignal{Type: protocol.TypeICECandidate, RoomID: roomID, Candidate: data})
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed:
+			pc.Close()
+		case webrtc.PeerConnectionStateClosed:
+			s.remove(p)
+		}
+	})
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		s.forward(p, remote)
+	})
+
+	s.mu.Lock()
+	r, ok := s.rooms[roomID]
+	if !ok {
+		r = &room{id: roomID, peers: make(map[uint]*peer), tracks: make(map[string]*webrtc.TrackLocalStaticRTP), owners: make(map[string]uint)}
+		s.rooms[roomID] = r
+	}
+	r.peers[userID] = p
+	s.peers[userID] = p
+	s.mu.Unlock()
+
+	s.renegotiate(roomID)
+	return nil
+}
+
+// ルームから抜けて PeerConnection を閉じる。参加していなければ何もしない
+func (s *SFU) Leave(userID uint) {
+	s.mu.Lock()
+	p, ok := s.peers[userID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	s.remove(p)
+	p.pc.Close()
+}
+
+func (s *SFU) remove(p *peer) {
+	s.mu.Lock()
+	if current, ok := s.peers[p.userID]; !ok || current != p {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.peers, p.userID)
+	r := s.rooms[p.roomID]
+	delete(r.peers, p.userID)
+	for trackID, owner := range r.owners {
+		if owner == p.userID {
+			delete(r.tracks, trackID)
+			delete(r.owners, trackID)
+		}
+	}
+	if len(r.peers) == 0 {
+		delete(s.rooms, p.roomID)
+	}
+	s.mu.Unlock()
+
+	s.renegotiate(p.roomID)
+}
+
+// answer / ice-candidate を受け取る。サーバー宛ての offer は受け付けない
+func (s *SFU) HandleSignal(userID uint, signal *protocol.Signal) error {
+	s.mu.Lock()
+	p, ok := s.peers[userID]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("HandleSignal: user %d: %w", userID, ErrNotJoined)
+	}
+
+	switch signal.Type {
+	case protocol.TypeAnswer:
+		var sdp string
+		if err := json.Unmarshal(signal.Payload["sdp"], &sdp); err != nil {
+			return fmt.Errorf("HandleSignal: sdp must be a string")
+		}
+		p.mu.Lock()
+		err := p.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp})
+		pending := p.pending
+		p.pending = false
+		p.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("HandleSignal: %v", err)
+		}
+		if pending {
+			s.offer(p)
+		}
+		return nil
+	case protocol.TypeICECandidate:
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal(signal.Payload["candidate"], &candidate); err != nil {
+			return fmt.Errorf("HandleSignal: candidate is invalid")
+		}
+		if err := p.pc.AddICECandidate(candidate); err != nil {
+			return fmt.Errorf("HandleSignal: %v", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("HandleSignal: %s to the server is not supported", signal.Type)
+	}
+}
+
+// クライアントから届いた音声をルームのトラックとして登録し、他のクライアントに転送し続ける
+func (s *SFU) forward(p *peer, remote *webrtc.TrackRemote) {
+	// stream ID で誰の音声かをクライアントが判別できるようにする
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, remote.ID(), fmt.Sprintf("user-%d", p.userID))
+	if err != nil {
+		log.Printf("Error creating local track: %v", err)
+		return
+	}
+	s.mu.Lock()
+	r, ok := s.rooms[p.roomID]
+	if !ok || r.peers[p.userID] != p {
+		s.mu.Unlock()
+		return
+	}
+	r.tracks[local.ID()] = local
+	r.owners[local.ID()] = p.userID
+	s.mu.Unlock()
+	s.renegotiate(p.roomID)
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			break
+		}
+		if _, err := local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	if r.tracks[local.ID()] == local {
+		delete(r.tracks, local.ID())
+		delete(r.owners, local.ID())
+	}
+	s.mu.Unlock()
+	s.renegotiate(p.roomID)
+}
+
+// ルームの全員について、他のクライアントの音声だけを送るように送信トラックを揃え、offer を送り直す
+func (s *SFU) renegotiate(roomID uint) {
+	s.mu.Lock()
+	r, ok := s.rooms[roomID]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	peers := make([]*peer, 0, len(r.peers))
+	for _, p := range r.peers {
+		peers = append(peers, p)
+	}
+	tracks := make(map[string]*webrtc.TrackLocalStaticRTP, len(r.tracks))
+	owners := make(map[string]uint, len(r.owners))
+	for trackID, track := range r.tracks {
+		tracks[trackID] = track
+		owners[trackID] = r.owners[trackID]
+	}
+	s.mu.Unlock()
+
+	s.negotiateMu.Lock()
+	defer s.negotiateMu.Unlock()
+	for _, p := range peers {
+		if err := p.syncTracks(tracks, owners); err != nil {
+			log.Printf("Error syncing tracks for user %d: %v", p.userID, err)
+			continue
+		}
+		s.offer(p)
+	}
+}
+
+func (p *peer) syncTracks(tracks map[string]*webrtc.TrackLocalStaticRTP, owners map[string]uint) error {
+	sending := make(map[string]bool)
+	for _, sender := range p.pc.GetSenders() {
+		track := sender.Track()
+		if track == nil {
+			continue
+		}
+		sending[track.ID()] = true
+		if _, ok := tracks[track.ID()]; !ok {
+			if err := p.pc.RemoveTrack(sender); err != nil {
+				return err
+			}
+		}
+	}
+	for trackID, track := range tracks {
+		if sending[trackID] || owners[trackID] == p.userID {
+			continue
+		}
+		if _, err := p.pc.AddTrack(track); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SFU) offer(p *peer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return
+	}
+	if p.pc.SignalingState() != webrtc.SignalingStateStable {
+		p.pending = true
+		return
+	}
+	offer, err := p.pc.CreateOffer(nil)
+	if err != nil {
+		log.Printf("Error creating offer for user %d: %v", p.userID, err)
+		return
+	}
+	if err := p.pc.SetLocalDescription(offer); err != nil {
+		log.Printf("Error setting local description for user %d: %v", p.userID, err)
+		return
+	}
+	p.send(&protocol.ServerSignal{Type: protocol.TypeOffer, RoomID: p.roomID, SDP: offer.SDP})
+}
+
+func (p *peer) send(msg *protocol.ServerSignal) {
+	msg.From = protocol.SignalTargetServer
+	if err := p.sender.Send(msg); err != nil {
+		log.Printf("Error sending SFU signal to user %d: %v", p.userID, err)
+	}
+}
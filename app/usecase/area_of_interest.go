@@ -0,0 +1,88 @@
+package usecase
+
+import "sync"
+
+// エリア内で互いに近くにいるユーザーの組を管理する。組は双方向で、同じ半径を使う
+// radius 以内に近づくと組になり、exitRadius より離れるまでは組のままにする
+type areaOfInterest struct {
+	radius     int
+	exitRadius int
+
+	mu      sync.Mutex
+	visible map[uint]map[uint]struct{} // Key: userID, Value: 視界に入っている userID
+}
+
+func newAreaOfInterest(radius int, exitRadius int) *areaOfInterest {
+	if exitRadius < radius {
+		exitRadius = radius
+	}
+	return &areaOfInterest{radius: radius, exitRadius: exitRadius, visible: make(map[uint]map[uint]struct{})}
+}
+
+// inRange は radius 以内、inExitRange は exitRadius 以内のユーザー
+// userID の組を求め直し、新しく組になったユーザーと外れたユーザーを返す
+func (a *areaOfInterest) Update(userID uint, inRange []uint, inExitRange []uint) (entered []uint, left []uint) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	previous := a.visible[userID]
+	current := make(map[uint]struct{}, len(inExitRange))
+	for _, otherID := range inExitRange {
+		// すでに組になっているユーザーは exitRadius を超えるまで外さない
+		if _, ok := previous[otherID]; ok {
+			current[otherID] = struct{}{}
+		}
+	}
+	for _, otherID := range inRange {
+		if otherID == userID {
+			continue
+		}
+		current[otherID] = struct{}{}
+		if _, ok := previous[otherID]; !ok {
+			entered = append(entered, otherID)
+			a.linkLocked(otherID, userID)
+		}
+	}
+	for otherID := range previous {
+		if _, ok := current[otherID]; !ok {
+			left = append(left, otherID)
+			delete(a.visible[otherID], userID)
+		}
+	}
+	a.visible[userID] = current
+	return entered, left
+}
+
+func (a *areaOfInterest) linkLocked(userID uint, otherID uint) {
+	others, ok := a.visible[userID]
+	if !ok {
+		others = make(map[uint]struct{})
+		a.visible[userID] = others
+	}
+	others[otherID] = struct{}{}
+}
+
+// エリアから外れたユーザーを取り除き、そのユーザーが視界に入っていたユーザーを返す
+func (a *areaOfInterest) Remove(userID uint) []uint {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	left := make([]uint, 0, len(a.visible[userID]))
+	for otherID := range a.visible[userID] {
+		left = append(left, otherID)
+		delete(a.visible[otherID], userID)
+	}
+	delete(a.visible, userID)
+	return left
+}
+
+// otherID が userID の視界に入っているか。自分自身は常に見える
+func (a *areaOfInterest) CanSee(userID uint, otherID uint) bool {
+	if userID == otherID {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.visible[userID][otherID]
+	return ok
+}
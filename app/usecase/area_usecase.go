@@ -0,0 +1,97 @@
+package usecase
+
+import (
+	"fmt"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+)
+
+type AreaUsecase struct {
+	areaRepo repository.AreaRepository
+	roomRepo repository.RoomRepository
+}
+
+func NewAreaUsecase(areaRepo repository.AreaRepository, roomRepo repository.RoomRepository) *AreaUsecase {
+	return &AreaUsecase{areaRepo: areaRepo, roomRepo: roomRepo}
+}
+
+func (uc *AreaUsecase) GetArea(areaID uint) (*model.Area, error) {
+	area, exists, err := uc.areaRepo.GetArea(areaID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: area %d", ErrNotFound, areaID)
+	}
+	return area, nil
+}
+
+func (uc *AreaUsecase) ListAreas(pagination Pagination) ([]*model.Area, int64, error) {
+	if err := pagination.validate(); err != nil {
+		return nil, 0, err
+	}
+	return uc.areaRepo.GetAreas(pagination.offset(), pagination.PerPage)
+}
+
+func (uc *AreaUsecase) CreateArea(area *model.Area) error {
+	if err := validateArea(area); err != nil {
+		return err
+	}
+	return uc.areaRepo.AddArea(area)
+}
+
+func (uc *AreaUsecase) UpdateArea(areaID uint, input *model.Area) (*model.Area, error) {
+	if err := validateArea(input); err != nil {
+		return nil, err
+	}
+	area, err := uc.GetArea(areaID)
+	if err != nil {
+		return nil, err
+	}
+	area.Name = input.Name
+	area.MaxParticipant = input.MaxParticipant
+	area.RoomCount = input.RoomCount
+	area.Status = input.Status
+	area.Description = input.Description
+	area.MovementRule = input.MovementRule
+	err = uc.areaRepo.UpdateArea(area)
+	if err != nil {
+		return nil, err
+	}
+	return area, nil
+}
+
+// ルームが残っているエリアは削除できない
+func (uc *AreaUsecase) DeleteArea(areaID uint) error {
+	if _, err := uc.GetArea(areaID); err != nil {
+		return err
+	}
+	count, err := uc.roomRepo.CountRoomsByAreaId(areaID)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: area %d still has %d rooms", ErrConflict, areaID, count)
+	}
+	return uc.areaRepo.RemoveArea(areaID)
+}
+
+func validateArea(area *model.Area) error {
+	if err := validateLength("name", area.Name, true); err != nil {
+		return err
+	}
+	if err := validateLength("status", area.Status, false); err != nil {
+		return err
+	}
+	if err := validateLength("description", area.Description, false); err != nil {
+		return err
+	}
+	if area.MaxParticipant < 0 {
+		return fmt.Errorf("%w: maxParticipant must not be negative", ErrInvalidArgument)
+	}
+	if area.RoomCount < 0 {
+		return fmt.Errorf("%w: roomCount must not be negative", ErrInvalidArgument)
+	}
+	return validateMovementRule(&area.MovementRule)
+}
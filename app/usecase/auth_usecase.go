@@ -0,0 +1,48 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sako0/minigame-space-api/app/auth"
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+)
+
+type AuthUsecase struct {
+	verifier auth.TokenVerifier
+	userRepo repository.UserRepository
+}
+
+func NewAuthUsecase(verifier auth.TokenVerifier, userRepo repository.UserRepository) *AuthUsecase {
+	return &AuthUsecase{verifier: verifier, userRepo: userRepo}
+}
+
+// ID トークンを検証し、対応する User を返す。初回アクセスの場合は User を作成する
+func (ac *AuthUsecase) Authenticate(idToken string) (*model.User, error) {
+	token, err := ac.verifier.Verify(idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, exists, err := ac.userRepo.GetUserByFirebaseUID(token.UID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return user, nil
+	}
+
+	log.Printf("user is not exist. create user for firebaseUID: %s", token.UID)
+	user = model.NewUser(token.UID)
+	err = ac.userRepo.AddUser(user)
+	if err != nil {
+		// 同時接続で先に作成された場合は作成済みの User を使う
+		existing, exists, getErr := ac.userRepo.GetUserByFirebaseUID(token.UID)
+		if getErr == nil && exists {
+			return existing, nil
+		}
+		return nil, fmt.Errorf("failed to add user: %w", err)
+	}
+	return user, nil
+}
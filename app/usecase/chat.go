@@ -0,0 +1,156 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// scope に応じて送信者が参加中のエリア・ルーム、または同じエリアかルームにいる相手に送る。送信者本人にも同じものを返す
+func (uc *UserLocationUsecase) SendChatMessage(userLocation *model.UserLocation, msg *protocol.Chat) error {
+	if _, joined := uc.inMemoryUserLocationRepo.Find(userLocation.UserID); !joined {
+		return fmt.Errorf("%w: user %d has not joined any area or room", ErrNotInRoom, userLocation.UserID)
+	}
+	chatMessage := &model.ChatMessage{Scope: msg.Scope, FromUserID: userLocation.UserID, Text: msg.Text}
+	var target *model.UserLocation
+	switch msg.Scope {
+	case model.ChatScopeArea:
+		if userLocation.AreaID == 0 {
+			return fmt.Errorf("%w: user %d has not joined any area", ErrNotInRoom, userLocation.UserID)
+		}
+		chatMessage.AreaID = userLocation.AreaID
+	case model.ChatScopeRoom:
+		if userLocation.RoomID == 0 {
+			return fmt.Errorf("%w: user %d has not joined any room", ErrNotInRoom, userLocation.UserID)
+		}
+		chatMessage.AreaID = userLocation.AreaID
+		chatMessage.RoomID = userLocation.RoomID
+	case model.ChatScopeDirect:
+		targetUserLocation, ok := uc.inMemoryUserLocationRepo.Find(msg.ToUserID)
+		sameArea := ok && userLocation.AreaID != 0 && userLocation.AreaID == targetUserLocation.AreaID
+		sameRoom := ok && userLocation.RoomID != 0 && userLocation.RoomID == targetUserLocation.RoomID
+		if !sameArea && !sameRoom {
+			return fmt.Errorf("%w: user %d is not in the same area or room as user %d", ErrNotInRoom, msg.ToUserID, userLocation.UserID)
+		}
+		target = targetUserLocation
+		chatMessage.ToUserID = msg.ToUserID
+	default:
+		return fmt.Errorf("%w: unsupported chat scope %q", ErrInvalidArgument, msg.Scope)
+	}
+
+	err := checkBan(uc.banRepo, model.BanKindMuteChat, userLocation.UserID, userLocation.AreaID, userLocation.RoomID)
+	if err != nil {
+		return err
+	}
+	err = uc.chatUsecase.PostMessage(chatMessage)
+	if err != nil {
+		return err
+	}
+	event := model.NewMessage(newChatMessageEvent(chatMessage))
+	switch msg.Scope {
+	case model.ChatScopeArea:
+		return uc.SendMessageToSameArea(userLocation, event)
+	case model.ChatScopeRoom:
+		err = userLocation.Conn.Send(event.Payload)
+		if err != nil {
+			return err
+		}
+		return uc.SendMessageToSameRoom(userLocation, event)
+	default:
+		err = userLocation.Conn.Send(event.Payload)
+		if err != nil {
+			return err
+		}
+		err = target.Conn.Send(event.Payload)
+		if err != nil {
+			log.Printf("Error sending message to client: %v", err)
+			uc.DisconnectUserLocation(target)
+		}
+		return nil
+	}
+}
+
+// エリアに参加したユーザーにエリアと自分宛ての最近のメッセージを送る
+func (uc *UserLocationUsecase) sendAreaChatHistory(userLocation *model.UserLocation) {
+	areaHistory, err := uc.chatUsecase.GetAreaHistory(userLocation.AreaID)
+	if err != nil {
+		log.Printf("Error getting chat history for area %d: %v", userLocation.AreaID, err)
+		return
+	}
+	directHistory, err := uc.chatUsecase.GetDirectHistory(userLocation.UserID)
+	if err != nil {
+		log.Printf("Error getting direct chat history for user %d: %v", userLocation.UserID, err)
+		return
+	}
+	for _, history := range []*protocol.ChatHistory{areaHistory, directHistory} {
+		if err := userLocation.Conn.Send(history); err != nil {
+			log.Printf("Error sending message to client: %v", err)
+			return
+		}
+	}
+}
+
+func (uc *UserLocationUsecase) sendRoomChatHistory(userLocation *model.UserLocation) {
+	history, err := uc.chatUsecase.GetRoomHistory(userLocation.RoomID)
+	if err != nil {
+		log.Printf("Error getting chat history for room %d: %v", userLocation.RoomID, err)
+		return
+	}
+	if err := userLocation.Conn.Send(history); err != nil {
+		log.Printf("Error sending message to client: %v", err)
+	}
+}
+
+// /game にエリアは無いため、scope は room と同じルームの相手への direct だけ受け付ける
+func (ugc *UserGameLocationUsecase) SendChatMessage(userGameLocation *model.UserGameLocation, msg *protocol.Chat) error {
+	if _, joined := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); !joined || userGameLocation.RoomID == 0 {
+		return fmt.Errorf("%w: user %d has not joined any room", ErrNotInRoom, userGameLocation.UserID)
+	}
+	chatMessage := &model.ChatMessage{Scope: msg.Scope, RoomID: userGameLocation.RoomID, FromUserID: userGameLocation.UserID, Text: msg.Text}
+	switch msg.Scope {
+	case model.ChatScopeRoom:
+	case model.ChatScopeDirect:
+		targetUserGameLocation, ok := ugc.inMemoryUserGameLocationRepo.Find(msg.ToUserID)
+		if !ok || targetUserGameLocation.RoomID != userGameLocation.RoomID {
+			return fmt.Errorf("%w: user %d is not in the same room as user %d", ErrNotInRoom, msg.ToUserID, userGameLocation.UserID)
+		}
+		chatMessage.ToUserID = msg.ToUserID
+	default:
+		return fmt.Errorf("%w: unsupported chat scope %q", ErrInvalidArgument, msg.Scope)
+	}
+
+	areaID, err := ugc.areaOfRoom(userGameLocation.RoomID)
+	if err != nil {
+		return err
+	}
+	err = checkBan(ugc.banRepo, model.BanKindMuteChat, userGameLocation.UserID, areaID, userGameLocation.RoomID)
+	if err != nil {
+		return err
+	}
+	err = ugc.chatUsecase.PostMessage(chatMessage)
+	if err != nil {
+		return err
+	}
+	event := model.NewMessage(newChatMessageEvent(chatMessage))
+	if msg.Scope == model.ChatScopeRoom {
+		return ugc.SendMessageToSameRoom(userGameLocation, event)
+	}
+	err = ugc.deliver(userGameLocation, event.Payload)
+	if err != nil {
+		return err
+	}
+	return ugc.SendMessageToSpecificUser(userGameLocation, event, msg.ToUserID)
+}
+
+func (ugc *UserGameLocationUsecase) sendRoomChatHistory(userGameLocation *model.UserGameLocation) {
+	history, err := ugc.chatUsecase.GetRoomHistory(userGameLocation.RoomID)
+	if err != nil {
+		log.Printf("Error getting chat history for room %d: %v", userGameLocation.RoomID, err)
+		return
+	}
+	if err := ugc.deliver(userGameLocation, history); err != nil {
+		log.Printf("Error sending message to client: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// チャットの保存と履歴の取得。宛先への配信はエリア・ルームの接続を持つ各ユースケースで行う
+type ChatUsecase struct {
+	chatMessageRepo repository.ChatMessageRepository
+	maxLength       int
+	historyLimit    int
+	limiter         *chatRateLimiter
+}
+
+func NewChatUsecase(chatMessageRepo repository.ChatMessageRepository, maxLength int, historyLimit int, rateBurst int, rateInterval time.Duration) *ChatUsecase {
+	return &ChatUsecase{
+		chatMessageRepo: chatMessageRepo,
+		maxLength:       maxLength,
+		historyLimit:    historyLimit,
+		limiter:         newChatRateLimiter(rateBurst, rateInterval),
+	}
+}
+
+// 長さと送信間隔を確認して保存する。保存後は ID と送信時刻が入る
+func (uc *ChatUsecase) PostMessage(chatMessage *model.ChatMessage) error {
+	chatMessage.Text = strings.TrimSpace(chatMessage.Text)
+	if chatMessage.Text == "" {
+		return fmt.Errorf("%w: text must not be empty", ErrInvalidArgument)
+	}
+	if utf8.RuneCountInString(chatMessage.Text) > uc.maxLength {
+		return fmt.Errorf("%w: text must be at most %d characters", ErrInvalidArgument, uc.maxLength)
+	}
+	if !uc.limiter.allow(chatMessage.FromUserID, time.Now()) {
+		return fmt.Errorf("%w: user %d is sending chat messages too fast", ErrRateLimited, chatMessage.FromUserID)
+	}
+	return uc.chatMessageRepo.AddChatMessage(chatMessage)
+}
+
+func (uc *ChatUsecase) GetAreaHistory(areaID uint) (*protocol.ChatHistory, error) {
+	chatMessages, err := uc.chatMessageRepo.GetRecentChatMessagesByAreaId(areaID, uc.historyLimit)
+	if err != nil {
+		return nil, err
+	}
+	return newChatHistory(model.ChatScopeArea, areaID, 0, chatMessages), nil
+}
+
+func (uc *ChatUsecase) GetRoomHistory(roomID uint) (*protocol.ChatHistory, error) {
+	chatMessages, err := uc.chatMessageRepo.GetRecentChatMessagesByRoomId(roomID, uc.historyLimit)
+	if err != nil {
+		return nil, err
+	}
+	return newChatHistory(model.ChatScopeRoom, 0, roomID, chatMessages), nil
+}
+
+func (uc *ChatUsecase) GetDirectHistory(userID uint) (*protocol.ChatHistory, error) {
+	chatMessages, err := uc.chatMessageRepo.GetRecentDirectChatMessages(userID, uc.historyLimit)
+	if err != nil {
+		return nil, err
+	}
+	return newChatHistory(model.ChatScopeDirect, 0, 0, chatMessages), nil
+}
+
+func newChatHistory(scope string, areaID uint, roomID uint, chatMessages []*model.ChatMessage) *protocol.ChatHistory {
+	messages := make([]protocol.ChatMessage, 0, len(chatMessages))
+	for _, chatMessage := range chatMessages {
+		messages = append(messages, *newChatMessageEvent(chatMessage))
+	}
+	return &protocol.ChatHistory{
+		Type:     protocol.TypeChatHistory,
+		Scope:    scope,
+		AreaID:   areaID,
+		RoomID:   roomID,
+		Messages: messages,
+	}
+}
+
+func newChatMessageEvent(chatMessage *model.ChatMessage) *protocol.ChatMessage {
+	return &protocol.ChatMessage{
+		Type:       protocol.TypeChat,
+		ID:         chatMessage.ID,
+		Scope:      chatMessage.Scope,
+		FromUserID: chatMessage.FromUserID,
+		ToUserID:   chatMessage.ToUserID,
+		AreaID:     chatMessage.AreaID,
+		RoomID:     chatMessage.RoomID,
+		Text:       chatMessage.Text,
+		SentAt:     chatMessage.CreatedAt,
+	}
+}
+
+// これを超えたら送信枠が満タンに戻ったユーザーを忘れる
+const maxChatRateBuckets = 1024
+
+// ユーザーごとのトークンバケット。burst 件まで続けて送れ、interval ごとに 1 件分戻る
+type chatRateLimiter struct {
+	mu       sync.Mutex
+	burst    int
+	interval time.Duration
+	buckets  map[uint]*chatRateBucket
+}
+
+type chatRateBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func newChatRateLimiter(burst int, interval time.Duration) *chatRateLimiter {
+	return &chatRateLimiter{burst: burst, interval: interval, buckets: make(map[uint]*chatRateBucket)}
+}
+
+func (l *chatRateLimiter) allow(userID uint, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[userID]
+	if !ok {
+		if len(l.buckets) >= maxChatRateBuckets {
+			l.prune(now)
+		}
+		bucket = &chatRateBucket{tokens: float64(l.burst), updatedAt: now}
+		l.buckets[userID] = bucket
+	}
+	l.refill(bucket, now)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+func (l *chatRateLimiter) refill(bucket *chatRateBucket, now time.Time) {
+	bucket.tokens += float64(now.Sub(bucket.updatedAt)) / float64(l.interval)
+	if bucket.tokens > float64(l.burst) {
+		bucket.tokens = float64(l.burst)
+	}
+	bucket.updatedAt = now
+}
+
+// 満タンに戻ったバケットは新しく作るのと変わらないので消してよい
+func (l *chatRateLimiter) prune(now time.Time) {
+	for userID, bucket := range l.buckets {
+		l.refill(bucket, now)
+		if bucket.tokens >= float64(l.burst) {
+			delete(l.buckets, userID)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package usecase
+
+import "errors"
+
+// ハンドラーがクライアントに返すエラーコードを決めるためのエラー種別
+// 呼び出し側は errors.Is で判定する
+var (
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrRoomFull        = errors.New("room is full")
+	ErrNotInRoom       = errors.New("user is not in the room")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrBanned          = errors.New("banned")
+	ErrAccessDenied    = errors.New("access denied")
+	ErrRoomBusy        = errors.New("room is not accepting players")
+)
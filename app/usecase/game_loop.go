@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"sync"
+	"time"
+)
+
+// 1 tick 分の変化をルームに配信する。ルームに誰もいなければ false を返し、ループを止める
+type tickFunc func(roomID uint) bool
+
+// ルームごとに一定間隔で入力をまとめて配信するループ
+// move を受け取るたびに配信せず、tick ごとに前回 ack されたスナップショットからの差分を 1 回で送る
+type GameLoop struct {
+	interval time.Duration
+	onTick   tickFunc
+
+	mu    sync.Mutex
+	loops map[uint]*roomLoop
+}
+
+type roomLoop struct {
+	roomID uint
+	// 前回の tick 以降に Wake された場合は、誰もいなくてもループを止めない
+	woken bool
+}
+
+func NewGameLoop(tickRate int, onTick tickFunc) *GameLoop {
+	return &GameLoop{
+		interval: time.Second / time.Duration(tickRate),
+		onTick:   onTick,
+		loops:    make(map[uint]*roomLoop),
+	}
+}
+
+// ルームの状態が変わったことを知らせ、次の tick で配信されるようにする
+func (g *GameLoop) Wake(roomID uint) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	loop, ok := g.loops[roomID]
+	if !ok {
+		loop = &roomLoop{roomID: roomID}
+		g.loops[roomID] = loop
+		go g.run(loop)
+	}
+	loop.woken = true
+}
+
+func (g *GameLoop) run(loop *roomLoop) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		g.mu.Lock()
+		loop.woken = false
+		g.mu.Unlock()
+
+		if g.onTick(loop.roomID) {
+			continue
+		}
+
+		// 誰もいなくなったルームのループは止める。止める直前に Wake されていれば続ける
+		g.mu.Lock()
+		idle := !loop.woken
+		if idle {
+			delete(g.loops, loop.roomID)
+		}
+		g.mu.Unlock()
+		if idle {
+			return
+		}
+	}
+}
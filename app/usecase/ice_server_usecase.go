@@ -0,0 +1,47 @@
+package usecase
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// クライアントが使う STUN / TURN サーバーと、ユーザーごとの期限付きの TURN 認証情報を発行する
+type ICEServerUsecase struct {
+	stunURLs   []string
+	turnURLs   []string
+	turnSecret string
+	ttl        time.Duration
+}
+
+func NewICEServerUsecase(stunURLs []string, turnURLs []string, turnSecret string, ttl time.Duration) *ICEServerUsecase {
+	return &ICEServerUsecase{stunURLs: stunURLs, turnURLs: turnURLs, turnSecret: turnSecret, ttl: ttl}
+}
+
+// TURN REST API の方式で認証情報を作る
+// username は "<有効期限の UNIX 時間>:<userID>"、credential は TURN_SECRET での username の HMAC-SHA1 を Base64 にしたもの
+func (uc *ICEServerUsecase) GetICEConfig(userID uint) *protocol.ICEConfig {
+	iceServers := []protocol.ICEServer{}
+	if len(uc.stunURLs) > 0 {
+		iceServers = append(iceServers, protocol.ICEServer{URLs: uc.stunURLs})
+	}
+	if len(uc.turnURLs) > 0 {
+		username := fmt.Sprintf("%d:%d", time.Now().Add(uc.ttl).Unix(), userID)
+		mac := hmac.New(sha1.New, []byte(uc.turnSecret))
+		mac.Write([]byte(username))
+		iceServers = append(iceServers, protocol.ICEServer{
+			URLs:       uc.turnURLs,
+			Username:   username,
+			Credential: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		})
+	}
+	return &protocol.ICEConfig{
+		Type:       protocol.TypeICEConfig,
+		ICEServers: iceServers,
+		TTL:        int(uc.ttl / time.Second),
+	}
+}
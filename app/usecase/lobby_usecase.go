@@ -0,0 +1,119 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// ルームの参加人数や設定が変わったことを通知する
+type RoomChangeNotifier interface {
+	NotifyRoomChanged(roomID uint)
+	NotifyRoomRemoved(areaID uint, roomID uint)
+}
+
+// エリア内のルーム一覧を購読している接続に参加人数の変化を配信する
+type LobbyUsecase struct {
+	areaRepo                      repository.AreaRepository
+	roomRepo                      repository.RoomRepository
+	inMemoryUserGameLocationRepo  repository.InMemoryUserGameLocationRepository
+	inMemoryLobbySubscriptionRepo repository.InMemoryLobbySubscriptionRepository
+}
+
+func NewLobbyUsecase(areaRepo repository.AreaRepository, roomRepo repository.RoomRepository, inMemoryUserGameLocationRepo repository.InMemoryUserGameLocationRepository, inMemoryLobbySubscriptionRepo repository.InMemoryLobbySubscriptionRepository) *LobbyUsecase {
+	return &LobbyUsecase{areaRepo: areaRepo, roomRepo: roomRepo, inMemoryUserGameLocationRepo: inMemoryUserGameLocationRepo, inMemoryLobbySubscriptionRepo: inMemoryLobbySubscriptionRepo}
+}
+
+// 購読を登録し、現在のルーム一覧を送る
+func (uc *LobbyUsecase) Subscribe(userLocation *model.UserLocation, areaID uint) error {
+	_, exists, err := uc.areaRepo.GetArea(areaID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: area %d does not exist", ErrInvalidArgument, areaID)
+	}
+	rooms, err := uc.roomRepo.GetAllRoomsByAreaId(areaID)
+	if err != nil {
+		return err
+	}
+
+	// 一覧を取得してから登録するまでの変化を取りこぼさないよう、先に登録してから送る
+	uc.inMemoryLobbySubscriptionRepo.Subscribe(areaID, userLocation)
+	lobbyRooms := make([]protocol.LobbyRoom, 0, len(rooms))
+	for _, room := range rooms {
+		if room.Listed() {
+			lobbyRooms = append(lobbyRooms, uc.lobbyRoom(room))
+		}
+	}
+	lobbyMsg := &protocol.Lobby{
+		Type:   protocol.TypeLobby,
+		AreaID: areaID,
+		Rooms:  lobbyRooms,
+	}
+	return userLocation.Conn.Send(lobbyMsg)
+}
+
+func (uc *LobbyUsecase) Unsubscribe(userLocation *model.UserLocation) {
+	uc.inMemoryLobbySubscriptionRepo.Unsubscribe(userLocation.Conn)
+}
+
+func (uc *LobbyUsecase) NotifyRoomChanged(roomID uint) {
+	// 誰も購読していなければルームを読みに行く必要はない
+	if uc.inMemoryLobbySubscriptionRepo.Count() == 0 {
+		return
+	}
+	room, exists, err := uc.roomRepo.GetRoom(roomID)
+	if err != nil {
+		log.Printf("Error getting room for lobby update: %v", err)
+		return
+	}
+	if !exists {
+		return
+	}
+	if !room.Listed() {
+		// private のルームは一覧に載せない
+		uc.NotifyRoomRemoved(room.AreaID, room.ID)
+		return
+	}
+	uc.broadcast(room.AreaID, &protocol.LobbyUpdate{
+		Type:   protocol.TypeLobbyUpdate,
+		AreaID: room.AreaID,
+		Room:   uc.lobbyRoom(room),
+	})
+}
+
+func (uc *LobbyUsecase) NotifyRoomRemoved(areaID uint, roomID uint) {
+	uc.broadcast(areaID, &protocol.LobbyUpdate{
+		Type:    protocol.TypeLobbyUpdate,
+		AreaID:  areaID,
+		Room:    protocol.LobbyRoom{RoomID: roomID},
+		Removed: true,
+	})
+}
+
+func (uc *LobbyUsecase) broadcast(areaID uint, msg interface{}) {
+	for _, subscriber := range uc.inMemoryLobbySubscriptionRepo.GetSubscribersByAreaId(areaID) {
+		err := subscriber.Conn.Send(msg)
+		if err != nil {
+			log.Printf("Error sending message to client: %v", err)
+			uc.inMemoryLobbySubscriptionRepo.Unsubscribe(subscriber.Conn)
+		}
+	}
+}
+
+func (uc *LobbyUsecase) lobbyRoom(room *model.Room) protocol.LobbyRoom {
+	return protocol.LobbyRoom{
+		RoomID:         room.ID,
+		RoomTypeName:   room.RoomType.Name,
+		MaxParticipant: room.RoomType.MaxParticipant,
+		Status:         int(room.Status),
+		State:          room.Status.String(),
+		Occupancy:      len(uc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(room.ID)),
+		Visibility:     room.Visibility,
+		HasPasscode:    room.PasscodeHash != "",
+	}
+}
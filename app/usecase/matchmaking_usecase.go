@@ -0,0 +1,295 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// 待ち行列を見直す間隔
+const matchInterval = time.Second
+
+type matchKey struct {
+	areaID     uint
+	roomTypeID uint
+}
+
+// find-match で並んだユーザーをルームタイプごとにルームへ割り当てる
+// 空きのある既存のルームから埋め、足りなければ新しいルームを作る
+type MatchmakingUsecase struct {
+	areaRepo                     repository.AreaRepository
+	roomRepo                     repository.RoomRepository
+	roomTypeRepo                 repository.RoomTypeRepository
+	inMemoryUserGameLocationRepo repository.InMemoryUserGameLocationRepository
+	inMemoryMatchTicketRepo      repository.InMemoryMatchTicketRepository
+	userGameLocationUsecase      *UserGameLocationUsecase
+	roomChangeNotifier           RoomChangeNotifier
+	timeout                      time.Duration
+	// 新しいルームを作るのはこの人数が揃ってから
+	minPlayers int
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+func NewMatchmakingUsecase(areaRepo repository.AreaRepository, roomRepo repository.RoomRepository, roomTypeRepo repository.RoomTypeRepository, inMemoryUserGameLocationRepo repository.InMemoryUserGameLocationRepository, inMemoryMatchTicketRepo repository.InMemoryMatchTicketRepository, userGameLocationUsecase *UserGameLocationUsecase, roomChangeNotifier RoomChangeNotifier, timeout time.Duration, minPlayers int) *MatchmakingUsecase {
+	return &MatchmakingUsecase{
+		areaRepo:                     areaRepo,
+		roomRepo:                     roomRepo,
+		roomTypeRepo:                 roomTypeRepo,
+		inMemoryUserGameLocationRepo: inMemoryUserGameLocationRepo,
+		inMemoryMatchTicketRepo:      inMemoryMatchTicketRepo,
+		userGameLocationUsecase:      userGameLocationUsecase,
+		roomChangeNotifier:           roomChangeNotifier,
+		timeout:                      timeout,
+		minPlayers:                   minPlayers,
+		stop:                         make(chan struct{}),
+	}
+}
+
+// 定期的に待ち行列を見直すゴルーチンを起動する
+func (uc *MatchmakingUsecase) Start() {
+	go func() {
+		ticker := time.NewTicker(matchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				uc.match()
+			case <-uc.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (uc *MatchmakingUsecase) Stop() {
+	close(uc.stop)
+}
+
+// 割り当てたルームは matched に送る。受け取った接続のゴルーチンで JoinMatchedRoom を呼ぶ
+func (uc *MatchmakingUsecase) FindMatch(userGameLocation *model.UserGameLocation, areaID uint, roomTypeID uint, matched chan<- *model.MatchTicket) error {
+	if _, joined := uc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); joined {
+		return fmt.Errorf("%w: user %d must leave room %d before finding a match", ErrInvalidArgument, userGameLocation.UserID, userGameLocation.RoomID)
+	}
+	_, exists, err := uc.areaRepo.GetArea(areaID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: area %d does not exist", ErrInvalidArgument, areaID)
+	}
+	_, exists, err = uc.roomTypeRepo.GetRoomType(roomTypeID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: room type %d does not exist", ErrInvalidArgument, roomTypeID)
+	}
+
+	uc.inMemoryMatchTicketRepo.Store(model.NewMatchTicket(userGameLocation, areaID, roomTypeID, matched))
+	searchingMsg := &protocol.MatchSearching{
+		Type:       protocol.TypeMatchSearching,
+		AreaID:     areaID,
+		RoomTypeID: roomTypeID,
+	}
+	err = userGameLocation.Connection().Send(searchingMsg)
+	if err != nil {
+		uc.inMemoryMatchTicketRepo.Delete(userGameLocation.UserID)
+		return err
+	}
+
+	// 空きのあるルームがあれば次の見直しを待たずに割り当てる
+	uc.match()
+	return nil
+}
+
+func (uc *MatchmakingUsecase) CancelMatch(userGameLocation *model.UserGameLocation) error {
+	if _, ok := uc.inMemoryMatchTicketRepo.Delete(userGameLocation.UserID); !ok {
+		return fmt.Errorf("%w: user %d is not finding a match", ErrInvalidArgument, userGameLocation.UserID)
+	}
+	cancelledMsg := &protocol.MatchCancelled{
+		Type:   protocol.TypeMatchCancelled,
+		Reason: protocol.MatchCancelReasonCancelled,
+	}
+	return userGameLocation.Connection().Send(cancelledMsg)
+}
+
+// 接続が切れた場合や join-game で自分でルームを選んだ場合は通知せずに待ち行列から外す
+func (uc *MatchmakingUsecase) Withdraw(userID uint) {
+	uc.inMemoryMatchTicketRepo.Delete(userID)
+}
+
+func (uc *MatchmakingUsecase) match() {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	keys := []matchKey{}
+	groups := make(map[matchKey][]*model.MatchTicket)
+	now := time.Now()
+	for _, ticket := range uc.inMemoryMatchTicketRepo.GetAllMatchTickets() {
+		if uc.timeout > 0 && now.Sub(ticket.CreatedAt) >= uc.timeout {
+			uc.expire(ticket)
+			continue
+		}
+		key := matchKey{areaID: ticket.AreaID, roomTypeID: ticket.RoomTypeID}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], ticket)
+	}
+	for _, key := range keys {
+		err := uc.matchGroup(key, groups[key])
+		if err != nil {
+			log.Printf("Error matching players for room type %d in area %d: %v", key.roomTypeID, key.areaID, err)
+		}
+	}
+}
+
+func (uc *MatchmakingUsecase) matchGroup(key matchKey, tickets []*model.MatchTicket) error {
+	roomType, exists, err := uc.roomTypeRepo.GetRoomType(key.roomTypeID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("room type %d does not exist", key.roomTypeID)
+	}
+	rooms, err := uc.roomRepo.GetAllRoomsByAreaIdAndRoomTypeId(key.areaID, key.roomTypeID)
+	if err != nil {
+		return err
+	}
+
+	// 空きのある既存のルームから埋める。パスコードや招待コードが必要なルームとゲーム中のルームには入れない
+	for _, room := range rooms {
+		if !room.Open() || !room.Status.AcceptsPlayers() {
+			continue
+		}
+		tickets = uc.fillRoom(room, tickets)
+		if len(tickets) == 0 {
+			return nil
+		}
+	}
+
+	// 残りは人数が揃った分だけ新しいルームを作る
+	for len(tickets) >= uc.minGroupSize(roomType) {
+		room := &model.Room{AreaID: key.areaID, RoomTypeID: key.roomTypeID, RoomType: *roomType, Visibility: model.RoomVisibilityPublic}
+		err := uc.roomRepo.AddRoom(room)
+		if err != nil {
+			return err
+		}
+		log.Printf("created room %d for matchmaking", room.ID)
+		uc.roomChangeNotifier.NotifyRoomChanged(room.ID)
+		remaining := uc.fillRoom(room, tickets)
+		if len(remaining) == len(tickets) {
+			// 1 人も入れられなかった場合は次の見直しで再試行する
+			return nil
+		}
+		tickets = remaining
+	}
+	return nil
+}
+
+// ルームが満員になるまで先頭から割り当て、残ったチケットを返す
+// 参加は接続のゴルーチンで後から行うため、この見直しで割り当てた人数も定員に含める
+func (uc *MatchmakingUsecase) fillRoom(room *model.Room, tickets []*model.MatchTicket) []*model.MatchTicket {
+	maxParticipant := room.RoomType.MaxParticipant
+	assigned := 0
+	for len(tickets) > 0 {
+		if maxParticipant > 0 && len(uc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(room.ID))+assigned >= maxParticipant {
+			break
+		}
+		if uc.assign(tickets[0], room) {
+			assigned++
+		}
+		tickets = tickets[1:]
+	}
+	return tickets
+}
+
+func (uc *MatchmakingUsecase) minGroupSize(roomType *model.RoomType) int {
+	size := uc.minPlayers
+	if roomType.MaxParticipant > 0 && roomType.MaxParticipant < size {
+		size = roomType.MaxParticipant
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// チケットにルームを割り当てて接続のゴルーチンに渡す。渡せた場合は true を返す
+func (uc *MatchmakingUsecase) assign(ticket *model.MatchTicket, room *model.Room) bool {
+	if _, ok := uc.inMemoryMatchTicketRepo.Delete(ticket.UserGameLocation.UserID); !ok {
+		// 割り当て前にキャンセルされた
+		return false
+	}
+	if ticket.UserGameLocation.Connection().Closed() {
+		return false
+	}
+	ticket.RoomID = room.ID
+	select {
+	case ticket.Matched <- ticket:
+		return true
+	default:
+		// 前の割り当てをまだ受け取っていない場合は次の見直しに回す
+		uc.inMemoryMatchTicketRepo.Store(ticket)
+		return false
+	}
+}
+
+// 接続のゴルーチンから呼び、割り当てられたルームに参加させる
+// 満員やゲーム中で参加できなかった場合はチケットを待ち行列に戻す
+func (uc *MatchmakingUsecase) JoinMatchedRoom(ticket *model.MatchTicket) {
+	userGameLocation := ticket.UserGameLocation
+	if _, joined := uc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); joined || userGameLocation.Connection().Closed() {
+		// 受け取る前に join-game で自分でルームを選んだ
+		return
+	}
+
+	userGameLocation.RoomID = ticket.RoomID
+	err := uc.userGameLocationUsecase.joinGame(userGameLocation, func() {
+		foundMsg := &protocol.MatchFound{
+			Type:       protocol.TypeMatchFound,
+			AreaID:     ticket.AreaID,
+			RoomTypeID: ticket.RoomTypeID,
+			RoomID:     ticket.RoomID,
+		}
+		if err := userGameLocation.Connection().Send(foundMsg); err != nil {
+			log.Printf("Error sending message to client: %v", err)
+		}
+	})
+	if err == nil {
+		return
+	}
+	if _, joined := uc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); !joined {
+		userGameLocation.RoomID = 0
+	}
+	if errors.Is(err, ErrRoomFull) || errors.Is(err, ErrRoomBusy) {
+		uc.inMemoryMatchTicketRepo.Store(ticket)
+		return
+	}
+	log.Printf("Error joining matched room %d: %v", ticket.RoomID, err)
+	errorMsg := protocol.NewError(protocol.CodeInternal, protocol.TypeFindMatch, "internal server error")
+	if sendErr := userGameLocation.Connection().Send(errorMsg); sendErr != nil {
+		log.Printf("Error sending message to client: %v", sendErr)
+	}
+}
+
+func (uc *MatchmakingUsecase) expire(ticket *model.MatchTicket) {
+	if _, ok := uc.inMemoryMatchTicketRepo.Delete(ticket.UserGameLocation.UserID); !ok {
+		return
+	}
+	cancelledMsg := &protocol.MatchCancelled{
+		Type:   protocol.TypeMatchCancelled,
+		Reason: protocol.MatchCancelReasonTimeout,
+	}
+	if err := ticket.UserGameLocation.Connection().Send(cancelledMsg); err != nil {
+		log.Printf("Error sending message to client: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/minigame"
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// game-input を参加中のルームで動いているミニゲームに渡す
+func (ugc *UserGameLocationUsecase) SendGameInput(userGameLocation *model.UserGameLocation, msg *protocol.GameInput) error {
+	if _, joined := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); !joined || userGameLocation.RoomID == 0 {
+		return fmt.Errorf("%w: user %d has not joined any room", ErrNotInRoom, userGameLocation.UserID)
+	}
+	running, err := ugc.minigames.Input(userGameLocation.RoomID, userGameLocation.UserID, minigame.Input{Action: msg.Action, Data: msg.Data})
+	if !running {
+		return fmt.Errorf("%w: no game is being played in room %d", ErrConflict, userGameLocation.RoomID)
+	}
+	if errors.Is(err, minigame.ErrInvalidInput) {
+		return fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+	}
+	return err
+}
+
+// playing に入ったルームでルームタイプのミニゲームを始める
+func (ugc *UserGameLocationUsecase) startMinigame(room *model.Room) {
+	name := room.RoomType.Minigame
+	if name == "" {
+		return
+	}
+	if !ugc.minigames.Start(name, &minigameRoom{ugc: ugc, roomID: room.ID, name: name}) {
+		log.Printf("minigame %q of room %d is not registered", name, room.ID)
+	}
+}
+
+// ゲーム中に参加したユーザーをミニゲームに知らせる
+func (ugc *UserGameLocationUsecase) joinMinigame(userGameLocation *model.UserGameLocation) {
+	ugc.minigames.PlayerJoined(userGameLocation.RoomID, minigame.Player{
+		UserID: userGameLocation.UserID,
+		XAxis:  userGameLocation.XAxis,
+		YAxis:  userGameLocation.YAxis,
+	})
+}
+
+// ミニゲームから見たルーム。送信は参加中のユーザーの接続に game-event として行う
+type minigameRoom struct {
+	ugc    *UserGameLocationUsecase
+	roomID uint
+	name   string
+}
+
+func (r *minigameRoom) ID() uint {
+	return r.roomID
+}
+
+// 位置は各接続のゴルーチンが書き換えるため、UserGameLocation ではなく positions から読む
+func (r *minigameRoom) Players() []minigame.Player {
+	positions := r.ugc.positions.Positions(r.roomID)
+	players := make([]minigame.Player, 0, len(positions))
+	for _, position := range positions {
+		players = append(players, minigame.Player{
+			UserID: position.UserID,
+			XAxis:  position.XAxis,
+			YAxis:  position.YAxis,
+		})
+	}
+	sortPlayers(players)
+	return players
+}
+
+func (r *minigameRoom) event(event string, data interface{}) *protocol.GameEvent {
+	return &protocol.GameEvent{
+		Type:   protocol.TypeGameEvent,
+		RoomID: r.roomID,
+		Game:   r.name,
+		Event:  event,
+		Data:   data,
+	}
+}
+
+func (r *minigameRoom) Broadcast(event string, data interface{}) {
+	eventMsg := r.event(event, data)
+	for _, otherClient := range r.ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(r.roomID) {
+		err := r.ugc.deliver(otherClient, eventMsg)
+		if err != nil {
+			log.Printf("Error sending message to client: %v", err)
+			r.ugc.DisconnectUserGameLocation(otherClient)
+		}
+	}
+}
+
+func (r *minigameRoom) Send(userID uint, event string, data interface{}) {
+	userGameLocation, ok := r.ugc.inMemoryUserGameLocationRepo.Find(userID)
+	if !ok || userGameLocation.RoomID != r.roomID {
+		return
+	}
+	err := r.ugc.deliver(userGameLocation, r.event(event, data))
+	if err != nil {
+		log.Printf("Error sending message to client: %v", err)
+		r.ugc.DisconnectUserGameLocation(userGameLocation)
+	}
+}
+
+// フックの中から呼ばれるため、遷移は別のゴルーチンで行う
+func (r *minigameRoom) End() {
+	go r.ugc.expireRoomStatus(r.roomID, model.RoomStatusPlaying, model.RoomStatusResults)
+}
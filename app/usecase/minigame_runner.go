@@ -0,0 +1,138 @@
+package usecase
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/minigame"
+)
+
+// ルームごとに動いているミニゲーム。playing に入った時に作り、playing を抜けた時に捨てる
+type minigameRunner struct {
+	registry *minigame.Registry
+	interval time.Duration
+
+	mu    sync.Mutex
+	games map[uint]*runningMinigame // Key: roomID
+}
+
+type runningMinigame struct {
+	// フックを 1 つずつ呼ぶためのロック
+	mu    sync.Mutex
+	game  minigame.Minigame
+	room  minigame.Room
+	ended bool
+	stop  chan struct{}
+}
+
+func newMinigameRunner(registry *minigame.Registry, tickRate int) *minigameRunner {
+	return &minigameRunner{
+		registry: registry,
+		interval: time.Second / time.Duration(tickRate),
+		games:    make(map[uint]*runningMinigame),
+	}
+}
+
+// name のゲームを始める。登録されていない名前の場合は何もせず false を返す
+func (r *minigameRunner) Start(name string, room minigame.Room) bool {
+	game, ok := r.registry.New(name)
+	if !ok {
+		return false
+	}
+	running := &runningMinigame{game: game, room: room, stop: make(chan struct{})}
+	r.mu.Lock()
+	previous := r.games[room.ID()]
+	r.games[room.ID()] = running
+	r.mu.Unlock()
+	if previous != nil {
+		previous.end()
+	}
+
+	running.mu.Lock()
+	game.OnStart(room, time.Now())
+	running.mu.Unlock()
+	go r.run(running)
+	return true
+}
+
+func (r *minigameRunner) run(running *runningMinigame) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-running.stop:
+			return
+		case now := <-ticker.C:
+			running.mu.Lock()
+			if !running.ended {
+				running.game.OnTick(running.room, now)
+			}
+			running.mu.Unlock()
+		}
+	}
+}
+
+// ゲームを終えて OnEnd を呼ぶ。動いていない場合は何もしない
+func (r *minigameRunner) Stop(roomID uint) {
+	r.mu.Lock()
+	running, ok := r.games[roomID]
+	delete(r.games, roomID)
+	r.mu.Unlock()
+	if ok {
+		running.end()
+	}
+}
+
+func (running *runningMinigame) end() {
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	if running.ended {
+		return
+	}
+	running.ended = true
+	close(running.stop)
+	running.game.OnEnd(running.room)
+}
+
+// 動いているゲームに hook を渡す。ゲームが動いていない場合は false を返す
+func (r *minigameRunner) with(roomID uint, hook func(game minigame.Minigame, room minigame.Room)) bool {
+	r.mu.Lock()
+	running, ok := r.games[roomID]
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	if running.ended {
+		return false
+	}
+	hook(running.game, running.room)
+	return true
+}
+
+func (r *minigameRunner) PlayerJoined(roomID uint, player minigame.Player) {
+	r.with(roomID, func(game minigame.Minigame, room minigame.Room) {
+		game.OnPlayerJoin(room, player)
+	})
+}
+
+func (r *minigameRunner) PlayerLeft(roomID uint, userID uint) {
+	r.with(roomID, func(game minigame.Minigame, room minigame.Room) {
+		game.OnPlayerLeave(room, userID)
+	})
+}
+
+// ゲームが動いていない場合は false を返す
+func (r *minigameRunner) Input(roomID uint, userID uint, input minigame.Input) (bool, error) {
+	var err error
+	ok := r.with(roomID, func(game minigame.Minigame, room minigame.Room) {
+		err = game.OnInput(room, userID, input)
+	})
+	return ok, err
+}
+
+func sortPlayers(players []minigame.Player) {
+	sort.Slice(players, func(i, j int) bool { return players[i].UserID < players[j].UserID })
+}
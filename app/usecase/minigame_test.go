@@ -0,0 +1,215 @@
+package usecase
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"github.com/sako0/minigame-space-api/app/infra/in_memory"
+	"github.com/sako0/minigame-space-api/app/minigame/tileclaim"
+	"github.com/sako0/minigame-space-api/app/protocol"
+	"github.com/sako0/minigame-space-api/app/wsconn"
+)
+
+const testMinigameRoomID = 1
+
+// End から呼ばれる GetRoom を記録する。ルームが見つからないことにして遷移はさせない
+type endRecordingRoomRepository struct {
+	repository.RoomRepository
+	ended chan uint
+}
+
+func (r *endRecordingRoomRepository) GetRoom(roomId uint) (*model.Room, bool, error) {
+	r.ended <- roomId
+	return nil, false, nil
+}
+
+type minigameTest struct {
+	t        *testing.T
+	ugc      *UserGameLocationUsecase
+	room     *minigameRoom
+	roomRepo *endRecordingRoomRepository
+	server   *httptest.Server
+	accepted chan *wsconn.Conn
+	players  map[uint]*model.UserGameLocation
+	clients  map[uint]*websocket.Conn
+}
+
+func newMinigameTest(t *testing.T) *minigameTest {
+	roomRepo := &endRecordingRoomRepository{ended: make(chan uint, 1)}
+	ugc := &UserGameLocationUsecase{
+		inMemoryUserGameLocationRepo: in_memory.NewInMemoryUserGameLocationRepository(),
+		inMemoryGameSessionRepo:      in_memory.NewInMemoryGameSessionRepository(),
+		roomRepo:                     roomRepo,
+		positions:                    newPositionTrackers(),
+	}
+	mt := &minigameTest{
+		t:        t,
+		ugc:      ugc,
+		room:     &minigameRoom{ugc: ugc, roomID: testMinigameRoomID, name: tileclaim.Name},
+		roomRepo: roomRepo,
+		accepted: make(chan *wsconn.Conn, 1),
+		players:  make(map[uint]*model.UserGameLocation),
+		clients:  make(map[uint]*websocket.Conn),
+	}
+	upgrader := websocket.Upgrader{}
+	mt.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		mt.accepted <- wsconn.New(ws, wsconn.DefaultOptions())
+	}))
+	t.Cleanup(mt.close)
+	return mt
+}
+
+func (mt *minigameTest) close() {
+	for _, client := range mt.clients {
+		client.Close()
+	}
+	for _, player := range mt.players {
+		player.Connection().Close()
+	}
+	mt.server.Close()
+}
+
+// ルームに接続したユーザーを (x, y) に置く
+func (mt *minigameTest) join(userID uint, x int, y int) {
+	mt.t.Helper()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(mt.server.URL, "http"), nil)
+	if err != nil {
+		mt.t.Fatalf("dial: %v", err)
+	}
+	player := model.NewUserGameLocationByConn(<-mt.accepted)
+	player.UserID = userID
+	player.RoomID = testMinigameRoomID
+	mt.players[userID] = player
+	mt.clients[userID] = client
+	mt.ugc.inMemoryUserGameLocationRepo.Store(player)
+	mt.move(userID, x, y)
+}
+
+func (mt *minigameTest) move(userID uint, x int, y int) {
+	player := mt.players[userID]
+	player.XAxis = x
+	player.YAxis = y
+	mt.ugc.submitPosition(player)
+}
+
+type testGameEvent struct {
+	Type   string          `json:"type"`
+	RoomID uint            `json:"roomID"`
+	Game   string          `json:"game"`
+	Event  string          `json:"event"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// userID の接続に届いた次の game-event を読み、event であることを確かめて data を v に読み込む
+func (mt *minigameTest) expectEvent(userID uint, event string, v interface{}) {
+	mt.t.Helper()
+	client := mt.clients[userID]
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	var eventMsg testGameEvent
+	if err := client.ReadJSON(&eventMsg); err != nil {
+		mt.t.Fatalf("user %d: reading %s: %v", userID, event, err)
+	}
+	if eventMsg.Type != protocol.TypeGameEvent || eventMsg.RoomID != testMinigameRoomID || eventMsg.Game != tileclaim.Name || eventMsg.Event != event {
+		mt.t.Fatalf("user %d: got %s %q, want game-event %q", userID, eventMsg.Type, eventMsg.Event, event)
+	}
+	if v != nil {
+		if err := json.Unmarshal(eventMsg.Data, v); err != nil {
+			mt.t.Fatalf("user %d: decoding %s: %v", userID, event, err)
+		}
+	}
+}
+
+// userID の接続に次のフレームが届かないことを確かめる。読み込みがタイムアウトした接続はそれ以降読めない
+func (mt *minigameTest) expectNoEvent(userID uint) {
+	mt.t.Helper()
+	client := mt.clients[userID]
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	var eventMsg testGameEvent
+	err := client.ReadJSON(&eventMsg)
+	if err == nil {
+		mt.t.Fatalf("user %d: got %s %q, want no frame", userID, eventMsg.Type, eventMsg.Event)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		mt.t.Fatalf("user %d: reading: %v, want timeout", userID, err)
+	}
+}
+
+func (mt *minigameTest) expectEnded(want bool) {
+	mt.t.Helper()
+	select {
+	case roomID := <-mt.roomRepo.ended:
+		if !want {
+			mt.t.Fatalf("room %d was ended", roomID)
+		}
+		if roomID != testMinigameRoomID {
+			mt.t.Fatalf("ended room %d, want %d", roomID, testMinigameRoomID)
+		}
+	case <-time.After(100 * time.Millisecond):
+		if want {
+			mt.t.Fatal("room was not ended")
+		}
+	}
+}
+
+type testClaimedEvent struct {
+	Tiles  []tileclaim.ClaimedTile `json:"tiles"`
+	Scores []int                   `json:"scores"`
+}
+
+func newTileClaimGame(teams int) *tileclaim.Game {
+	return tileclaim.New(tileclaim.Options{TileSize: 10, RoundDuration: 30 * time.Second, Teams: teams})().(*tileclaim.Game)
+}
+
+func expectScores(t *testing.T, game *tileclaim.Game, want []int) {
+	t.Helper()
+	if got := game.Scores(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("scores = %v, want %v", got, want)
+	}
+}
+
+func TestTileClaimClaimsStandingTiles(t *testing.T) {
+	mt := newMinigameTest(t)
+	game := newTileClaimGame(2)
+	// UserID の順にチーム 0, 1 に分かれる
+	mt.join(1, 5, 5)
+	mt.join(2, 25, -5)
+
+	now := time.Now()
+	game.OnStart(mt.room, now)
+	expectScores(t, game, []int{1, 1})
+	mt.expectEvent(1, tileclaim.EventStart, nil)
+	mt.expectEvent(2, tileclaim.EventStart, nil)
+
+	mt.move(1, 15, 5)
+	game.OnTick(mt.room, now.Add(time.Second))
+	var claimed testClaimedEvent
+	mt.expectEvent(1, tileclaim.EventClaimed, &claimed)
+	want := testClaimedEvent{Tiles: []tileclaim.ClaimedTile{{Tile: tileclaim.Tile{X: 1, Y: 0}, Team: 0}}, Scores: []int{2, 1}}
+	if !reflect.DeepEqual(claimed, want) {
+		t.Fatalf("claimed = %+v, want %+v", claimed, want)
+	}
+	mt.expectEvent(2, tileclaim.EventClaimed, nil)
+
+	// 同じマスに留まっている間は何も送らない
+	game.OnTick(mt.room, now.Add(2*time.Second))
+	mt.expectNoEvent(1)
+	mt.expectNoEvent(2)
+	expectScores(t, game, []int{2, 1})
+	mt.expectEnded(false)
+}
@@ -0,0 +1,89 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// areaID と roomID で kind の制限を受けている場合は ErrBanned を返す
+func checkBan(banRepo repository.BanRepository, kind string, userID uint, areaID uint, roomID uint) error {
+	ban, found, err := banRepo.FindActiveBan(userID, kind, areaID, roomID, time.Now())
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("%w: user %d is restricted by %s (ban %d)", ErrBanned, userID, kind, ban.ID)
+	}
+	return nil
+}
+
+// 音声の接続を張る offer / answer だけを mute-audio の対象にする。ice-candidate は接続済みの相手にしか意味がない
+func checkSignalBan(banRepo repository.BanRepository, signal *protocol.Signal, userID uint, areaID uint, roomID uint) error {
+	if signal.Type != protocol.TypeOffer && signal.Type != protocol.TypeAnswer {
+		return nil
+	}
+	return checkBan(banRepo, model.BanKindMuteAudio, userID, areaID, roomID)
+}
+
+// kicked を送ってから接続を閉じる。エリアやルームからの退出は接続が閉じた後のクリーンアップで行う
+func (uc *UserLocationUsecase) Kick(userLocation *model.UserLocation, kicked *protocol.Kicked) {
+	if err := userLocation.Conn.Send(kicked); err != nil {
+		log.Printf("Error sending message to client: %v", err)
+	}
+	userLocation.Conn.CloseAfterFlush()
+}
+
+// 音声からだけ外す。エリアとルームには残ったままにする
+func (uc *UserLocationUsecase) LeaveAudio(userLocation *model.UserLocation) {
+	leaveVoice(uc.sfu, userLocation.UserID)
+	uc.removeFromVoice(userLocation)
+	if userLocation.RoomID == 0 {
+		return
+	}
+	for _, otherClient := range uc.inMemoryUserLocationRepo.GetAllUserLocationsByRoomId(userLocation.RoomID) {
+		if otherClient.UserID != userLocation.UserID {
+			uc.sendEvent(otherClient, &protocol.RoomLeft{
+				Type:       protocol.TypeLeaveRoom,
+				FromUserID: userLocation.UserID,
+				ToUserID:   otherClient.UserID,
+				AreaID:     userLocation.AreaID,
+				RoomID:     userLocation.RoomID,
+			})
+		}
+	}
+}
+
+// kicked を送ってから接続を閉じる。再接続はさせず、接続が閉じた後の cleanUp でルームから退出させる
+func (ugc *UserGameLocationUsecase) Kick(userGameLocation *model.UserGameLocation, kicked *protocol.Kicked) {
+	_, hadSession := ugc.inMemoryGameSessionRepo.FindByUserID(userGameLocation.UserID)
+	ugc.closeSession(userGameLocation.UserID)
+	conn := userGameLocation.Connection()
+	if conn.Closed() {
+		// 切断後の猶予期間中だった場合は cleanUp が済んでいるのでここで退出させる
+		if hadSession {
+			ugc.disconnectAll(userGameLocation)
+		}
+		return
+	}
+	if err := conn.Send(kicked); err != nil {
+		log.Printf("Error sending message to client: %v", err)
+	}
+	conn.CloseAfterFlush()
+}
+
+// room の所属するエリアを返す。ルームが無い場合は 0
+func (ugc *UserGameLocationUsecase) areaOfRoom(roomID uint) (uint, error) {
+	room, exists, err := ugc.roomRepo.GetRoom(roomID)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+	return room.AreaID, nil
+}
@@ -0,0 +1,267 @@
+package usecase
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// 制限の対象。RoomID を指定した場合はそのルーム、AreaID だけの場合はそのエリア、どちらも 0 の場合はすべてが対象
+type ModerationTarget struct {
+	UserID uint
+	AreaID uint
+	RoomID uint
+}
+
+// kick / mute / ban。管理者はどこでも、ルームの所有者は自分のルームでだけ行える
+type ModerationUsecase struct {
+	banRepo                      repository.BanRepository
+	userRepo                     repository.UserRepository
+	roomRepo                     repository.RoomRepository
+	inMemoryUserLocationRepo     repository.InMemoryUserLocationRepository
+	inMemoryUserGameLocationRepo repository.InMemoryUserGameLocationRepository
+	userLocationUsecase          *UserLocationUsecase
+	userGameLocationUsecase      *UserGameLocationUsecase
+}
+
+func NewModerationUsecase(banRepo repository.BanRepository, userRepo repository.UserRepository, roomRepo repository.RoomRepository, inMemoryUserLocationRepo repository.InMemoryUserLocationRepository, inMemoryUserGameLocationRepo repository.InMemoryUserGameLocationRepository, userLocationUsecase *UserLocationUsecase, userGameLocationUsecase *UserGameLocationUsecase) *ModerationUsecase {
+	return &ModerationUsecase{
+		banRepo:                      banRepo,
+		userRepo:                     userRepo,
+		roomRepo:                     roomRepo,
+		inMemoryUserLocationRepo:     inMemoryUserLocationRepo,
+		inMemoryUserGameLocationRepo: inMemoryUserGameLocationRepo,
+		userLocationUsecase:          userLocationUsecase,
+		userGameLocationUsecase:      userGameLocationUsecase,
+	}
+}
+
+// WebSocket で受け取った kick / mute-audio / mute-chat / ban を、送信者が参加中のルームに対して行う
+func (uc *ModerationUsecase) ModerateRoom(actorID uint, roomID uint, msg *protocol.Moderate) error {
+	if roomID == 0 {
+		return fmt.Errorf("%w: user %d has not joined any room", ErrNotInRoom, actorID)
+	}
+	target := ModerationTarget{UserID: msg.TargetUserID, RoomID: roomID}
+	if msg.Type == protocol.TypeKick {
+		return uc.Kick(actorID, target, msg.Reason)
+	}
+	_, err := uc.Restrict(actorID, target, msg.Type, time.Duration(msg.DurationSeconds)*time.Second, msg.Reason)
+	return err
+}
+
+// 対象の接続に kicked を送って閉じる。制限は残らないので参加し直すことはできる
+func (uc *ModerationUsecase) Kick(actorID uint, target ModerationTarget, reason string) error {
+	if err := uc.authorize(actorID, target); err != nil {
+		return err
+	}
+	kicked, err := uc.kickConnections(target, &protocol.Kicked{
+		Type:   protocol.TypeKicked,
+		AreaID: target.AreaID,
+		RoomID: target.RoomID,
+		Reason: reason,
+	})
+	if err != nil {
+		return err
+	}
+	if !kicked {
+		return fmt.Errorf("%w: user %d is not connected to the target", ErrNotInRoom, target.UserID)
+	}
+	return nil
+}
+
+// kind の制限を追加し、接続中の対象にすぐに適用する。duration が 0 の場合は無期限
+func (uc *ModerationUsecase) Restrict(actorID uint, target ModerationTarget, kind string, duration time.Duration, reason string) (*model.Ban, error) {
+	if kind != model.BanKindBan && kind != model.BanKindMuteAudio && kind != model.BanKindMuteChat {
+		return nil, fmt.Errorf("%w: kind must be %q, %q or %q", ErrInvalidArgument, model.BanKindBan, model.BanKindMuteAudio, model.BanKindMuteChat)
+	}
+	if duration < 0 {
+		return nil, fmt.Errorf("%w: duration must not be negative", ErrInvalidArgument)
+	}
+	if err := uc.authorize(actorID, target); err != nil {
+		return nil, err
+	}
+	ban := &model.Ban{
+		UserID:   target.UserID,
+		Kind:     kind,
+		AreaID:   target.AreaID,
+		RoomID:   target.RoomID,
+		Reason:   reason,
+		IssuedBy: actorID,
+	}
+	if duration > 0 {
+		expiresAt := time.Now().Add(duration)
+		ban.ExpiresAt = &expiresAt
+	}
+	err := uc.banRepo.AddBan(ban)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case model.BanKindBan:
+		_, err = uc.kickConnections(target, &protocol.Kicked{
+			Type:      protocol.TypeKicked,
+			AreaID:    target.AreaID,
+			RoomID:    target.RoomID,
+			Reason:    reason,
+			Banned:    true,
+			ExpiresAt: ban.ExpiresAt,
+		})
+	default:
+		err = uc.applyMute(target, &protocol.Muted{
+			Type:      protocol.TypeMuted,
+			Kind:      kind,
+			AreaID:    target.AreaID,
+			RoomID:    target.RoomID,
+			Reason:    reason,
+			ExpiresAt: ban.ExpiresAt,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ban, nil
+}
+
+func (uc *ModerationUsecase) ListBans(pagination Pagination) ([]*model.Ban, int64, error) {
+	if err := pagination.validate(); err != nil {
+		return nil, 0, err
+	}
+	return uc.banRepo.GetBans(pagination.offset(), pagination.PerPage)
+}
+
+func (uc *ModerationUsecase) RemoveBan(banID uint) error {
+	_, exists, err := uc.banRepo.GetBan(banID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: ban %d", ErrNotFound, banID)
+	}
+	return uc.banRepo.RemoveBan(banID)
+}
+
+func (uc *ModerationUsecase) authorize(actorID uint, target ModerationTarget) error {
+	if target.UserID == 0 {
+		return fmt.Errorf("%w: target user is required", ErrInvalidArgument)
+	}
+	if target.UserID == actorID {
+		return fmt.Errorf("%w: users cannot moderate themselves", ErrInvalidArgument)
+	}
+	actor, exists, err := uc.userRepo.GetUser(actorID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: user %d does not exist", ErrUnauthorized, actorID)
+	}
+	if actor.IsAdmin {
+		return nil
+	}
+	if target.RoomID == 0 {
+		return fmt.Errorf("%w: only admins can moderate areas", ErrUnauthorized)
+	}
+	room, exists, err := uc.roomRepo.GetRoom(target.RoomID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: room %d does not exist", ErrInvalidArgument, target.RoomID)
+	}
+	if room.OwnerID != actorID {
+		return fmt.Errorf("%w: user %d is not the owner of room %d", ErrUnauthorized, actorID, room.ID)
+	}
+	targetUser, exists, err := uc.userRepo.GetUser(target.UserID)
+	if err != nil {
+		return err
+	}
+	if exists && targetUser.IsAdmin {
+		return fmt.Errorf("%w: room owners cannot moderate admins", ErrUnauthorized)
+	}
+	return nil
+}
+
+// 対象に当てはまる /ws と /game の接続を閉じる。閉じた接続があったかを返す
+func (uc *ModerationUsecase) kickConnections(target ModerationTarget, kicked *protocol.Kicked) (bool, error) {
+	userLocation, userGameLocation, err := uc.findConnections(target)
+	if err != nil {
+		return false, err
+	}
+	if userLocation != nil {
+		uc.userLocationUsecase.Kick(userLocation, kicked)
+	}
+	if userGameLocation != nil {
+		uc.userGameLocationUsecase.Kick(userGameLocation, kicked)
+	}
+	return userLocation != nil || userGameLocation != nil, nil
+}
+
+// 音声の制限の場合は音声から外す。チャットの制限は送信時に確認する
+func (uc *ModerationUsecase) applyMute(target ModerationTarget, muted *protocol.Muted) error {
+	userLocation, userGameLocation, err := uc.findConnections(target)
+	if err != nil {
+		return err
+	}
+	if userLocation != nil {
+		if muted.Kind == model.BanKindMuteAudio {
+			uc.userLocationUsecase.LeaveAudio(userLocation)
+		}
+		uc.userLocationUsecase.sendEvent(userLocation, muted)
+	}
+	if userGameLocation != nil {
+		if muted.Kind == model.BanKindMuteAudio {
+			err = uc.userGameLocationUsecase.LeaveInAudio(userGameLocation, userGameLocation.RoomID)
+			if err != nil {
+				return err
+			}
+		}
+		err = uc.userGameLocationUsecase.deliver(userGameLocation, muted)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 対象のユーザーの接続のうち、target のエリア・ルームにいるもの
+func (uc *ModerationUsecase) findConnections(target ModerationTarget) (*model.UserLocation, *model.UserGameLocation, error) {
+	var found *model.UserLocation
+	if userLocation, ok := uc.inMemoryUserLocationRepo.Find(target.UserID); ok {
+		switch {
+		case target.RoomID != 0:
+			if userLocation.RoomID == target.RoomID {
+				found = userLocation
+			}
+		case target.AreaID != 0:
+			if userLocation.AreaID == target.AreaID {
+				found = userLocation
+			}
+		default:
+			found = userLocation
+		}
+	}
+
+	var foundGame *model.UserGameLocation
+	if userGameLocation, ok := uc.inMemoryUserGameLocationRepo.Find(target.UserID); ok {
+		switch {
+		case target.RoomID != 0:
+			if userGameLocation.RoomID == target.RoomID {
+				foundGame = userGameLocation
+			}
+		case target.AreaID != 0:
+			areaID, err := uc.userGameLocationUsecase.areaOfRoom(userGameLocation.RoomID)
+			if err != nil {
+				return nil, nil, err
+			}
+			if areaID == target.AreaID {
+				foundGame = userGameLocation
+			}
+		default:
+			foundGame = userGameLocation
+		}
+	}
+	return found, foundGame, nil
+}
@@ -0,0 +1,25 @@
+package usecase
+
+import "fmt"
+
+const MaxPerPage = 100
+
+// 一覧取得のページ指定。Page は 1 始まり
+type Pagination struct {
+	Page    int
+	PerPage int
+}
+
+func (p Pagination) validate() error {
+	if p.Page < 1 {
+		return fmt.Errorf("%w: page must be greater than 0", ErrInvalidArgument)
+	}
+	if p.PerPage < 1 || p.PerPage > MaxPerPage {
+		return fmt.Errorf("%w: perPage must be between 1 and %d", ErrInvalidArgument, MaxPerPage)
+	}
+	return nil
+}
+
+func (p Pagination) offset() int {
+	return (p.Page - 1) * p.PerPage
+}
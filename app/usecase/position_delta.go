@@ -0,0 +1,307 @@
+package usecase
+
+import (
+	"sync"
+
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// ack を待つために残しておくスナップショットの数。これより古い ack しかないクライアントにはキーフレームを送る
+const maxSnapshotHistory = 64
+
+type positionSnapshot struct {
+	seq       uint64
+	positions map[uint]protocol.PlayerPosition
+	// 一部のユーザーの位置だけを確定したもの
+	partial bool
+}
+
+type positionRemoval struct {
+	userID uint
+	// この seq のスナップショットから外れている
+	seq uint64
+}
+
+// エリアまたはルーム 1 つ分の位置とスナップショットの履歴
+type positionHistory struct {
+	positions map[uint]protocol.PlayerPosition
+	dirty     bool
+	seq       uint64
+	snapshots []*positionSnapshot
+	// クライアントが最後に受け取ったと ack したスナップショットの seq
+	acks map[uint]uint64
+	// 一部だけを確定したスナップショットでは外れたユーザーが分からないため、別に残しておく
+	removals []positionRemoval
+}
+
+func (h *positionHistory) snapshot(seq uint64) *positionSnapshot {
+	for _, snapshot := range h.snapshots {
+		if snapshot.seq == seq {
+			return snapshot
+		}
+	}
+	return nil
+}
+
+func (h *positionHistory) latest() *positionSnapshot {
+	if len(h.snapshots) == 0 {
+		return nil
+	}
+	return h.snapshots[len(h.snapshots)-1]
+}
+
+// スナップショットを確定する。playerIDs が nil の場合は全員の位置を、それ以外は playerIDs の位置だけを残す
+func (h *positionHistory) commit(playerIDs []uint) *positionSnapshot {
+	h.seq++
+	snapshot := &positionSnapshot{seq: h.seq}
+	if playerIDs == nil {
+		snapshot.positions = make(map[uint]protocol.PlayerPosition, len(h.positions))
+		for userID, position := range h.positions {
+			snapshot.positions[userID] = position
+		}
+	} else {
+		snapshot.partial = true
+		snapshot.positions = make(map[uint]protocol.PlayerPosition, len(playerIDs))
+		for _, userID := range playerIDs {
+			if position, ok := h.positions[userID]; ok {
+				snapshot.positions[userID] = position
+			}
+		}
+	}
+	h.snapshots = append(h.snapshots, snapshot)
+	if len(h.snapshots) > maxSnapshotHistory {
+		h.snapshots = h.snapshots[1:]
+	}
+	// 残っている最も古いスナップショットより前に外れたユーザーはもう要らない
+	oldest := h.snapshots[0].seq
+	for len(h.removals) > 0 && h.removals[0].seq <= oldest {
+		h.removals = h.removals[1:]
+	}
+	h.dirty = false
+	return snapshot
+}
+
+// latest を base からの差分にした state を作る。playerIDs が nil の場合は stateBetween と同じ
+// それ以外は playerIDs の位置だけを比べ、外れたユーザーは removals から求める
+func (h *positionHistory) stateBetween(base *positionSnapshot, latest *positionSnapshot, playerIDs []uint) protocol.State {
+	if base == nil || playerIDs == nil {
+		return stateBetween(base, latest)
+	}
+	state := protocol.State{
+		Type:    protocol.TypeState,
+		Seq:     latest.seq,
+		BaseSeq: base.seq,
+		Players: []protocol.PlayerPosition{},
+	}
+	for _, userID := range playerIDs {
+		position, ok := latest.positions[userID]
+		if !ok {
+			continue
+		}
+		if previous, ok := base.positions[userID]; !ok || previous != position {
+			state.Players = append(state.Players, position)
+		}
+	}
+	for _, removal := range h.removals {
+		if removal.seq <= base.seq || removal.seq > latest.seq {
+			continue
+		}
+		// 外れた後に戻ってきたユーザーは Players に入る
+		if _, ok := h.positions[removal.userID]; !ok {
+			state.Removed = append(state.Removed, removal.userID)
+		}
+	}
+	return state
+}
+
+// latest を base からの差分にした state を作る。base が無ければキーフレームにする
+func stateBetween(base *positionSnapshot, latest *positionSnapshot) protocol.State {
+	state := protocol.State{
+		Type:    protocol.TypeState,
+		Seq:     latest.seq,
+		Players: []protocol.PlayerPosition{},
+	}
+	if base == nil {
+		state.Keyframe = true
+		for _, position := range latest.positions {
+			state.Players = append(state.Players, position)
+		}
+		return state
+	}
+	state.BaseSeq = base.seq
+	for userID, position := range latest.positions {
+		if previous, ok := base.positions[userID]; !ok || previous != position {
+			state.Players = append(state.Players, position)
+		}
+	}
+	for userID := range base.positions {
+		if _, ok := latest.positions[userID]; !ok {
+			state.Removed = append(state.Removed, userID)
+		}
+	}
+	return state
+}
+
+// エリアやルームごとに、クライアントが ack したスナップショットからの差分を作る
+type positionTrackers struct {
+	mu        sync.Mutex
+	histories map[uint]*positionHistory // Key: areaID または roomID
+	groups    map[uint]uint             // Key: userID, Value: 所属している areaID または roomID
+}
+
+func newPositionTrackers() *positionTrackers {
+	return &positionTrackers{
+		histories: make(map[uint]*positionHistory),
+		groups:    make(map[uint]uint),
+	}
+}
+
+func (t *positionTrackers) historyLocked(key uint) *positionHistory {
+	history, ok := t.histories[key]
+	if !ok {
+		history = &positionHistory{
+			positions: make(map[uint]protocol.PlayerPosition),
+			acks:      make(map[uint]uint64),
+		}
+		t.histories[key] = history
+	}
+	return history
+}
+
+// 位置を更新する。別のグループから移ってきた場合は元のグループから外し、そのキーも返す
+func (t *positionTrackers) Set(key uint, position protocol.PlayerPosition) []uint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	changed := []uint{key}
+	if previous, ok := t.groups[position.UserID]; ok && previous != key {
+		t.removeLocked(position.UserID)
+		changed = append(changed, previous)
+	}
+	t.groups[position.UserID] = key
+	history := t.historyLocked(key)
+	if current, ok := history.positions[position.UserID]; !ok || current != position {
+		history.positions[position.UserID] = position
+		history.dirty = true
+	}
+	return changed
+}
+
+func (t *positionTrackers) Remove(userID uint) (uint, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.removeLocked(userID)
+}
+
+func (t *positionTrackers) removeLocked(userID uint) (uint, bool) {
+	key, ok := t.groups[userID]
+	if !ok {
+		return 0, false
+	}
+	delete(t.groups, userID)
+	if history, ok := t.histories[key]; ok {
+		delete(history.positions, userID)
+		delete(history.acks, userID)
+		history.removals = append(history.removals, positionRemoval{userID: userID, seq: history.seq + 1})
+		history.dirty = true
+	}
+	return key, true
+}
+
+// 誰もいなくなったグループの履歴を捨てる
+func (t *positionTrackers) Drop(key uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if history, ok := t.histories[key]; ok && len(history.positions) == 0 {
+		delete(t.histories, key)
+	}
+}
+
+func (t *positionTrackers) Ack(userID uint, seq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key, ok := t.groups[userID]
+	if !ok {
+		return
+	}
+	history := t.histories[key]
+	// 履歴に残っていない seq や巻き戻る ack は無視する
+	if history.snapshot(seq) != nil && seq > history.acks[userID] {
+		history.acks[userID] = seq
+	}
+}
+
+// 次の state をキーフレームにする。参加直後や再接続後に呼ぶ
+func (t *positionTrackers) ResetAck(userID uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if key, ok := t.groups[userID]; ok {
+		delete(t.histories[key].acks, userID)
+	}
+}
+
+// 変化があればスナップショットを確定し、各クライアントに送る state を返す
+// 同じ seq まで ack しているクライアント同士では同じ差分を使い回す
+// playerIDs を渡した場合は、その位置だけを確定して差分を作る。ただしキーフレームが必要なクライアントがいる場合は全員分を確定する
+func (t *positionTrackers) Commit(key uint, userIDs []uint, playerIDs []uint) (map[uint]protocol.State, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	history, ok := t.histories[key]
+	if !ok || !history.dirty {
+		return nil, false
+	}
+	bases := make(map[uint]*positionSnapshot, len(userIDs))
+	committed := playerIDs
+	for _, userID := range userIDs {
+		base := history.snapshot(history.acks[userID])
+		if base == nil {
+			committed = nil
+		}
+		bases[userID] = base
+	}
+	latest := history.commit(committed)
+	byBase := make(map[uint64]protocol.State)
+	states := make(map[uint]protocol.State, len(userIDs))
+	for _, userID := range userIDs {
+		baseSeq := history.acks[userID]
+		state, ok := byBase[baseSeq]
+		if !ok {
+			state = history.stateBetween(bases[userID], latest, playerIDs)
+			byBase[baseSeq] = state
+		}
+		states[userID] = state
+	}
+	return states, true
+}
+
+// グループの今の位置を返す。確定していない位置も含む
+func (t *positionTrackers) Positions(key uint) []protocol.PlayerPosition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	history, ok := t.histories[key]
+	if !ok {
+		return []protocol.PlayerPosition{}
+	}
+	positions := make([]protocol.PlayerPosition, 0, len(history.positions))
+	for _, position := range history.positions {
+		positions = append(positions, position)
+	}
+	return positions
+}
+
+// クライアントから要求された場合などに、最新の状態のキーフレームを返す
+func (t *positionTrackers) Keyframe(userID uint) (protocol.State, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key, ok := t.groups[userID]
+	if !ok {
+		return protocol.State{}, false
+	}
+	history := t.histories[key]
+	latest := history.latest()
+	if latest == nil || latest.partial || history.dirty {
+		latest = history.commit(nil)
+	}
+	delete(history.acks, userID)
+	return stateBetween(nil, latest), true
+}
@@ -0,0 +1,101 @@
+package usecase
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+const benchmarkRoomID = 1
+
+// tick ごとに players 人のうち moving 人が動いた場合に、ルーム全員へ送るバイト数を比べる
+// delta は tick ごとの state の差分、full は move のたびにルーム全員の位置を送る従来の方式
+func BenchmarkStateFrames(b *testing.B) {
+	for _, players := range []int{10, 50, 200} {
+		moving := players / 10
+		if moving == 0 {
+			moving = 1
+		}
+		b.Run(fmt.Sprintf("delta/players=%d/moving=%d", players, moving), func(b *testing.B) {
+			benchmarkDeltaStateFrames(b, players, moving)
+		})
+		b.Run(fmt.Sprintf("full/players=%d/moving=%d", players, moving), func(b *testing.B) {
+			benchmarkFullStateFrames(b, players, moving)
+		})
+	}
+}
+
+func benchmarkDeltaStateFrames(b *testing.B, players int, moving int) {
+	trackers := newPositionTrackers()
+	userIDs := make([]uint, 0, players)
+	for i := 0; i < players; i++ {
+		userID := uint(i + 1)
+		userIDs = append(userIDs, userID)
+		trackers.Set(benchmarkRoomID, protocol.PlayerPosition{UserID: userID, XAxis: i, YAxis: i})
+	}
+	// 参加直後のキーフレームは測らない
+	states, _ := trackers.Commit(benchmarkRoomID, userIDs, nil)
+	for _, userID := range userIDs {
+		trackers.Ack(userID, states[userID].Seq)
+	}
+
+	var sent int
+	b.ReportAllocs()
+	b.ResetTimer()
+	for tick := 0; tick < b.N; tick++ {
+		for i := 0; i < moving; i++ {
+			userID := userIDs[(tick*moving+i)%players]
+			trackers.Set(benchmarkRoomID, protocol.PlayerPosition{UserID: userID, XAxis: players + tick, YAxis: i})
+		}
+		states, changed := trackers.Commit(benchmarkRoomID, userIDs, nil)
+		if !changed {
+			b.Fatal("no state was committed")
+		}
+		for _, userID := range userIDs {
+			stateMsg := states[userID]
+			stateMsg.RoomID = benchmarkRoomID
+			data, err := json.Marshal(&stateMsg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			sent += len(data)
+			trackers.Ack(userID, stateMsg.Seq)
+		}
+	}
+	b.ReportMetric(float64(sent)/float64(b.N), "bytes/tick")
+}
+
+func benchmarkFullStateFrames(b *testing.B, players int, moving int) {
+	userGameLocations := make([]protocol.UserGameLocation, 0, players)
+	for i := 0; i < players; i++ {
+		userGameLocations = append(userGameLocations, protocol.UserGameLocation{UserID: uint(i + 1), RoomID: benchmarkRoomID, XAxis: i, YAxis: i})
+	}
+
+	var sent int
+	b.ReportAllocs()
+	b.ResetTimer()
+	for tick := 0; tick < b.N; tick++ {
+		for i := 0; i < moving; i++ {
+			mover := &userGameLocations[(tick*moving+i)%players]
+			mover.XAxis = players + tick
+			mover.YAxis = i
+			moveMsg := &protocol.GameMoved{
+				Type:              protocol.TypeMove,
+				FromUserID:        mover.UserID,
+				RoomID:            benchmarkRoomID,
+				UserGameLocations: userGameLocations,
+			}
+			// 接続ごとに Send で符号化される
+			for range userGameLocations {
+				data, err := json.Marshal(moveMsg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				sent += len(data)
+			}
+		}
+	}
+	b.ReportMetric(float64(sent)/float64(b.N), "bytes/tick")
+}
@@ -0,0 +1,180 @@
+package usecase
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+)
+
+// これ以上の位置が溜まった場合は間隔を待たずに書き込む
+const maxPendingPositions = 1000
+
+// 移動のたびに DB に書き込まず、最新の位置だけを一定間隔でまとめて書き込む
+// プロセスが異常終了した場合に失われるのは、最後の書き込みから interval の間 (最大 maxPendingPositions 件) の移動のみ
+type PositionWriter struct {
+	userLocationRepo     repository.UserLocationRepository
+	userGameLocationRepo repository.UserGameLocationRepository
+	interval             time.Duration
+
+	// 書き込み中に退出したユーザーの位置を書き戻さないよう、書き込みと退出を直列にする
+	flushMu           sync.Mutex
+	mu                sync.Mutex
+	userLocations     map[uint]*model.UserLocation     // Key: userID
+	userGameLocations map[uint]*model.UserGameLocation // Key: userID
+
+	flushNow chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewPositionWriter(userLocationRepo repository.UserLocationRepository, userGameLocationRepo repository.UserGameLocationRepository, interval time.Duration) *PositionWriter {
+	return &PositionWriter{
+		userLocationRepo:     userLocationRepo,
+		userGameLocationRepo: userGameLocationRepo,
+		interval:             interval,
+		userLocations:        make(map[uint]*model.UserLocation),
+		userGameLocations:    make(map[uint]*model.UserGameLocation),
+		flushNow:             make(chan struct{}, 1),
+		stop:                 make(chan struct{}),
+		done:                 make(chan struct{}),
+	}
+}
+
+func (w *PositionWriter) Start() {
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.Flush()
+			case <-w.flushNow:
+				w.Flush()
+			case <-w.stop:
+				w.Flush()
+				return
+			}
+		}
+	}()
+}
+
+// 溜まっている位置を書き込んでから止める
+func (w *PositionWriter) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+// 次の書き込みで保存する。同じユーザーの古い位置は上書きする
+func (w *PositionWriter) SaveUserLocation(userLocation *model.UserLocation) {
+	w.mu.Lock()
+	// 呼び出し元が書き換え続けるため、書き込みに必要な値だけを写しておく
+	w.userLocations[userLocation.UserID] = &model.UserLocation{
+		UserID: userLocation.UserID,
+		XAxis:  userLocation.XAxis,
+		YAxis:  userLocation.YAxis,
+	}
+	pending := len(w.userLocations) + len(w.userGameLocations)
+	w.mu.Unlock()
+	w.requestFlushIfFull(pending)
+}
+
+func (w *PositionWriter) SaveUserGameLocation(userGameLocation *model.UserGameLocation) {
+	w.mu.Lock()
+	w.userGameLocations[userGameLocation.UserID] = &model.UserGameLocation{
+		UserID: userGameLocation.UserID,
+		RoomID: userGameLocation.RoomID,
+		XAxis:  userGameLocation.XAxis,
+		YAxis:  userGameLocation.YAxis,
+		Status: userGameLocation.Status,
+	}
+	pending := len(w.userLocations) + len(w.userGameLocations)
+	w.mu.Unlock()
+	w.requestFlushIfFull(pending)
+}
+
+func (w *PositionWriter) requestFlushIfFull(pending int) {
+	if pending < maxPendingPositions {
+		return
+	}
+	select {
+	case w.flushNow <- struct{}{}:
+	default:
+	}
+}
+
+// 退出時に呼ぶ。溜まっている位置を捨てて userLocation の現在の状態をすぐに書き込む
+func (w *PositionWriter) FlushUserLocation(userLocation *model.UserLocation) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	delete(w.userLocations, userLocation.UserID)
+	w.mu.Unlock()
+	return w.userLocationRepo.UpdateUserLocation(userLocation)
+}
+
+func (w *PositionWriter) FlushUserGameLocation(userGameLocation *model.UserGameLocation) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	delete(w.userGameLocations, userGameLocation.UserID)
+	w.mu.Unlock()
+	return w.userGameLocationRepo.UpdateUserGameLocation(userGameLocation)
+}
+
+// 行を削除する前に呼び、削除した行を書き戻さないようにする
+func (w *PositionWriter) DiscardUserLocation(userID uint) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.userLocations, userID)
+}
+
+// 溜まっている位置をまとめて書き込む。失敗した分は次の書き込みで再試行する
+func (w *PositionWriter) Flush() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	userLocations := make([]*model.UserLocation, 0, len(w.userLocations))
+	for _, userLocation := range w.userLocations {
+		userLocations = append(userLocations, userLocation)
+	}
+	userGameLocations := make([]*model.UserGameLocation, 0, len(w.userGameLocations))
+	for _, userGameLocation := range w.userGameLocations {
+		userGameLocations = append(userGameLocations, userGameLocation)
+	}
+	w.userLocations = make(map[uint]*model.UserLocation)
+	w.userGameLocations = make(map[uint]*model.UserGameLocation)
+	w.mu.Unlock()
+
+	if len(userLocations) > 0 {
+		if err := w.userLocationRepo.UpsertUserLocations(userLocations); err != nil {
+			log.Printf("Error flushing user locations: %v", err)
+			w.mu.Lock()
+			for _, userLocation := range userLocations {
+				// 書き込み中に新しい位置が来ていればそちらを残す
+				if _, ok := w.userLocations[userLocation.UserID]; !ok {
+					w.userLocations[userLocation.UserID] = userLocation
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+	if len(userGameLocations) > 0 {
+		if err := w.userGameLocationRepo.UpsertUserGameLocations(userGameLocations); err != nil {
+			log.Printf("Error flushing user game locations: %v", err)
+			w.mu.Lock()
+			for _, userGameLocation := range userGameLocations {
+				if _, ok := w.userGameLocations[userGameLocation.UserID]; !ok {
+					w.userGameLocations[userGameLocation.UserID] = userGameLocation
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
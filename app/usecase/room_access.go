@@ -0,0 +1,140 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 参加する時にクライアントから送られてくるパスコードと招待コード
+type RoomAccess struct {
+	Passcode   string
+	InviteCode string
+}
+
+// ルームの公開範囲、パスコード、招待コードから参加できるかを判定する
+type RoomAccessChecker struct {
+	userRepo       repository.UserRepository
+	roomInviteRepo repository.RoomInviteRepository
+}
+
+func NewRoomAccessChecker(userRepo repository.UserRepository, roomInviteRepo repository.RoomInviteRepository) *RoomAccessChecker {
+	return &RoomAccessChecker{userRepo: userRepo, roomInviteRepo: roomInviteRepo}
+}
+
+// 参加できない場合は ErrAccessDenied を返す。所有者と管理者は常に参加できる
+// 招待コードを使った場合は使用回数を 1 増やし、その招待を返す
+func (c *RoomAccessChecker) Admit(room *model.Room, userID uint, access RoomAccess) (*model.RoomInvite, error) {
+	if room.Open() {
+		return nil, nil
+	}
+	privileged, err := c.isOwnerOrAdmin(room, userID)
+	if err != nil {
+		return nil, err
+	}
+	if privileged {
+		return nil, nil
+	}
+	if access.InviteCode != "" {
+		invite, ok, err := c.roomInviteRepo.UseRoomInvite(room.ID, access.InviteCode, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: invite code for room %d is invalid or expired", ErrAccessDenied, room.ID)
+		}
+		return invite, nil
+	}
+	if room.Visibility == model.RoomVisibilityInviteOnly || room.PasscodeHash == "" {
+		return nil, fmt.Errorf("%w: room %d requires an invite code", ErrAccessDenied, room.ID)
+	}
+	if access.Passcode == "" {
+		return nil, fmt.Errorf("%w: room %d requires a passcode", ErrAccessDenied, room.ID)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(room.PasscodeHash), []byte(access.Passcode)) != nil {
+		return nil, fmt.Errorf("%w: passcode for room %d is incorrect", ErrAccessDenied, room.ID)
+	}
+	return nil, nil
+}
+
+// 参加できなかった場合に Admit で使った招待の使用回数を戻す
+func (c *RoomAccessChecker) Release(invite *model.RoomInvite) {
+	if invite == nil {
+		return
+	}
+	if err := c.roomInviteRepo.ReleaseRoomInvite(invite.ID); err != nil {
+		log.Printf("Error releasing room invite %d: %v", invite.ID, err)
+	}
+}
+
+func (c *RoomAccessChecker) isOwnerOrAdmin(room *model.Room, userID uint) (bool, error) {
+	if room.OwnerID != 0 && room.OwnerID == userID {
+		return true, nil
+	}
+	user, exists, err := c.userRepo.GetUser(userID)
+	if err != nil {
+		return false, err
+	}
+	return exists && user.IsAdmin, nil
+}
+
+// パスコードと招待コードを確認し、参加を認めたルームを記録する。確認済みのルームの場合は何もしない
+func (ugc *UserGameLocationUsecase) admit(userGameLocation *model.UserGameLocation, room *model.Room, access RoomAccess) (*model.RoomInvite, error) {
+	if userGameLocation.AdmittedRoomID == room.ID {
+		return nil, nil
+	}
+	invite, err := ugc.roomAccessChecker.Admit(room, userGameLocation.UserID, access)
+	if err != nil {
+		return nil, err
+	}
+	userGameLocation.AdmittedRoomID = room.ID
+	return invite, nil
+}
+
+// /ws の接続の場合。確認済みのルームは /game の接続とは別に記録する
+func (uc *UserLocationUsecase) admit(userLocation *model.UserLocation, room *model.Room, access RoomAccess) (*model.RoomInvite, error) {
+	if userLocation.AdmittedRoomID == room.ID {
+		return nil, nil
+	}
+	invite, err := uc.roomAccessChecker.Admit(room, userLocation.UserID, access)
+	if err != nil {
+		return nil, err
+	}
+	userLocation.AdmittedRoomID = room.ID
+	return invite, nil
+}
+
+// bcrypt は 72 バイトまでしか使わないため、それより長いパスコードは受け付けない
+const (
+	minPasscodeLength = 4
+	maxPasscodeLength = 72
+)
+
+// 空のパスコードはパスコードなしとして空のハッシュを返す
+func hashPasscode(passcode string) (string, error) {
+	if passcode == "" {
+		return "", nil
+	}
+	if len(passcode) < minPasscodeLength || len(passcode) > maxPasscodeLength {
+		return "", fmt.Errorf("%w: passcode must be between %d and %d bytes", ErrInvalidArgument, minPasscodeLength, maxPasscodeLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash passcode: %w", err)
+	}
+	return string(hash), nil
+}
+
+func newInviteCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate invite code: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,148 @@
+package usecase
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+)
+
+// ルームの公開範囲・パスコード・招待コード・所有者の変更。ルームの所有者と管理者だけが行える
+type RoomAccessUsecase struct {
+	roomRepo                repository.RoomRepository
+	roomInviteRepo          repository.RoomInviteRepository
+	userRepo                repository.UserRepository
+	userGameLocationUsecase *UserGameLocationUsecase
+	roomChangeNotifier      RoomChangeNotifier
+}
+
+func NewRoomAccessUsecase(roomRepo repository.RoomRepository, roomInviteRepo repository.RoomInviteRepository, userRepo repository.UserRepository, userGameLocationUsecase *UserGameLocationUsecase, roomChangeNotifier RoomChangeNotifier) *RoomAccessUsecase {
+	return &RoomAccessUsecase{roomRepo: roomRepo, roomInviteRepo: roomInviteRepo, userRepo: userRepo, userGameLocationUsecase: userGameLocationUsecase, roomChangeNotifier: roomChangeNotifier}
+}
+
+// passcode が nil の場合はパスコードを変更せず、空文字の場合はパスコードを外す
+func (uc *RoomAccessUsecase) UpdateAccess(actorID uint, roomID uint, visibility string, passcode *string) (*model.Room, error) {
+	if !model.IsRoomVisibility(visibility) {
+		return nil, fmt.Errorf("%w: visibility must be %q, %q or %q", ErrInvalidArgument, model.RoomVisibilityPublic, model.RoomVisibilityPrivate, model.RoomVisibilityInviteOnly)
+	}
+	room, err := uc.authorize(actorID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	previousListed := room.Listed()
+	room.Visibility = visibility
+	if passcode != nil {
+		room.PasscodeHash, err = hashPasscode(*passcode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = uc.roomRepo.UpdateRoom(room)
+	if err != nil {
+		return nil, err
+	}
+	if previousListed && !room.Listed() {
+		uc.roomChangeNotifier.NotifyRoomRemoved(room.AreaID, room.ID)
+	} else {
+		uc.roomChangeNotifier.NotifyRoomChanged(room.ID)
+	}
+	return room, nil
+}
+
+// 所有者を ownerID のユーザーに変更し、ルームの参加者に知らせる
+func (uc *RoomAccessUsecase) TransferOwnership(actorID uint, roomID uint, ownerID uint) (*model.Room, error) {
+	if ownerID == 0 {
+		return nil, fmt.Errorf("%w: ownerID must be greater than 0", ErrInvalidArgument)
+	}
+	room, err := uc.authorize(actorID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	_, exists, err := uc.userRepo.GetUser(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: user %d does not exist", ErrInvalidArgument, ownerID)
+	}
+	if room.OwnerID == ownerID {
+		return room, nil
+	}
+	err = uc.userGameLocationUsecase.changeOwner(room, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// ttl が 0 の場合は無期限、maxUses が 0 の場合は回数の制限なし
+func (uc *RoomAccessUsecase) CreateInvite(actorID uint, roomID uint, ttl time.Duration, maxUses int) (*model.RoomInvite, error) {
+	if ttl < 0 {
+		return nil, fmt.Errorf("%w: ttl must not be negative", ErrInvalidArgument)
+	}
+	if maxUses < 0 {
+		return nil, fmt.Errorf("%w: maxUses must not be negative", ErrInvalidArgument)
+	}
+	room, err := uc.authorize(actorID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	code, err := newInviteCode()
+	if err != nil {
+		return nil, err
+	}
+	invite := &model.RoomInvite{
+		RoomID:    room.ID,
+		Code:      code,
+		CreatedBy: actorID,
+		MaxUses:   maxUses,
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		invite.ExpiresAt = &expiresAt
+	}
+	err = uc.roomInviteRepo.AddRoomInvite(invite)
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func (uc *RoomAccessUsecase) ListInvites(actorID uint, roomID uint) ([]*model.RoomInvite, error) {
+	room, err := uc.authorize(actorID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return uc.roomInviteRepo.GetRoomInvitesByRoomId(room.ID)
+}
+
+func (uc *RoomAccessUsecase) RevokeInvite(actorID uint, roomID uint, inviteID uint) error {
+	room, err := uc.authorize(actorID, roomID)
+	if err != nil {
+		return err
+	}
+	invite, exists, err := uc.roomInviteRepo.GetRoomInvite(inviteID)
+	if err != nil {
+		return err
+	}
+	if !exists || invite.RoomID != room.ID {
+		return fmt.Errorf("%w: invite %d of room %d", ErrNotFound, inviteID, room.ID)
+	}
+	return uc.roomInviteRepo.RemoveRoomInvite(inviteID)
+}
+
+func (uc *RoomAccessUsecase) authorize(actorID uint, roomID uint) (*model.Room, error) {
+	room, exists, err := uc.roomRepo.GetRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: room %d", ErrNotFound, roomID)
+	}
+	err = authorizeRoomOwner(uc.userRepo, actorID, room)
+	if err != nil {
+		return nil, err
+	}
+	return room, nil
+}
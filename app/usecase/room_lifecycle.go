@@ -0,0 +1,180 @@
+package usecase
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ルームの状態ごとの時間。0 の場合の扱いは config.Lifecycle と同じ
+type RoomLifecycleOptions struct {
+	ReadyCheckTimeout time.Duration
+	Countdown         time.Duration
+	Results           time.Duration
+	EmptyRoomTimeout  time.Duration
+}
+
+// ルームごとの準備の状況とタイマー。状態そのものは rooms.status に持つ
+type roomLifecycle struct {
+	// 状態が変わるたびに増やし、前の状態のタイマーが遅れて発火しても無視する
+	generation uint64
+	ready      map[uint]bool
+	deadline   *time.Time
+	timer      *time.Timer
+	emptyTimer *time.Timer
+}
+
+type roomLifecycles struct {
+	mu    sync.Mutex
+	rooms map[uint]*roomLifecycle
+	// ユーザーが参加しているルーム。参加済みのユーザーはゲーム中のルームにも join-audio などで接続し直せる
+	members map[uint]uint
+}
+
+func newRoomLifecycles() *roomLifecycles {
+	return &roomLifecycles{
+		rooms:   make(map[uint]*roomLifecycle),
+		members: make(map[uint]uint),
+	}
+}
+
+func (l *roomLifecycles) getLocked(roomID uint) *roomLifecycle {
+	room, ok := l.rooms[roomID]
+	if !ok {
+		room = &roomLifecycle{ready: make(map[uint]bool)}
+		l.rooms[roomID] = room
+	}
+	return room
+}
+
+// userID を roomID の参加者にし、削除待ちのタイマーを止める。別のルームから移ってきた場合はそのルームを返す
+func (l *roomLifecycles) Join(userID uint, roomID uint) (uint, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	previousRoomID, ok := l.members[userID]
+	l.members[userID] = roomID
+	room := l.getLocked(roomID)
+	if room.emptyTimer != nil {
+		room.emptyTimer.Stop()
+		room.emptyTimer = nil
+	}
+	if ok && previousRoomID != roomID {
+		delete(l.getLocked(previousRoomID).ready, userID)
+		return previousRoomID, true
+	}
+	return 0, false
+}
+
+// roomID から userID を外す。既に別のルームに移っている場合は何もしない
+func (l *roomLifecycles) Leave(userID uint, roomID uint) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if current, ok := l.members[userID]; !ok || current != roomID {
+		return false
+	}
+	delete(l.members, userID)
+	delete(l.getLocked(roomID).ready, userID)
+	return true
+}
+
+func (l *roomLifecycles) RoomOf(userID uint) uint {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.members[userID]
+}
+
+// 新しい状態に入る。準備の状況を消し、after 後に expire を呼ぶ。expire が nil の場合はタイマーを使わない
+func (l *roomLifecycles) Enter(roomID uint, after time.Duration, expire func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	room := l.getLocked(roomID)
+	room.generation++
+	room.ready = make(map[uint]bool)
+	room.deadline = nil
+	if room.timer != nil {
+		room.timer.Stop()
+		room.timer = nil
+	}
+	if expire == nil {
+		return
+	}
+	deadline := time.Now().Add(after)
+	room.deadline = &deadline
+	generation := room.generation
+	room.timer = time.AfterFunc(after, func() {
+		l.mu.Lock()
+		current := room.generation
+		l.mu.Unlock()
+		if current == generation {
+			expire()
+		}
+	})
+}
+
+// 準備の状況を更新し、準備ができているユーザーを返す
+func (l *roomLifecycles) SetReady(roomID uint, userID uint, ready bool) []uint {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	room := l.getLocked(roomID)
+	if ready {
+		room.ready[userID] = true
+	} else {
+		delete(room.ready, userID)
+	}
+	return readyUserIDsLocked(room)
+}
+
+// 準備ができているユーザーと、今の状態が終わる時刻
+func (l *roomLifecycles) Snapshot(roomID uint) ([]uint, *time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	room, ok := l.rooms[roomID]
+	if !ok {
+		return nil, nil
+	}
+	return readyUserIDsLocked(room), room.deadline
+}
+
+func (l *roomLifecycles) IsReady(roomID uint, userID uint) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	room, ok := l.rooms[roomID]
+	return ok && room.ready[userID]
+}
+
+// after 後に cleanUp を呼ぶ。その前に誰かが参加した場合は Join で止める
+func (l *roomLifecycles) ScheduleCleanup(roomID uint, after time.Duration, cleanUp func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	room := l.getLocked(roomID)
+	if room.emptyTimer != nil {
+		room.emptyTimer.Stop()
+	}
+	room.emptyTimer = time.AfterFunc(after, cleanUp)
+}
+
+// 削除したルームのタイマーを止めて状態を捨てる
+func (l *roomLifecycles) Drop(roomID uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	room, ok := l.rooms[roomID]
+	if !ok {
+		return
+	}
+	if room.timer != nil {
+		room.timer.Stop()
+	}
+	if room.emptyTimer != nil {
+		room.emptyTimer.Stop()
+	}
+	delete(l.rooms, roomID)
+}
+
+func readyUserIDsLocked(room *roomLifecycle) []uint {
+	userIDs := make([]uint, 0, len(room.ready))
+	for userID := range room.ready {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+	return userIDs
+}
@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"log"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// 所有者がルームから抜けた場合は、残っているユーザーのうち UserID が最も小さいユーザーに所有者を移す
+// 誰も残っていない場合は所有者のままにする
+func (ugc *UserGameLocationUsecase) handOverOwnership(userID uint, roomID uint) {
+	if roomID == 0 {
+		return
+	}
+	room, exists, err := ugc.roomRepo.GetRoom(roomID)
+	if err != nil {
+		log.Printf("Error getting room %d for ownership transfer: %v", roomID, err)
+		return
+	}
+	if !exists || room.OwnerID != userID {
+		return
+	}
+	var next *model.UserGameLocation
+	for _, otherClient := range ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(roomID) {
+		if otherClient.UserID == userID {
+			continue
+		}
+		if next == nil || otherClient.UserID < next.UserID {
+			next = otherClient
+		}
+	}
+	if next == nil {
+		return
+	}
+	err = ugc.changeOwner(room, next.UserID)
+	if err != nil {
+		log.Printf("Error transferring ownership of room %d: %v", roomID, err)
+	}
+}
+
+func (ugc *UserGameLocationUsecase) changeOwner(room *model.Room, ownerID uint) error {
+	previousOwnerID := room.OwnerID
+	room.OwnerID = ownerID
+	err := ugc.roomRepo.UpdateRoom(room)
+	if err != nil {
+		room.OwnerID = previousOwnerID
+		return err
+	}
+	log.Printf("room %d owner changed from %d to %d", room.ID, previousOwnerID, ownerID)
+	ownerMsg := &protocol.OwnerChanged{
+		Type:            protocol.TypeOwnerChanged,
+		RoomID:          room.ID,
+		OwnerID:         ownerID,
+		PreviousOwnerID: previousOwnerID,
+	}
+	for _, otherClient := range ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(room.ID) {
+		err := ugc.deliver(otherClient, ownerMsg)
+		if err != nil {
+			log.Printf("Error sending message to client: %v", err)
+			ugc.DisconnectUserGameLocation(otherClient)
+		}
+	}
+	ugc.roomChangeNotifier.NotifyRoomChanged(room.ID)
+	return nil
+}
@@ -0,0 +1,278 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/protocol"
+)
+
+// 参加中のルームで準備の確認を始める
+func (ugc *UserGameLocationUsecase) StartReadyCheck(userGameLocation *model.UserGameLocation) error {
+	room, err := ugc.controlledRoom(userGameLocation)
+	if err != nil {
+		return err
+	}
+	return ugc.requestTransition(room, model.RoomStatusReadyCheck)
+}
+
+// ready-check 中に準備の状況を更新する。全員の準備ができた時点でカウントダウンを始める
+func (ugc *UserGameLocationUsecase) SetReady(userGameLocation *model.UserGameLocation, ready bool) error {
+	room, err := ugc.joinedRoom(userGameLocation)
+	if err != nil {
+		return err
+	}
+	if room.Status != model.RoomStatusReadyCheck {
+		return fmt.Errorf("%w: room %d is %s", ErrConflict, room.ID, room.Status)
+	}
+	ugc.lifecycles.SetReady(room.ID, userGameLocation.UserID, ready)
+	ugc.broadcastRoomState(room, "")
+	ugc.startIfAllReady(room)
+	return nil
+}
+
+// ゲームを終えて結果の表示に移る
+func (ugc *UserGameLocationUsecase) EndGame(userGameLocation *model.UserGameLocation) error {
+	room, err := ugc.controlledRoom(userGameLocation)
+	if err != nil {
+		return err
+	}
+	return ugc.requestTransition(room, model.RoomStatusResults)
+}
+
+func (ugc *UserGameLocationUsecase) joinedRoom(userGameLocation *model.UserGameLocation) (*model.Room, error) {
+	if _, joined := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); !joined || userGameLocation.RoomID == 0 {
+		return nil, fmt.Errorf("%w: user %d has not joined any room", ErrNotInRoom, userGameLocation.UserID)
+	}
+	room, exists, err := ugc.roomRepo.GetRoom(userGameLocation.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: room %d does not exist", ErrNotInRoom, userGameLocation.RoomID)
+	}
+	return room, nil
+}
+
+// 状態を進められるのは所有者だけ。所有者のいないルームでは参加者の誰でも進められる
+func (ugc *UserGameLocationUsecase) controlledRoom(userGameLocation *model.UserGameLocation) (*model.Room, error) {
+	room, err := ugc.joinedRoom(userGameLocation)
+	if err != nil {
+		return nil, err
+	}
+	if room.OwnerID != 0 && room.OwnerID != userGameLocation.UserID {
+		return nil, fmt.Errorf("%w: user %d is not the owner of room %d", ErrUnauthorized, userGameLocation.UserID, room.ID)
+	}
+	return room, nil
+}
+
+// クライアントからの要求による遷移。遷移できない場合は ErrConflict を返す
+func (ugc *UserGameLocationUsecase) requestTransition(room *model.Room, to model.RoomStatus) error {
+	transitioned, err := ugc.transitionRoom(room, to)
+	if err != nil {
+		return err
+	}
+	if !transitioned {
+		return fmt.Errorf("%w: room %d changed its state concurrently", ErrConflict, room.ID)
+	}
+	return nil
+}
+
+// room.Status から to に遷移し、ルームの全員に room-state を送る
+// 遷移できない組み合わせの場合は ErrConflict を、他の遷移が先に行われていた場合は false を返す
+func (ugc *UserGameLocationUsecase) transitionRoom(room *model.Room, to model.RoomStatus) (bool, error) {
+	from := room.Status
+	if !from.CanTransitionTo(to) {
+		return false, fmt.Errorf("%w: room %d cannot change from %s to %s", ErrConflict, room.ID, from, to)
+	}
+	transitioned, err := ugc.roomRepo.UpdateRoomStatus(room.ID, from, to)
+	if err != nil || !transitioned {
+		return false, err
+	}
+	room.Status = to
+	log.Printf("room %d changed from %s to %s", room.ID, from, to)
+	if from == model.RoomStatusPlaying {
+		// 結果は room-state より先に届くようにする
+		ugc.minigames.Stop(room.ID)
+	}
+
+	roomID := room.ID
+	switch to {
+	case model.RoomStatusReadyCheck:
+		ugc.lifecycles.Enter(roomID, ugc.lifecycleOptions.ReadyCheckTimeout, func() {
+			ugc.expireRoomStatus(roomID, model.RoomStatusReadyCheck, model.RoomStatusWaiting)
+		})
+	case model.RoomStatusCountdown:
+		ugc.lifecycles.Enter(roomID, ugc.lifecycleOptions.Countdown, func() {
+			ugc.expireRoomStatus(roomID, model.RoomStatusCountdown, model.RoomStatusPlaying)
+		})
+	case model.RoomStatusResults:
+		ugc.lifecycles.Enter(roomID, ugc.lifecycleOptions.Results, func() {
+			ugc.expireRoomStatus(roomID, model.RoomStatusResults, model.RoomStatusWaiting)
+		})
+	default:
+		ugc.lifecycles.Enter(roomID, 0, nil)
+	}
+	ugc.broadcastRoomState(room, from.String())
+	ugc.roomChangeNotifier.NotifyRoomChanged(roomID)
+	if to == model.RoomStatusPlaying {
+		ugc.startMinigame(room)
+	}
+	if to == model.RoomStatusWaiting && ugc.inMemoryWaitingQueueRepo != nil {
+		// ゲーム中に並んだユーザーを入れる
+		go ugc.admitWaiting(roomID)
+	}
+	return true, nil
+}
+
+// タイマーで from から to に進める。既に別の状態に移っている場合は何もしない
+func (ugc *UserGameLocationUsecase) expireRoomStatus(roomID uint, from model.RoomStatus, to model.RoomStatus) {
+	room, exists, err := ugc.roomRepo.GetRoom(roomID)
+	if err != nil {
+		log.Printf("Error getting room %d: %v", roomID, err)
+		return
+	}
+	if !exists || room.Status != from {
+		return
+	}
+	_, err = ugc.transitionRoom(room, to)
+	if err != nil {
+		log.Printf("Error changing state of room %d: %v", roomID, err)
+	}
+}
+
+// ready-check 中にルームの全員の準備ができていればカウントダウンを始める
+func (ugc *UserGameLocationUsecase) startIfAllReady(room *model.Room) {
+	if room.Status != model.RoomStatusReadyCheck {
+		return
+	}
+	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(room.ID)
+	if len(connectedUserGameLocations) == 0 {
+		return
+	}
+	for _, userGameLocation := range connectedUserGameLocations {
+		if !ugc.lifecycles.IsReady(room.ID, userGameLocation.UserID) {
+			return
+		}
+	}
+	_, err := ugc.transitionRoom(room, model.RoomStatusCountdown)
+	if err != nil {
+		log.Printf("Error starting countdown in room %d: %v", room.ID, err)
+	}
+}
+
+// ゲーム中のルームには参加済みのユーザーしか接続できない
+// 誰もいないのにゲーム中のままのルームは、前回の起動時の状態が残っているだけなので waiting に戻す
+func (ugc *UserGameLocationUsecase) checkRoomAcceptsPlayer(room *model.Room, userID uint) error {
+	if room.Status.AcceptsPlayers() || ugc.lifecycles.RoomOf(userID) == room.ID {
+		return nil
+	}
+	if room.Status != model.RoomStatusClosed && len(ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(room.ID)) == 0 {
+		if _, err := ugc.transitionRoom(room, model.RoomStatusWaiting); err != nil {
+			return err
+		}
+		if room.Status.AcceptsPlayers() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: room %d is %s", ErrRoomBusy, room.ID, room.Status)
+}
+
+// ルームからユーザーが抜けた後の処理。誰もいなくなった場合はゲームを中断し、一定時間後にルームを閉じる
+func (ugc *UserGameLocationUsecase) onRoomMemberLeft(roomID uint, userID uint) {
+	ugc.minigames.PlayerLeft(roomID, userID)
+	room, exists, err := ugc.roomRepo.GetRoom(roomID)
+	if err != nil {
+		log.Printf("Error getting room %d: %v", roomID, err)
+		return
+	}
+	if !exists {
+		ugc.lifecycles.Drop(roomID)
+		return
+	}
+	if len(ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(roomID)) > 0 {
+		// 準備ができていないユーザーが抜けて全員揃った場合
+		ugc.startIfAllReady(room)
+		return
+	}
+	if room.Status != model.RoomStatusWaiting && room.Status != model.RoomStatusClosed {
+		if _, err := ugc.transitionRoom(room, model.RoomStatusWaiting); err != nil {
+			log.Printf("Error resetting state of room %d: %v", roomID, err)
+		}
+	}
+	if ugc.lifecycleOptions.EmptyRoomTimeout > 0 {
+		ugc.lifecycles.ScheduleCleanup(roomID, ugc.lifecycleOptions.EmptyRoomTimeout, func() {
+			ugc.closeEmptyRoom(roomID)
+		})
+	}
+}
+
+// 誰もいないままのルームを閉じて削除する
+func (ugc *UserGameLocationUsecase) closeEmptyRoom(roomID uint) {
+	if len(ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(roomID)) > 0 {
+		return
+	}
+	room, exists, err := ugc.roomRepo.GetRoom(roomID)
+	if err != nil {
+		log.Printf("Error getting room %d: %v", roomID, err)
+		return
+	}
+	if !exists {
+		ugc.lifecycles.Drop(roomID)
+		return
+	}
+	if room.Status != model.RoomStatusClosed {
+		transitioned, err := ugc.transitionRoom(room, model.RoomStatusClosed)
+		if err != nil || !transitioned {
+			log.Printf("Error closing room %d: %v", roomID, err)
+			return
+		}
+	}
+	err = ugc.roomRepo.RemoveRoom(roomID)
+	if err != nil {
+		log.Printf("Error removing room %d: %v", roomID, err)
+		return
+	}
+	ugc.lifecycles.Drop(roomID)
+	ugc.roomChangeNotifier.NotifyRoomRemoved(room.AreaID, roomID)
+	log.Printf("removed empty room %d", roomID)
+}
+
+func (ugc *UserGameLocationUsecase) roomStateMessage(room *model.Room, previousState string) *protocol.RoomState {
+	readyUserIDs, deadline := ugc.lifecycles.Snapshot(room.ID)
+	return &protocol.RoomState{
+		Type:          protocol.TypeRoomState,
+		RoomID:        room.ID,
+		State:         room.Status.String(),
+		PreviousState: previousState,
+		ReadyUserIDs:  readyUserIDs,
+		Deadline:      deadline,
+	}
+}
+
+func (ugc *UserGameLocationUsecase) broadcastRoomState(room *model.Room, previousState string) {
+	stateMsg := ugc.roomStateMessage(room, previousState)
+	for _, otherClient := range ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(room.ID) {
+		err := ugc.deliver(otherClient, stateMsg)
+		if err != nil {
+			log.Printf("Error sending message to client: %v", err)
+			ugc.DisconnectUserGameLocation(otherClient)
+		}
+	}
+}
+
+// 参加したユーザーに今の状態を送る
+func (ugc *UserGameLocationUsecase) sendRoomState(userGameLocation *model.UserGameLocation) {
+	room, exists, err := ugc.roomRepo.GetRoom(userGameLocation.RoomID)
+	if err != nil {
+		log.Printf("Error getting room %d: %v", userGameLocation.RoomID, err)
+		return
+	}
+	if !exists {
+		return
+	}
+	if err := ugc.deliver(userGameLocation, ugc.roomStateMessage(room, "")); err != nil {
+		log.Printf("Error sending message to client: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package usecase
+
+import (
+	"fmt"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"github.com/sako0/minigame-space-api/app/minigame"
+)
+
+type RoomTypeUsecase struct {
+	roomTypeRepo repository.RoomTypeRepository
+	roomRepo     repository.RoomRepository
+	minigames    *minigame.Registry
+}
+
+func NewRoomTypeUsecase(roomTypeRepo repository.RoomTypeRepository, roomRepo repository.RoomRepository, minigames *minigame.Registry) *RoomTypeUsecase {
+	return &RoomTypeUsecase{roomTypeRepo: roomTypeRepo, roomRepo: roomRepo, minigames: minigames}
+}
+
+func (uc *RoomTypeUsecase) GetRoomType(roomTypeID uint) (*model.RoomType, error) {
+	roomType, exists, err := uc.roomTypeRepo.GetRoomType(roomTypeID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: room type %d", ErrNotFound, roomTypeID)
+	}
+	return roomType, nil
+}
+
+func (uc *RoomTypeUsecase) ListRoomTypes(pagination Pagination) ([]*model.RoomType, int64, error) {
+	if err := pagination.validate(); err != nil {
+		return nil, 0, err
+	}
+	return uc.roomTypeRepo.GetRoomTypes(pagination.offset(), pagination.PerPage)
+}
+
+func (uc *RoomTypeUsecase) CreateRoomType(roomType *model.RoomType) error {
+	if err := uc.validateRoomType(roomType); err != nil {
+		return err
+	}
+	return uc.roomTypeRepo.AddRoomType(roomType)
+}
+
+func (uc *RoomTypeUsecase) UpdateRoomType(roomTypeID uint, input *model.RoomType) (*model.RoomType, error) {
+	if err := uc.validateRoomType(input); err != nil {
+		return nil, err
+	}
+	roomType, err := uc.GetRoomType(roomTypeID)
+	if err != nil {
+		return nil, err
+	}
+	roomType.Name = input.Name
+	roomType.MaxParticipant = input.MaxParticipant
+	roomType.Description = input.Description
+	roomType.MovementRule = input.MovementRule
+	roomType.VoiceMode = input.VoiceMode
+	roomType.Minigame = input.Minigame
+	err = uc.roomTypeRepo.UpdateRoomType(roomType)
+	if err != nil {
+		return nil, err
+	}
+	return roomType, nil
+}
+
+// 使われているルームタイプは削除できない
+func (uc *RoomTypeUsecase) DeleteRoomType(roomTypeID uint) error {
+	if _, err := uc.GetRoomType(roomTypeID); err != nil {
+		return err
+	}
+	count, err := uc.roomRepo.CountRoomsByRoomTypeId(roomTypeID)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: room type %d is used by %d rooms", ErrConflict, roomTypeID, count)
+	}
+	return uc.roomTypeRepo.RemoveRoomType(roomTypeID)
+}
+
+func (uc *RoomTypeUsecase) validateRoomType(roomType *model.RoomType) error {
+	if err := validateRoomType(roomType); err != nil {
+		return err
+	}
+	if roomType.Minigame != "" && !uc.minigames.Has(roomType.Minigame) {
+		return fmt.Errorf("%w: minigame must be one of %q", ErrInvalidArgument, uc.minigames.Names())
+	}
+	return nil
+}
+
+func validateRoomType(roomType *model.RoomType) error {
+	if err := validateLength("name", roomType.Name, true); err != nil {
+		return err
+	}
+	if err := validateLength("description", roomType.Description, false); err != nil {
+		return err
+	}
+	if roomType.MaxParticipant < 0 {
+		return fmt.Errorf("%w: maxParticipant must not be negative", ErrInvalidArgument)
+	}
+	switch roomType.VoiceMode {
+	case "":
+		roomType.VoiceMode = model.VoiceModeMesh
+	case model.VoiceModeMesh, model.VoiceModeSFU:
+	default:
+		return fmt.Errorf("%w: voiceMode must be %q or %q", ErrInvalidArgument, model.VoiceModeMesh, model.VoiceModeSFU)
+	}
+	return validateMovementRule(&roomType.MovementRule)
+}
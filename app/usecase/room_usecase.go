@@ -0,0 +1,197 @@
+package usecase
+
+import (
+	"fmt"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
+)
+
+type RoomUsecase struct {
+	roomRepo                     repository.RoomRepository
+	areaRepo                     repository.AreaRepository
+	roomTypeRepo                 repository.RoomTypeRepository
+	inMemoryUserGameLocationRepo repository.InMemoryUserGameLocationRepository
+	roomChangeNotifier           RoomChangeNotifier
+	userRepo                     repository.UserRepository
+}
+
+func NewRoomUsecase(roomRepo repository.RoomRepository, areaRepo repository.AreaRepository, roomTypeRepo repository.RoomTypeRepository, inMemoryUserGameLocationRepo repository.InMemoryUserGameLocationRepository, roomChangeNotifier RoomChangeNotifier, userRepo repository.UserRepository) *RoomUsecase {
+	return &RoomUsecase{roomRepo: roomRepo, areaRepo: areaRepo, roomTypeRepo: roomTypeRepo, inMemoryUserGameLocationRepo: inMemoryUserGameLocationRepo, roomChangeNotifier: roomChangeNotifier, userRepo: userRepo}
+}
+
+// ルームと現在 /game に接続してルームに参加しているユーザー数
+type RoomWithOccupancy struct {
+	Room      *model.Room
+	Occupancy int
+}
+
+func (uc *RoomUsecase) GetRoom(roomID uint) (*RoomWithOccupancy, error) {
+	room, exists, err := uc.roomRepo.GetRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: room %d", ErrNotFound, roomID)
+	}
+	return uc.withOccupancy(room), nil
+}
+
+func (uc *RoomUsecase) ListRooms(pagination Pagination) ([]*RoomWithOccupancy, int64, error) {
+	if err := pagination.validate(); err != nil {
+		return nil, 0, err
+	}
+	rooms, total, err := uc.roomRepo.GetRooms(pagination.offset(), pagination.PerPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return uc.withOccupancies(rooms), total, nil
+}
+
+func (uc *RoomUsecase) ListRoomsInArea(areaID uint, pagination Pagination) ([]*RoomWithOccupancy, int64, error) {
+	if err := pagination.validate(); err != nil {
+		return nil, 0, err
+	}
+	_, exists, err := uc.areaRepo.GetArea(areaID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !exists {
+		return nil, 0, fmt.Errorf("%w: area %d", ErrNotFound, areaID)
+	}
+	rooms, total, err := uc.roomRepo.GetRoomsByAreaId(areaID, pagination.offset(), pagination.PerPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return uc.withOccupancies(rooms), total, nil
+}
+
+// passcode が空の場合はパスコードなし
+func (uc *RoomUsecase) CreateRoom(room *model.Room, passcode string) (*RoomWithOccupancy, error) {
+	if room.Visibility == "" {
+		room.Visibility = model.RoomVisibilityPublic
+	}
+	if !model.IsRoomVisibility(room.Visibility) {
+		return nil, fmt.Errorf("%w: visibility must be %q, %q or %q", ErrInvalidArgument, model.RoomVisibilityPublic, model.RoomVisibilityPrivate, model.RoomVisibilityInviteOnly)
+	}
+	if err := uc.validateRoom(room); err != nil {
+		return nil, err
+	}
+	passcodeHash, err := hashPasscode(passcode)
+	if err != nil {
+		return nil, err
+	}
+	room.PasscodeHash = passcodeHash
+	room.Status = model.RoomStatusWaiting
+	err = uc.roomRepo.AddRoom(room)
+	if err != nil {
+		return nil, err
+	}
+	uc.roomChangeNotifier.NotifyRoomChanged(room.ID)
+	return uc.GetRoom(room.ID)
+}
+
+// ルームの所有者と管理者だけが変更できる
+func (uc *RoomUsecase) UpdateRoom(actorID uint, roomID uint, input *model.Room) (*RoomWithOccupancy, error) {
+	if err := uc.validateRoom(input); err != nil {
+		return nil, err
+	}
+	room, exists, err := uc.roomRepo.GetRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: room %d", ErrNotFound, roomID)
+	}
+	err = authorizeRoomOwner(uc.userRepo, actorID, room)
+	if err != nil {
+		return nil, err
+	}
+	previousAreaID := room.AreaID
+	room.AreaID = input.AreaID
+	room.RoomTypeID = input.RoomTypeID
+	err = uc.roomRepo.UpdateRoom(room)
+	if err != nil {
+		return nil, err
+	}
+	if previousAreaID != room.AreaID {
+		uc.roomChangeNotifier.NotifyRoomRemoved(previousAreaID, roomID)
+	}
+	uc.roomChangeNotifier.NotifyRoomChanged(roomID)
+	return uc.GetRoom(roomID)
+}
+
+// ルームの所有者と管理者だけが削除できる。参加中のユーザーがいるルームは削除できない
+func (uc *RoomUsecase) DeleteRoom(actorID uint, roomID uint) error {
+	room, err := uc.GetRoom(roomID)
+	if err != nil {
+		return err
+	}
+	err = authorizeRoomOwner(uc.userRepo, actorID, room.Room)
+	if err != nil {
+		return err
+	}
+	if room.Occupancy > 0 {
+		return fmt.Errorf("%w: room %d still has %d participants", ErrConflict, roomID, room.Occupancy)
+	}
+	err = uc.roomRepo.RemoveRoom(roomID)
+	if err != nil {
+		return err
+	}
+	uc.roomChangeNotifier.NotifyRoomRemoved(room.Room.AreaID, roomID)
+	return nil
+}
+
+func (uc *RoomUsecase) validateRoom(room *model.Room) error {
+	if room.AreaID == 0 {
+		return fmt.Errorf("%w: areaID must be greater than 0", ErrInvalidArgument)
+	}
+	if room.RoomTypeID == 0 {
+		return fmt.Errorf("%w: roomTypeID must be greater than 0", ErrInvalidArgument)
+	}
+	_, exists, err := uc.areaRepo.GetArea(room.AreaID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: area %d does not exist", ErrInvalidArgument, room.AreaID)
+	}
+	_, exists, err = uc.roomTypeRepo.GetRoomType(room.RoomTypeID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: room type %d does not exist", ErrInvalidArgument, room.RoomTypeID)
+	}
+	return nil
+}
+
+// ルームの所有者か管理者でなければ ErrUnauthorized を返す
+func authorizeRoomOwner(userRepo repository.UserRepository, actorID uint, room *model.Room) error {
+	if room.OwnerID != 0 && room.OwnerID == actorID {
+		return nil
+	}
+	actor, exists, err := userRepo.GetUser(actorID)
+	if err != nil {
+		return err
+	}
+	if !exists || !actor.IsAdmin {
+		return fmt.Errorf("%w: user %d is not the owner of room %d", ErrUnauthorized, actorID, room.ID)
+	}
+	return nil
+}
+
+func (uc *RoomUsecase) withOccupancy(room *model.Room) *RoomWithOccupancy {
+	return &RoomWithOccupancy{
+		Room:      room,
+		Occupancy: len(uc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(room.ID)),
+	}
+}
+
+func (uc *RoomUsecase) withOccupancies(rooms []*model.Room) []*RoomWithOccupancy {
+	roomsWithOccupancy := make([]*RoomWithOccupancy, 0, len(rooms))
+	for _, room := range rooms {
+		roomsWithOccupancy = append(roomsWithOccupancy, uc.withOccupancy(room))
+	}
+	return roomsWithOccupancy
+}
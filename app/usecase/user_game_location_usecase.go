@@ -2,30 +2,90 @@
 package usecase
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/sako0/minigame-space-api/app/domain/model"
 	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"github.com/sako0/minigame-space-api/app/minigame"
+	"github.com/sako0/minigame-space-api/app/protocol"
+	"github.com/sako0/minigame-space-api/app/sfu"
+	"github.com/sako0/minigame-space-api/app/wsconn"
 )
 
 type UserGameLocationUsecase struct {
 	userGameLocationRepo         repository.UserGameLocationRepository
 	inMemoryUserGameLocationRepo repository.InMemoryUserGameLocationRepository
+	inMemoryGameSessionRepo      repository.InMemoryGameSessionRepository
+	roomRepo                     repository.RoomRepository
+	// nil の場合は満員のルームへの参加を待たせずに拒否する
+	inMemoryWaitingQueueRepo repository.InMemoryWaitingQueueRepository
+	roomChangeNotifier       RoomChangeNotifier
+	sessionGracePeriod       time.Duration
+	// nil の場合は move を受け取るたびにルーム全員に送る
+	gameLoop *GameLoop
+	// ミニゲームも位置をここから読むため、gameLoop が無い場合も位置は記録する
+	positions *positionTrackers
+	// nil の場合は move のたびに DB に書き込む
+	positionWriter *PositionWriter
+	// nil の場合は SFU を使うルームタイプの音声に参加できない
+	sfu               *sfu.SFU
+	chatUsecase       *ChatUsecase
+	banRepo           repository.BanRepository
+	roomAccessChecker *RoomAccessChecker
+	lifecycles        *roomLifecycles
+	lifecycleOptions  RoomLifecycleOptions
+	minigames         *minigameRunner
 }
 
-func NewUserGameLocationUsecase(userGameLocationRepo repository.UserGameLocationRepository, inMemoryUserGameLocationRepo repository.InMemoryUserGameLocationRepository) *UserGameLocationUsecase {
-	return &UserGameLocationUsecase{userGameLocationRepo: userGameLocationRepo, inMemoryUserGameLocationRepo: inMemoryUserGameLocationRepo}
+func NewUserGameLocationUsecase(userGameLocationRepo repository.UserGameLocationRepository, inMemoryUserGameLocationRepo repository.InMemoryUserGameLocationRepository, inMemoryGameSessionRepo repository.InMemoryGameSessionRepository, roomRepo repository.RoomRepository, inMemoryWaitingQueueRepo repository.InMemoryWaitingQueueRepository, roomChangeNotifier RoomChangeNotifier, sessionGracePeriod time.Duration, tickRate int, positionWriter *PositionWriter, sfu *sfu.SFU, chatUsecase *ChatUsecase, banRepo repository.BanRepository, roomAccessChecker *RoomAccessChecker, lifecycleOptions RoomLifecycleOptions, minigames *minigame.Registry, minigameTickRate int) *UserGameLocationUsecase {
+	ugc := &UserGameLocationUsecase{userGameLocationRepo: userGameLocationRepo, inMemoryUserGameLocationRepo: inMemoryUserGameLocationRepo, inMemoryGameSessionRepo: inMemoryGameSessionRepo, roomRepo: roomRepo, inMemoryWaitingQueueRepo: inMemoryWaitingQueueRepo, roomChangeNotifier: roomChangeNotifier, sessionGracePeriod: sessionGracePeriod, positionWriter: positionWriter, sfu: sfu, chatUsecase: chatUsecase, banRepo: banRepo, roomAccessChecker: roomAccessChecker, lifecycles: newRoomLifecycles(), lifecycleOptions: lifecycleOptions, minigames: newMinigameRunner(minigames, minigameTickRate), positions: newPositionTrackers()}
+	if tickRate > 0 {
+		ugc.gameLoop = NewGameLoop(tickRate, ugc.broadcastState)
+	}
+	return ugc
 }
 
 func (ugc *UserGameLocationUsecase) ConnectUserGameLocation(userGameLocation *model.UserGameLocation) error {
 	if userGameLocation.RoomID == 0 {
-		return fmt.Errorf("userGameLocation.RoomID is nil")
+		return fmt.Errorf("%w: userGameLocation.RoomID is nil", ErrInvalidArgument)
+	}
+
+	room, exists, err := ugc.roomRepo.GetRoom(userGameLocation.RoomID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: room %d does not exist", ErrInvalidArgument, userGameLocation.RoomID)
+	}
+	err = checkBan(ugc.banRepo, model.BanKindBan, userGameLocation.UserID, room.AreaID, room.ID)
+	if err != nil {
+		return err
+	}
+	// join-game で確認済みでなければ、誰でも参加できるルームか所有者・管理者の場合だけ参加できる
+	_, err = ugc.admit(userGameLocation, room, RoomAccess{})
+	if err != nil {
+		return err
+	}
+	err = ugc.checkRoomAcceptsPlayer(room, userGameLocation.UserID)
+	if err != nil {
+		return err
+	}
+
+	// 定員の確認とインメモリへの登録は同時に行わないと、同時に参加した場合に定員を超えてしまう
+	if !ugc.inMemoryUserGameLocationRepo.StoreIfRoomNotFull(userGameLocation, room.RoomType.MaxParticipant) {
+		return fmt.Errorf("%w: room %d has reached its capacity of %d", ErrRoomFull, room.ID, room.RoomType.MaxParticipant)
+	}
+	if previousRoomID, moved := ugc.lifecycles.Join(userGameLocation.UserID, room.ID); moved {
+		go ugc.onRoomMemberLeft(previousRoomID, userGameLocation.UserID)
 	}
 
 	// UserGameLocationが存在しない場合は新規作成
-	_, exists, err := ugc.userGameLocationRepo.GetUserGameLocation(userGameLocation.UserID)
+	_, exists, err = ugc.userGameLocationRepo.GetUserGameLocation(userGameLocation.UserID)
 	if err != nil {
 		ugc.DisconnectUserGameLocation(userGameLocation)
 		log.Println("failed to get userGameLocation")
@@ -41,29 +101,138 @@ func (ugc *UserGameLocationUsecase) ConnectUserGameLocation(userGameLocation *mo
 		}
 	}
 
-	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(userGameLocation.RoomID)
-
-	for _, otherUserGameLocation := range connectedUserGameLocations {
-		if otherUserGameLocation.RoomID == userGameLocation.RoomID && otherUserGameLocation.UserID == userGameLocation.UserID {
-			return nil
-		}
-	}
 	err = ugc.userGameLocationRepo.UpdateUserGameLocation(userGameLocation)
 	if err != nil {
 		ugc.DisconnectUserGameLocation(userGameLocation)
 		return err
 	}
-	ugc.inMemoryUserGameLocationRepo.Store(userGameLocation)
+	userGameLocation.MovementRule = room.RoomType.MovementRule.Indexed()
+	userGameLocation.MovementState.Reset(&userGameLocation.MovementRule, time.Now())
+	ugc.submitPosition(userGameLocation)
+	if ugc.gameLoop != nil {
+		// 参加直後の state はキーフレームにする
+		ugc.positions.ResetAck(userGameLocation.UserID)
+	}
+	ugc.roomChangeNotifier.NotifyRoomChanged(userGameLocation.RoomID)
 
 	return nil
 }
 
 func (ugc *UserGameLocationUsecase) DisconnectUserGameLocation(userGameLocation *model.UserGameLocation) error {
+	stored, ok := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID)
 	ugc.inMemoryUserGameLocationRepo.Delete(userGameLocation.UserID)
+	leaveVoice(ugc.sfu, userGameLocation.UserID)
+	if ok {
+		ugc.roomChangeNotifier.NotifyRoomChanged(stored.RoomID)
+	}
+	if ok && ugc.lifecycles.Leave(userGameLocation.UserID, stored.RoomID) {
+		go ugc.onRoomMemberLeft(stored.RoomID, userGameLocation.UserID)
+	}
+	if ok {
+		_, removed := ugc.positions.Remove(userGameLocation.UserID)
+		switch {
+		case ugc.gameLoop == nil:
+			ugc.positions.Drop(stored.RoomID)
+		case removed:
+			// 退出したことを次の tick の差分で知らせる
+			ugc.gameLoop.Wake(stored.RoomID)
+		}
+	}
+	if ok && ugc.inMemoryWaitingQueueRepo != nil {
+		// 空いた枠に待っているユーザーを入れる。呼び出し元のロックや送信処理と絡まないよう別のゴルーチンで行う
+		go ugc.admitWaiting(stored.RoomID)
+	}
 
 	return nil
 }
 
+// ルームに参加して参加イベントの送信と再接続用のトークンの発行まで行う
+// 満員で待ち行列が有効な場合は待ち行列に並べ、空きができた時点で参加させる
+func (ugc *UserGameLocationUsecase) JoinGame(userGameLocation *model.UserGameLocation, access RoomAccess) error {
+	if ugc.inMemoryWaitingQueueRepo != nil {
+		ugc.inMemoryWaitingQueueRepo.Remove(userGameLocation.UserID)
+	}
+	room, exists, err := ugc.roomRepo.GetRoom(userGameLocation.RoomID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: room %d does not exist", ErrInvalidArgument, userGameLocation.RoomID)
+	}
+	previousAdmittedRoomID := userGameLocation.AdmittedRoomID
+	invite, err := ugc.admit(userGameLocation, room, access)
+	if err != nil {
+		return err
+	}
+	err = ugc.joinGame(userGameLocation, nil)
+	if err == nil {
+		return nil
+	}
+	// 別のルームに参加中のユーザーは退出してから並んでもらう
+	_, joined := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID)
+	if !(errors.Is(err, ErrRoomFull) || errors.Is(err, ErrRoomBusy)) || ugc.inMemoryWaitingQueueRepo == nil || joined {
+		// 参加できなかったので招待の使用回数を戻す
+		userGameLocation.AdmittedRoomID = previousAdmittedRoomID
+		ugc.roomAccessChecker.Release(invite)
+		return err
+	}
+	position := ugc.inMemoryWaitingQueueRepo.Enqueue(userGameLocation.RoomID, userGameLocation)
+	waitingMsg := &protocol.Waiting{
+		Type:     protocol.TypeWaiting,
+		RoomID:   userGameLocation.RoomID,
+		Position: position,
+	}
+	return userGameLocation.Connection().Send(waitingMsg)
+}
+
+// onConnected はルームに登録した直後、参加イベントを送る前に呼ぶ。nil の場合は何もしない
+func (ugc *UserGameLocationUsecase) joinGame(userGameLocation *model.UserGameLocation, onConnected func()) error {
+	err := ugc.ConnectUserGameLocation(userGameLocation)
+	if err != nil {
+		return err
+	}
+	if onConnected != nil {
+		onConnected()
+	}
+	err = ugc.SendGameJoinedEvent(userGameLocation)
+	if err != nil {
+		return err
+	}
+	ugc.sendRoomState(userGameLocation)
+	ugc.joinMinigame(userGameLocation)
+	ugc.sendRoomChatHistory(userGameLocation)
+	return ugc.IssueSession(userGameLocation)
+}
+
+// 待ち行列の先頭から順に参加させる。接続が切れているユーザーは飛ばす
+func (ugc *UserGameLocationUsecase) admitWaiting(roomID uint) {
+	for {
+		next, ok := ugc.inMemoryWaitingQueueRepo.Dequeue(roomID)
+		if !ok {
+			return
+		}
+		if next.Connection().Closed() || next.RoomID != roomID {
+			continue
+		}
+		err := ugc.joinGame(next, nil)
+		if errors.Is(err, ErrRoomFull) || errors.Is(err, ErrRoomBusy) {
+			// 他のユーザーが先に参加した場合やゲーム中の場合は先頭に戻して次の空きを待つ
+			ugc.inMemoryWaitingQueueRepo.PushFront(roomID, next)
+			return
+		}
+		if err != nil {
+			log.Printf("Error admitting waiting user %d: %v", next.UserID, err)
+			errorMsg := protocol.NewError(protocol.CodeInternal, protocol.TypeJoinGame, "internal server error")
+			if sendErr := next.Connection().Send(errorMsg); sendErr != nil {
+				log.Printf("Error sending message to client: %v", sendErr)
+			}
+			continue
+		}
+		log.Printf("admitted waiting user %d to room %d", next.UserID, roomID)
+		return
+	}
+}
+
 func (ugc *UserGameLocationUsecase) SendGameJoinedEvent(userGameLocation *model.UserGameLocation) error {
 	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(userGameLocation.RoomID)
 	connectedUserIds := []uint{}
@@ -74,49 +243,66 @@ func (ugc *UserGameLocationUsecase) SendGameJoinedEvent(userGameLocation *model.
 	if err != nil {
 		return fmt.Errorf("failed to get serialized connected user locations: %w", err)
 	}
-	roomJoinedMsg := map[string]interface{}{
-		"type":              "join-game",
-		"connectedUserIds":  connectedUserIds,
-		"fromUserID":        userGameLocation.UserID,
-		"xAxis":             userGameLocation.XAxis,
-		"yAxis":             userGameLocation.YAxis,
-		"roomID":            userGameLocation.RoomID,
-		"userGameLocations": userLocations,
+	roomJoinedMsg := &protocol.GameJoined{
+		Type:              protocol.TypeJoinGame,
+		FromUserID:        userGameLocation.UserID,
+		RoomID:            userGameLocation.RoomID,
+		XAxis:             userGameLocation.XAxis,
+		YAxis:             userGameLocation.YAxis,
+		ConnectedUserIDs:  connectedUserIds,
+		UserGameLocations: userLocations,
 	}
 	msg := model.NewMessage(roomJoinedMsg)
 	return ugc.SendMessageToSameRoom(userGameLocation, msg)
 }
 
 func (ugc *UserGameLocationUsecase) SendAudioJoinedEvent(userGameLocation *model.UserGameLocation) error {
+	room, exists, err := ugc.roomRepo.GetRoom(userGameLocation.RoomID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: room %d does not exist", ErrInvalidArgument, userGameLocation.RoomID)
+	}
+	err = checkBan(ugc.banRepo, model.BanKindMuteAudio, userGameLocation.UserID, room.AreaID, room.ID)
+	if err != nil {
+		return err
+	}
+	voiceMode, err := joinVoice(ugc.sfu, room, userGameLocation.UserID, userGameLocationSender{userGameLocation: userGameLocation})
+	if err != nil {
+		return err
+	}
 	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(userGameLocation.RoomID)
 	connectedUserIds := []uint{}
 	for _, otherUserGameLocation := range connectedUserGameLocations {
 		connectedUserIds = append(connectedUserIds, otherUserGameLocation.UserID)
 	}
-	roomJoinedMsg := map[string]interface{}{
-		"type":             "join-audio",
-		"connectedUserIds": connectedUserIds,
-		"fromUserID":       userGameLocation.UserID,
-		"roomID":           userGameLocation.RoomID,
+	roomJoinedMsg := &protocol.AudioJoined{
+		Type:             protocol.TypeJoinAudio,
+		FromUserID:       userGameLocation.UserID,
+		RoomID:           userGameLocation.RoomID,
+		ConnectedUserIDs: connectedUserIds,
+		VoiceMode:        voiceMode,
 	}
 	msg := model.NewMessage(roomJoinedMsg)
-	return ugc.SendMessageToSameRoomWithoutMe(userGameLocation, msg)
+	err = ugc.SendMessageToSameRoomWithoutMe(userGameLocation, msg)
+	if err != nil {
+		return err
+	}
+	ugc.sendRoomChatHistory(userGameLocation)
+	return nil
 }
 
 func (ugc *UserGameLocationUsecase) SendMessageToSameRoomWithoutMe(userGameLocation *model.UserGameLocation, msg *model.Message) error {
 	msgPayload := msg.Payload
-	msgPayload["fromUserID"] = userGameLocation.UserID
-	msgPayload["roomID"] = userGameLocation.RoomID
 	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(userGameLocation.RoomID)
 	for _, otherClient := range connectedUserGameLocations {
 		if otherClient.UserID != userGameLocation.UserID {
-			otherClient.Mutex.Lock()
-			defer otherClient.Mutex.Unlock()
-			err := otherClient.Conn.WriteJSON(msgPayload)
+			// キューに積むだけなので遅いクライアントがいても他のクライアントへの送信は止まらない
+			err := ugc.deliver(otherClient, msgPayload)
 			if err != nil {
 				log.Printf("Error sending message to client: %v", err)
 				ugc.DisconnectUserGameLocation(otherClient)
-				return err
 			}
 		}
 	}
@@ -125,17 +311,12 @@ func (ugc *UserGameLocationUsecase) SendMessageToSameRoomWithoutMe(userGameLocat
 
 func (ugc *UserGameLocationUsecase) SendMessageToSameRoom(userGameLocation *model.UserGameLocation, msg *model.Message) error {
 	msgPayload := msg.Payload
-	msgPayload["fromUserID"] = userGameLocation.UserID
-	msgPayload["roomID"] = userGameLocation.RoomID
 	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(userGameLocation.RoomID)
 	for _, otherClient := range connectedUserGameLocations {
-		otherClient.Mutex.Lock()
-		defer otherClient.Mutex.Unlock()
-		err := otherClient.Conn.WriteJSON(msgPayload)
+		err := ugc.deliver(otherClient, msgPayload)
 		if err != nil {
 			log.Printf("Error sending message to client: %v", err)
 			ugc.DisconnectUserGameLocation(otherClient)
-			return err
 		}
 
 	}
@@ -144,18 +325,13 @@ func (ugc *UserGameLocationUsecase) SendMessageToSameRoom(userGameLocation *mode
 
 func (ugc *UserGameLocationUsecase) SendMessageToSpecificUser(userGameLocation *model.UserGameLocation, msg *model.Message, targetUserID uint) error {
 	msgPayload := msg.Payload
-	msgPayload["fromUserID"] = userGameLocation.UserID
-	msgPayload["roomID"] = userGameLocation.RoomID
-	msgPayload["toUserID"] = targetUserID
 
 	targetUserGameLocation, ok := ugc.inMemoryUserGameLocationRepo.Find(targetUserID)
 	if !ok {
-		return fmt.Errorf("target user location not found for UserID: %d", targetUserID)
+		return fmt.Errorf("%w: target user location not found for UserID: %d", ErrNotInRoom, targetUserID)
 	}
 
-	targetUserGameLocation.Mutex.Lock()
-	defer targetUserGameLocation.Mutex.Unlock()
-	err := targetUserGameLocation.Conn.WriteJSON(msgPayload)
+	err := ugc.deliver(targetUserGameLocation, msgPayload)
 	if err != nil {
 		log.Printf("Error sending message to client: %v", err)
 		ugc.DisconnectUserGameLocation(targetUserGameLocation)
@@ -166,22 +342,50 @@ func (ugc *UserGameLocationUsecase) SendMessageToSpecificUser(userGameLocation *
 }
 
 func (ugc *UserGameLocationUsecase) MoveInGame(userGameLocation *model.UserGameLocation, xAxis int, yAxis int) error {
-	userGameLocation.XAxis = xAxis
-	userGameLocation.YAxis = yAxis
-	err := ugc.userGameLocationRepo.UpdateUserGameLocation(userGameLocation)
-	if err != nil {
-		return err
+	if _, ok := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); !ok {
+		return fmt.Errorf("%w: user %d has not joined room %d", ErrNotInRoom, userGameLocation.UserID, userGameLocation.RoomID)
+	}
+	x, y, reason := userGameLocation.MovementRule.Apply(&userGameLocation.MovementState, userGameLocation.XAxis, userGameLocation.YAxis, xAxis, yAxis, time.Now())
+	if reason != "" {
+		correctionMsg := &protocol.PositionCorrection{
+			Type:   protocol.TypePositionCorrection,
+			RoomID: userGameLocation.RoomID,
+			XAxis:  x,
+			YAxis:  y,
+			Reason: reason,
+		}
+		if err := userGameLocation.Connection().Send(correctionMsg); err != nil {
+			return err
+		}
+		if x == userGameLocation.XAxis && y == userGameLocation.YAxis {
+			// 動けなかった場合は他のユーザーに送る必要はない
+			return nil
+		}
+	}
+	userGameLocation.XAxis = x
+	userGameLocation.YAxis = y
+	if ugc.positionWriter != nil {
+		ugc.positionWriter.SaveUserGameLocation(userGameLocation)
+	} else {
+		err := ugc.userGameLocationRepo.UpdateUserGameLocation(userGameLocation)
+		if err != nil {
+			return err
+		}
 	}
 	ugc.inMemoryUserGameLocationRepo.Store(userGameLocation)
+	ugc.submitPosition(userGameLocation)
+	if ugc.gameLoop != nil {
+		return nil
+	}
 	userGameLocations, err := ugc.GetSerializedConnectedUserGameLocations(userGameLocation.RoomID)
 	if err != nil {
 		return err
 	}
-	moveMsg := map[string]interface{}{
-		"type":              "move",
-		"fromUserID":        userGameLocation.UserID,
-		"roomID":            userGameLocation.RoomID,
-		"userGameLocations": userGameLocations,
+	moveMsg := &protocol.GameMoved{
+		Type:              protocol.TypeMove,
+		FromUserID:        userGameLocation.UserID,
+		RoomID:            userGameLocation.RoomID,
+		UserGameLocations: userGameLocations,
 	}
 	msg := model.NewMessage(moveMsg)
 	err = ugc.SendMessageToSameRoom(userGameLocation, msg)
@@ -192,25 +396,14 @@ func (ugc *UserGameLocationUsecase) MoveInGame(userGameLocation *model.UserGameL
 }
 
 func (ugc *UserGameLocationUsecase) LeaveInGame(userGameLocation *model.UserGameLocation, roomID uint) error {
-	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(roomID)
-	for _, otherClient := range connectedUserGameLocations {
-		if otherClient.UserID != userGameLocation.UserID {
-			leaveMsg := map[string]interface{}{
-				"type":       "leave-game",
-				"roomID":     roomID,
-				"fromUserID": userGameLocation.UserID,
-				"toUserID":   otherClient.UserID,
-			}
-			msg := model.NewMessage(leaveMsg)
-			err := ugc.SendMessageToSpecificUser(userGameLocation, msg, otherClient.UserID)
-			if err != nil {
-				log.Printf("Error sending message to client: %v", err)
-				return err
-			}
-
-		}
+	if ugc.inMemoryWaitingQueueRepo != nil && ugc.inMemoryWaitingQueueRepo.Remove(userGameLocation.UserID) {
+		return nil
+	}
+	if _, ok := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); !ok {
+		return fmt.Errorf("%w: user %d has not joined room %d", ErrNotInRoom, userGameLocation.UserID, roomID)
 	}
-	err := ugc.userGameLocationRepo.UpdateUserGameLocation(userGameLocation)
+	ugc.notifyLeft(userGameLocation, roomID, protocol.TypeLeaveGame)
+	err := ugc.flushPosition(userGameLocation)
 	if err != nil {
 		return err
 	}
@@ -218,6 +411,8 @@ func (ugc *UserGameLocationUsecase) LeaveInGame(userGameLocation *model.UserGame
 	if err != nil {
 		return err
 	}
+	ugc.handOverOwnership(userGameLocation.UserID, roomID)
+	ugc.closeSession(userGameLocation.UserID)
 	err = ugc.userGameLocationRepo.RemoveUserGameLocation(userGameLocation.UserID)
 	if err != nil {
 		return err
@@ -227,45 +422,14 @@ func (ugc *UserGameLocationUsecase) LeaveInGame(userGameLocation *model.UserGame
 }
 
 func (ugc *UserGameLocationUsecase) LeaveInAudio(userGameLocationUsecase *model.UserGameLocation, roomID uint) error {
-	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(roomID)
-	for _, otherClient := range connectedUserGameLocations {
-		if otherClient.UserID != userGameLocationUsecase.UserID {
-			leaveMsg := map[string]interface{}{
-				"type":       "leave-audio",
-				"roomID":     roomID,
-				"fromUserID": userGameLocationUsecase.UserID,
-				"toUserID":   otherClient.UserID,
-			}
-			msg := model.NewMessage(leaveMsg)
-			err := ugc.SendMessageToSpecificUser(userGameLocationUsecase, msg, otherClient.UserID)
-			if err != nil {
-				log.Printf("Error sending message to client: %v", err)
-				return err
-			}
-
-		}
-	}
+	leaveVoice(ugc.sfu, userGameLocationUsecase.UserID)
+	ugc.notifyLeft(userGameLocationUsecase, roomID, protocol.TypeLeaveAudio)
 	return nil
 }
 
 func (ugc *UserGameLocationUsecase) DisconnectInGame(userGameLocation *model.UserGameLocation, roomID uint) error {
-	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(roomID)
-	for _, otherClient := range connectedUserGameLocations {
-		if otherClient.UserID != userGameLocation.UserID {
-			leaveMsg := map[string]interface{}{
-				"type":       "disconnect-game",
-				"roomID":     roomID,
-				"fromUserID": userGameLocation.UserID,
-				"toUserID":   otherClient.UserID,
-			}
-			msg := model.NewMessage(leaveMsg)
-			err := ugc.SendMessageToSpecificUser(userGameLocation, msg, otherClient.UserID)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	err := ugc.userGameLocationRepo.UpdateUserGameLocation(userGameLocation)
+	ugc.notifyLeft(userGameLocation, roomID, protocol.TypeDisconnectGame)
+	err := ugc.flushPosition(userGameLocation)
 	if err != nil {
 		return err
 	}
@@ -274,6 +438,8 @@ func (ugc *UserGameLocationUsecase) DisconnectInGame(userGameLocation *model.Use
 
 		return err
 	}
+	ugc.handOverOwnership(userGameLocation.UserID, roomID)
+	ugc.closeSession(userGameLocation.UserID)
 	err = ugc.userGameLocationRepo.RemoveUserGameLocation(userGameLocation.UserID)
 	if err != nil {
 		return err
@@ -283,7 +449,7 @@ func (ugc *UserGameLocationUsecase) DisconnectInGame(userGameLocation *model.Use
 
 func (ugc *UserGameLocationUsecase) DisconnectInAudio(userGameLocation *model.UserGameLocation, roomID uint) error {
 
-	err := ugc.userGameLocationRepo.UpdateUserGameLocation(userGameLocation)
+	err := ugc.flushPosition(userGameLocation)
 	if err != nil {
 		return err
 	}
@@ -292,10 +458,10 @@ func (ugc *UserGameLocationUsecase) DisconnectInAudio(userGameLocation *model.Us
 
 		return err
 	}
-	disconnectMsg := map[string]interface{}{
-		"type":       "disconnect-audio",
-		"roomID":     roomID,
-		"fromUserID": userGameLocation.UserID,
+	disconnectMsg := &protocol.AudioDisconnected{
+		Type:       protocol.TypeDisconnectAudio,
+		FromUserID: userGameLocation.UserID,
+		RoomID:     roomID,
 	}
 	msg := model.NewMessage(disconnectMsg)
 	err = ugc.SendMessageToSameRoom(userGameLocation, msg)
@@ -305,47 +471,306 @@ func (ugc *UserGameLocationUsecase) DisconnectInAudio(userGameLocation *model.Us
 	return nil
 }
 
-func (ugc *UserGameLocationUsecase) GetSerializedConnectedUserGameLocations(roomID uint) ([]map[string]interface{}, error) {
+// 退出や切断をルームの他のユーザーに知らせる
+// 送信元が既にインメモリから消えていても送れるよう、同じルームかどうかの確認はせずに直接送る
+func (ugc *UserGameLocationUsecase) notifyLeft(userGameLocation *model.UserGameLocation, roomID uint, msgType string) {
 	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(roomID)
-	userGameLocations := []map[string]interface{}{}
-	for _, otherUserGameLocation := range connectedUserGameLocations {
-		userGameLocation, exists, err := ugc.userGameLocationRepo.GetUserGameLocation(otherUserGameLocation.UserID)
-
-		if err != nil {
-			ugc.DisconnectUserGameLocation(userGameLocation)
-			return nil, err
+	for _, otherClient := range connectedUserGameLocations {
+		if otherClient.UserID == userGameLocation.UserID {
+			continue
 		}
-		if !exists {
-			log.Printf("user game location does not exist for user ID: %d", otherUserGameLocation.UserID)
-			err := ugc.userGameLocationRepo.AddUserGameLocation(userGameLocation)
-			if err != nil {
-				ugc.DisconnectUserGameLocation(userGameLocation)
-				return nil, fmt.Errorf("failed to add user location: %w", err)
-			}
+		leaveMsg := &protocol.GameLeft{
+			Type:       msgType,
+			FromUserID: userGameLocation.UserID,
+			ToUserID:   otherClient.UserID,
+			RoomID:     roomID,
 		}
-		userGameLocationJSON, err := userGameLocation.MarshalJSON()
+		err := ugc.deliver(otherClient, leaveMsg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal user location from JSON: %w", err)
+			log.Printf("Error sending message to client: %v", err)
+			ugc.DisconnectUserGameLocation(otherClient)
+		}
+	}
+}
+
+// インメモリの状態だけから組み立てる。move のたびに呼ばれるため DB は読まない
+func (ugc *UserGameLocationUsecase) GetSerializedConnectedUserGameLocations(roomID uint) ([]protocol.UserGameLocation, error) {
+	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(roomID)
+	userGameLocations := make([]protocol.UserGameLocation, 0, len(connectedUserGameLocations))
+	for _, userGameLocation := range connectedUserGameLocations {
+		userGameLocations = append(userGameLocations, protocol.UserGameLocation{
+			UserID: userGameLocation.UserID,
+			RoomID: userGameLocation.RoomID,
+			XAxis:  userGameLocation.XAxis,
+			YAxis:  userGameLocation.YAxis,
+		})
+	}
+	return userGameLocations, nil
+}
+
+// 退出時に呼び、まだ書き込んでいない位置も含めて現在の状態を DB に書き込む
+func (ugc *UserGameLocationUsecase) flushPosition(userGameLocation *model.UserGameLocation) error {
+	if ugc.positionWriter != nil {
+		return ugc.positionWriter.FlushUserGameLocation(userGameLocation)
+	}
+	return ugc.userGameLocationRepo.UpdateUserGameLocation(userGameLocation)
+}
+
+// 次の tick で配信する位置を更新する。同じ tick 内の古い位置は上書きされる
+func (ugc *UserGameLocationUsecase) submitPosition(userGameLocation *model.UserGameLocation) {
+	ugc.positions.Set(userGameLocation.RoomID, protocol.PlayerPosition{
+		UserID: userGameLocation.UserID,
+		XAxis:  userGameLocation.XAxis,
+		YAxis:  userGameLocation.YAxis,
+	})
+	if ugc.gameLoop != nil {
+		ugc.gameLoop.Wake(userGameLocation.RoomID)
+	}
+}
+
+// ゲームループの tick ごとに、各クライアントが ack したスナップショットからの差分を送る
+func (ugc *UserGameLocationUsecase) broadcastState(roomID uint) bool {
+	connectedUserGameLocations := ugc.inMemoryUserGameLocationRepo.GetAllUserGameLocationsByRoomId(roomID)
+	userIDs := make([]uint, 0, len(connectedUserGameLocations))
+	for _, userGameLocation := range connectedUserGameLocations {
+		userIDs = append(userIDs, userGameLocation.UserID)
+	}
+	states, changed := ugc.positions.Commit(roomID, userIDs, nil)
+	if len(connectedUserGameLocations) == 0 {
+		ugc.positions.Drop(roomID)
+		return false
+	}
+	if !changed {
+		return true
+	}
+	for _, otherClient := range connectedUserGameLocations {
+		stateMsg := states[otherClient.UserID]
+		stateMsg.RoomID = roomID
+		err := ugc.deliver(otherClient, &stateMsg)
+		if err != nil {
+			log.Printf("Error sending message to client: %v", err)
+			ugc.DisconnectUserGameLocation(otherClient)
+		}
+	}
+	return true
+}
+
+// クライアントが受け取った state の seq を記録する。以降はこの seq からの差分を送る
+func (ugc *UserGameLocationUsecase) AckState(userGameLocation *model.UserGameLocation, seq uint64) error {
+	if _, ok := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); !ok {
+		return fmt.Errorf("%w: user %d has not joined room %d", ErrNotInRoom, userGameLocation.UserID, userGameLocation.RoomID)
+	}
+	if ugc.gameLoop != nil {
+		ugc.positions.Ack(userGameLocation.UserID, seq)
+	}
+	return nil
+}
+
+// ルーム全員の位置をキーフレームとしてすぐに送る
+func (ugc *UserGameLocationUsecase) SendKeyframe(userGameLocation *model.UserGameLocation) error {
+	if _, ok := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); !ok {
+		return fmt.Errorf("%w: user %d has not joined room %d", ErrNotInRoom, userGameLocation.UserID, userGameLocation.RoomID)
+	}
+	if ugc.gameLoop == nil {
+		return fmt.Errorf("%w: state updates are disabled", ErrInvalidArgument)
+	}
+	stateMsg, ok := ugc.positions.Keyframe(userGameLocation.UserID)
+	if !ok {
+		return fmt.Errorf("%w: user %d has not joined room %d", ErrNotInRoom, userGameLocation.UserID, userGameLocation.RoomID)
+	}
+	stateMsg.RoomID = userGameLocation.RoomID
+	return ugc.deliver(userGameLocation, &stateMsg)
+}
 
+// offer / answer / ice-candidate を送信元を付けて特定のユーザーに転送する
+func (ugc *UserGameLocationUsecase) ForwardSignal(userGameLocation *model.UserGameLocation, signal *protocol.Signal) error {
+	if signal.Type == protocol.TypeOffer || signal.Type == protocol.TypeAnswer {
+		areaID, err := ugc.areaOfRoom(userGameLocation.RoomID)
+		if err != nil {
+			return err
 		}
-		var userGameLocationMap map[string]interface{}
-		err = json.Unmarshal(userGameLocationJSON, &userGameLocationMap)
+		err = checkSignalBan(ugc.banRepo, signal, userGameLocation.UserID, areaID, userGameLocation.RoomID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal user location from JSON: %w", err)
+			return err
 		}
-		userGameLocations = append(userGameLocations, userGameLocationMap)
 	}
-	return userGameLocations, nil
+	if signal.Target == protocol.SignalTargetServer {
+		return handleServerSignal(ugc.sfu, userGameLocation.UserID, signal)
+	}
+	// 同じルームにいるユーザーにしか送れない
+	target, ok := ugc.inMemoryUserGameLocationRepo.Find(signal.ToUserID)
+	if _, joined := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID); !ok || !joined || userGameLocation.RoomID == 0 || userGameLocation.RoomID != target.RoomID {
+		return fmt.Errorf("%w: user %d is not in the same room as user %d", ErrNotInRoom, signal.ToUserID, userGameLocation.UserID)
+	}
+	forwardMsg := &protocol.SignalForward{
+		Signal:     signal,
+		FromUserID: userGameLocation.UserID,
+		ToUserID:   signal.ToUserID,
+		RoomID:     userGameLocation.RoomID,
+	}
+	msg := model.NewMessage(forwardMsg)
+	return ugc.SendMessageToSpecificUser(userGameLocation, msg, signal.ToUserID)
 }
 
 func (ugc *UserGameLocationUsecase) PingUserGameLocation(userGameLocation *model.UserGameLocation) error {
-	pongMsg := map[string]interface{}{
-		"type": "pong",
+	pongMsg := &protocol.Pong{
+		Type:       protocol.TypePong,
+		FromUserID: userGameLocation.UserID,
+		ToUserID:   userGameLocation.UserID,
+		RoomID:     userGameLocation.RoomID,
+	}
+	// 参加前の接続からも ping が届くため、インメモリのリポジトリを経由せずに返す
+	return userGameLocation.Connection().Send(pongMsg)
+}
+
+// 切断中のユーザー宛てのイベントはセッションに溜め、再接続時にまとめて送る
+func (ugc *UserGameLocationUsecase) deliver(userGameLocation *model.UserGameLocation, msg interface{}) error {
+	if session, ok := ugc.inMemoryGameSessionRepo.FindByUserID(userGameLocation.UserID); ok && session.Buffer(msg) {
+		return nil
 	}
-	msg := model.NewMessage(pongMsg)
-	err := ugc.SendMessageToSpecificUser(userGameLocation, msg, userGameLocation.UserID)
+	return userGameLocation.Connection().Send(msg)
+}
+
+// join-game に成功したユーザーに再接続用のトークンを発行する
+func (ugc *UserGameLocationUsecase) IssueSession(userGameLocation *model.UserGameLocation) error {
+	if ugc.sessionGracePeriod <= 0 {
+		return nil
+	}
+	token, err := newResumeToken()
 	if err != nil {
 		return err
 	}
-	return nil
+	ugc.closeSession(userGameLocation.UserID)
+	ugc.inMemoryGameSessionRepo.Store(model.NewGameSession(token, userGameLocation.UserID, userGameLocation.RoomID))
+
+	sessionMsg := &protocol.SessionIssued{
+		Type:         protocol.TypeSession,
+		RoomID:       userGameLocation.RoomID,
+		ResumeToken:  token,
+		GraceSeconds: int(ugc.sessionGracePeriod / time.Second),
+	}
+	return userGameLocation.Connection().Send(sessionMsg)
+}
+
+// 接続が切れた時の処理。セッションがあれば猶予期間の間は位置を確保したまま再接続を待つ
+func (ugc *UserGameLocationUsecase) HandleConnectionLost(userGameLocation *model.UserGameLocation, conn *wsconn.Conn) {
+	if userGameLocation.Connection() != conn {
+		// 既に別の接続で再開済み
+		return
+	}
+	if ugc.inMemoryWaitingQueueRepo != nil && ugc.inMemoryWaitingQueueRepo.Remove(userGameLocation.UserID) {
+		// 待ち行列に並んでいただけでルームには参加していない
+		return
+	}
+	stored, joined := ugc.inMemoryUserGameLocationRepo.Find(userGameLocation.UserID)
+	if joined && stored != userGameLocation {
+		// 同じユーザーの別の接続がルームに参加している
+		return
+	}
+	session, ok := ugc.inMemoryGameSessionRepo.FindByUserID(userGameLocation.UserID)
+	if !joined || !ok {
+		ugc.disconnectAll(userGameLocation)
+		return
+	}
+
+	userGameLocation.Status = model.UserGameLocationStatusReconnecting
+	ugc.sendPlayerStatus(userGameLocation)
+	session.Suspend(ugc.sessionGracePeriod, func() {
+		log.Printf("resume grace period expired for user: %d", userGameLocation.UserID)
+		ugc.inMemoryGameSessionRepo.Delete(session.Token)
+		ugc.disconnectAll(userGameLocation)
+	})
+}
+
+// resumeToken を検証し、新しい接続を既存の UserGameLocation に結び付ける
+func (ugc *UserGameLocationUsecase) ResumeUserGameLocation(userID uint, token string, conn *wsconn.Conn) (*model.UserGameLocation, error) {
+	session, ok := ugc.inMemoryGameSessionRepo.FindByToken(token)
+	if !ok {
+		return nil, fmt.Errorf("%w: resume token is invalid or expired", ErrNotInRoom)
+	}
+	if session.UserID != userID {
+		return nil, fmt.Errorf("%w: resume token belongs to another user", ErrUnauthorized)
+	}
+	userGameLocation, ok := ugc.inMemoryUserGameLocationRepo.Find(userID)
+	if !ok || userGameLocation.RoomID != session.RoomID {
+		return nil, fmt.Errorf("%w: resume token is invalid or expired", ErrNotInRoom)
+	}
+	missed, ok := session.Resume()
+	if !ok {
+		return nil, fmt.Errorf("%w: resume token is invalid or expired", ErrNotInRoom)
+	}
+
+	if old := userGameLocation.Rebind(conn); old != nil && old != conn {
+		// 切断を検知する前に再接続してきた場合は古い接続を閉じる
+		old.Close()
+	}
+	userGameLocation.Status = model.UserGameLocationStatusConnected
+
+	userGameLocations, err := ugc.GetSerializedConnectedUserGameLocations(userGameLocation.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	resumedMsg := &protocol.Resumed{
+		Type:              protocol.TypeResumed,
+		FromUserID:        userGameLocation.UserID,
+		RoomID:            userGameLocation.RoomID,
+		XAxis:             userGameLocation.XAxis,
+		YAxis:             userGameLocation.YAxis,
+		UserGameLocations: userGameLocations,
+	}
+	if err := conn.Send(resumedMsg); err != nil {
+		return nil, err
+	}
+	for _, msg := range missed {
+		if err := conn.Send(msg); err != nil {
+			return nil, err
+		}
+	}
+	if ugc.gameLoop != nil {
+		// 切断中の差分は届いていないので次の state はキーフレームにする
+		ugc.positions.ResetAck(userGameLocation.UserID)
+	}
+	ugc.sendPlayerStatus(userGameLocation)
+	return userGameLocation, nil
+}
+
+func (ugc *UserGameLocationUsecase) sendPlayerStatus(userGameLocation *model.UserGameLocation) {
+	statusMsg := &protocol.PlayerStatus{
+		Type:       protocol.TypePlayerStatus,
+		FromUserID: userGameLocation.UserID,
+		RoomID:     userGameLocation.RoomID,
+		Status:     userGameLocation.Status,
+	}
+	err := ugc.SendMessageToSameRoomWithoutMe(userGameLocation, model.NewMessage(statusMsg))
+	if err != nil {
+		log.Printf("Error sending player status: %v", err)
+	}
+}
+
+func (ugc *UserGameLocationUsecase) disconnectAll(userGameLocation *model.UserGameLocation) {
+	err := ugc.DisconnectInAudio(userGameLocation, userGameLocation.RoomID)
+	if err != nil {
+		log.Printf("Error disconnecting audio: %v", err)
+	}
+	err = ugc.DisconnectInGame(userGameLocation, userGameLocation.RoomID)
+	if err != nil {
+		log.Printf("Error disconnecting game: %v", err)
+	}
+}
+
+func (ugc *UserGameLocationUsecase) closeSession(userID uint) {
+	session, ok := ugc.inMemoryGameSessionRepo.FindByUserID(userID)
+	if !ok {
+		return
+	}
+	session.Close()
+	ugc.inMemoryGameSessionRepo.Delete(session.Token)
+}
+
+func newResumeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate resume token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -2,30 +2,73 @@
 package usecase
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/sako0/minigame-space-api/app/domain/model"
 	"github.com/sako0/minigame-space-api/app/domain/repository"
+	"github.com/sako0/minigame-space-api/app/protocol"
+	"github.com/sako0/minigame-space-api/app/sfu"
 )
 
 type UserLocationUsecase struct {
 	userLocationRepo         repository.UserLocationRepository
 	inMemoryUserLocationRepo repository.InMemoryUserLocationRepository
+	areaRepo                 repository.AreaRepository
+	roomRepo                 repository.RoomRepository
+	// nil の場合は move のたびにエリア全員の位置を送る
+	positions *positionTrackers
+	// nil の場合はエリア全員に位置を送る。使う場合は positions も必要
+	areaOfInterest *areaOfInterest
+	// nil の場合はエリア内の距離で音声をつながない
+	voiceProximity *areaOfInterest
+	// nil の場合は move のたびに DB に書き込む
+	positionWriter *PositionWriter
+	// nil の場合は SFU を使うルームタイプの音声に参加できない
+	sfu               *sfu.SFU
+	chatUsecase       *ChatUsecase
+	banRepo           repository.BanRepository
+	roomAccessChecker *RoomAccessChecker
 }
 
-func NewUserLocationUsecase(userLocationRepo repository.UserLocationRepository, inMemoryUserLocationRepo repository.InMemoryUserLocationRepository) *UserLocationUsecase {
-	return &UserLocationUsecase{userLocationRepo: userLocationRepo, inMemoryUserLocationRepo: inMemoryUserLocationRepo}
+func NewUserLocationUsecase(userLocationRepo repository.UserLocationRepository, inMemoryUserLocationRepo repository.InMemoryUserLocationRepository, areaRepo repository.AreaRepository, roomRepo repository.RoomRepository, deltaUpdates bool, viewRadius int, voiceRadius int, voiceExitMargin int, positionWriter *PositionWriter, sfu *sfu.SFU, chatUsecase *ChatUsecase, banRepo repository.BanRepository, roomAccessChecker *RoomAccessChecker) *UserLocationUsecase {
+	uc := &UserLocationUsecase{userLocationRepo: userLocationRepo, inMemoryUserLocationRepo: inMemoryUserLocationRepo, areaRepo: areaRepo, roomRepo: roomRepo, positionWriter: positionWriter, sfu: sfu, chatUsecase: chatUsecase, banRepo: banRepo, roomAccessChecker: roomAccessChecker}
+	if deltaUpdates {
+		uc.positions = newPositionTrackers()
+		if viewRadius > 0 {
+			uc.areaOfInterest = newAreaOfInterest(viewRadius, viewRadius)
+		}
+	}
+	if voiceRadius > 0 {
+		uc.voiceProximity = newAreaOfInterest(voiceRadius, voiceRadius+voiceExitMargin)
+	}
+	return uc
 }
 
 func (uc *UserLocationUsecase) ConnectUserLocationForArea(userLocation *model.UserLocation) error {
 	if userLocation.AreaID == 0 {
-		return fmt.Errorf("userLocation.AreaID is nil")
+		return fmt.Errorf("%w: userLocation.AreaID is nil", ErrInvalidArgument)
+	}
+	area, exists, err := uc.areaRepo.GetArea(userLocation.AreaID)
+	if err != nil {
+		return err
 	}
+	if !exists {
+		return fmt.Errorf("%w: area %d does not exist", ErrInvalidArgument, userLocation.AreaID)
+	}
+	err = checkBan(uc.banRepo, model.BanKindBan, userLocation.UserID, area.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	// 定員の確認とインメモリへの登録は同時に行わないと、同時に参加した場合に定員を超えてしまう
+	if !uc.inMemoryUserLocationRepo.StoreIfAreaNotFull(userLocation, area.MaxParticipant) {
+		return fmt.Errorf("%w: area %d has reached its capacity of %d", ErrRoomFull, area.ID, area.MaxParticipant)
+	}
+
 	// UserLocationが存在しない場合は新規作成
-	_, exists, err := uc.userLocationRepo.GetUserLocation(userLocation.UserID)
+	_, exists, err = uc.userLocationRepo.GetUserLocation(userLocation.UserID)
 	if err != nil {
 		uc.DisconnectUserLocation(userLocation)
 		return err
@@ -35,33 +78,51 @@ func (uc *UserLocationUsecase) ConnectUserLocationForArea(userLocation *model.Us
 		log.Println("userLocation is not exist")
 		err := uc.userLocationRepo.AddUserLocation(userLocation)
 		if err != nil {
+			uc.DisconnectUserLocation(userLocation)
 			return err
 		}
 	}
 
-	connectedUserLocations := uc.inMemoryUserLocationRepo.GetAllUserLocationsByAreaId(userLocation.AreaID)
-
-	// connectedUserLocationsの中に既に同じAreaIDでかつ同じUserIDを持つものがある場合は何もせずに終了
-	for _, otherUserLocation := range connectedUserLocations {
-		if otherUserLocation.AreaID == userLocation.AreaID && otherUserLocation.UserID == userLocation.UserID {
-			return nil
-		}
-	}
 	err = uc.userLocationRepo.UpdateUserLocation(userLocation)
 	if err != nil {
+		uc.DisconnectUserLocation(userLocation)
 		return err
 	}
-	uc.inMemoryUserLocationRepo.Store(userLocation)
+	userLocation.MovementRule = area.MovementRule.Indexed()
+	userLocation.MovementState.Reset(&userLocation.MovementRule, time.Now())
 
 	return nil
 }
-func (uc *UserLocationUsecase) ConnectUserLocationForRoom(userLocation *model.UserLocation) error {
+func (uc *UserLocationUsecase) ConnectUserLocationForRoom(userLocation *model.UserLocation, access RoomAccess) error {
 	if userLocation.RoomID == 0 {
-		return fmt.Errorf("userLocation.RoomID is nil")
+		return fmt.Errorf("%w: userLocation.RoomID is nil", ErrInvalidArgument)
+	}
+
+	room, exists, err := uc.roomRepo.GetRoom(userLocation.RoomID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: room %d does not exist", ErrInvalidArgument, userLocation.RoomID)
+	}
+	err = checkBan(uc.banRepo, model.BanKindBan, userLocation.UserID, room.AreaID, room.ID)
+	if err != nil {
+		return err
+	}
+	previousAdmittedRoomID := userLocation.AdmittedRoomID
+	invite, err := uc.admit(userLocation, room, access)
+	if err != nil {
+		return err
+	}
+
+	if !uc.inMemoryUserLocationRepo.StoreIfRoomNotFull(userLocation, room.RoomType.MaxParticipant) {
+		userLocation.AdmittedRoomID = previousAdmittedRoomID
+		uc.roomAccessChecker.Release(invite)
+		return fmt.Errorf("%w: room %d has reached its capacity of %d", ErrRoomFull, room.ID, room.RoomType.MaxParticipant)
 	}
 
 	// UserLocationが存在しない場合は新規作成
-	_, exists, err := uc.userLocationRepo.GetUserLocation(userLocation.UserID)
+	_, exists, err = uc.userLocationRepo.GetUserLocation(userLocation.UserID)
 	if err != nil {
 		uc.DisconnectUserLocation(userLocation)
 		return err
@@ -76,26 +137,25 @@ func (uc *UserLocationUsecase) ConnectUserLocationForRoom(userLocation *model.Us
 		}
 	}
 
-	connectedUserLocations := uc.inMemoryUserLocationRepo.GetAllUserLocationsByRoomId(userLocation.RoomID)
-
-	// connectedUserLocationsの中に既に同じRoomIDでかつ同じUserIDを持つものがある場合は何もせずに終了
-	for _, otherUserLocation := range connectedUserLocations {
-		if otherUserLocation.RoomID == userLocation.RoomID && otherUserLocation.UserID == userLocation.UserID {
-			return nil
-		}
-	}
 	err = uc.userLocationRepo.UpdateUserLocation(userLocation)
 	if err != nil {
 		uc.DisconnectUserLocation(userLocation)
 		return err
 	}
-	uc.inMemoryUserLocationRepo.Store(userLocation)
 
 	return nil
 }
 
 func (uc *UserLocationUsecase) DisconnectUserLocation(userLocation *model.UserLocation) error {
 	uc.inMemoryUserLocationRepo.Delete(userLocation.UserID)
+	uc.removeFromVoice(userLocation)
+	leaveVoice(uc.sfu, userLocation.UserID)
+	if uc.positions != nil {
+		// 退出したことを残りのユーザーに差分で知らせる
+		if areaID, ok := uc.positions.Remove(userLocation.UserID); ok {
+			uc.broadcastState(areaID, uc.removeFromView(userLocation.UserID, areaID))
+		}
+	}
 
 	return nil
 }
@@ -105,88 +165,343 @@ func (uc *UserLocationUsecase) SendAreaJoinedEvent(userLocation *model.UserLocat
 	if err != nil {
 		return err
 	}
-	areaJoinedMsg := map[string]interface{}{
-		"areaID":        userLocation.AreaID,
-		"type":          "joined-area",
-		"userLocations": userLocations,
-		"fromUserID":    userLocation.UserID,
-		"xAxis":         userLocation.XAxis,
-		"yAxis":         userLocation.YAxis,
+	areaJoinedMsg := &protocol.AreaJoined{
+		Type:          protocol.TypeJoinedArea,
+		FromUserID:    userLocation.UserID,
+		AreaID:        userLocation.AreaID,
+		XAxis:         userLocation.XAxis,
+		YAxis:         userLocation.YAxis,
+		UserLocations: userLocations,
 	}
 	msg := model.NewMessage(areaJoinedMsg)
-	return uc.SendMessageToSameArea(userLocation, msg)
+	err = uc.SendMessageToSameArea(userLocation, msg)
+	if err != nil {
+		return err
+	}
+	uc.sendAreaChatHistory(userLocation)
+	uc.updateVoice(userLocation)
+	if uc.positions != nil {
+		// 参加したユーザーにはキーフレームを、他のユーザーには差分を送る。移動元のエリアにも退出を知らせる
+		areaIDs := uc.positions.Set(userLocation.AreaID, uc.playerPosition(userLocation))
+		uc.positions.ResetAck(userLocation.UserID)
+		recipients := uc.updateView(userLocation)
+		for _, areaID := range areaIDs {
+			if areaID == userLocation.AreaID {
+				uc.broadcastState(areaID, recipients)
+			} else {
+				uc.broadcastState(areaID, nil)
+			}
+		}
+	}
+	return nil
 }
 
 func (uc *UserLocationUsecase) SendRoomJoinedEvent(userLocation *model.UserLocation) error {
+	room, exists, err := uc.roomRepo.GetRoom(userLocation.RoomID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: room %d does not exist", ErrInvalidArgument, userLocation.RoomID)
+	}
+	err = checkBan(uc.banRepo, model.BanKindMuteAudio, userLocation.UserID, room.AreaID, room.ID)
+	if err != nil {
+		return err
+	}
+	voiceMode, err := joinVoice(uc.sfu, room, userLocation.UserID, userLocation.Conn)
+	if err != nil {
+		return err
+	}
 	connectedUserLocations := uc.inMemoryUserLocationRepo.GetAllUserLocationsByRoomId(userLocation.RoomID)
 	connectedUserIds := []uint{}
 	for _, otherUserLocation := range connectedUserLocations {
 		connectedUserIds = append(connectedUserIds, otherUserLocation.UserID)
 	}
-	roomJoinedMsg := map[string]interface{}{
-		"type":             "join-audio",
-		"connectedUserIds": connectedUserIds,
-		"fromUserID":       userLocation.UserID,
+	roomJoinedMsg := &protocol.AudioJoined{
+		Type:             protocol.TypeJoinAudio,
+		FromUserID:       userLocation.UserID,
+		RoomID:           userLocation.RoomID,
+		ConnectedUserIDs: connectedUserIds,
+		VoiceMode:        voiceMode,
 	}
 	msg := model.NewMessage(roomJoinedMsg)
-	return uc.SendMessageToSameRoom(userLocation, msg)
+	err = uc.SendMessageToSameRoom(userLocation, msg)
+	if err != nil {
+		return err
+	}
+	uc.sendRoomChatHistory(userLocation)
+	return nil
 }
 
 func (uc *UserLocationUsecase) MoveInArea(userLocation *model.UserLocation, xAxis int, yAxis int) error {
-	userLocation.XAxis = xAxis
-	userLocation.YAxis = yAxis
-	log.Printf("XAxis: %d, YAxis: %d", xAxis, yAxis)
-	err := uc.userLocationRepo.UpdateUserLocation(userLocation)
-	if err != nil {
-		return err
+	if _, ok := uc.inMemoryUserLocationRepo.Find(userLocation.UserID); !ok {
+		return fmt.Errorf("%w: user %d has not joined area %d", ErrNotInRoom, userLocation.UserID, userLocation.AreaID)
+	}
+	x, y, reason := userLocation.MovementRule.Apply(&userLocation.MovementState, userLocation.XAxis, userLocation.YAxis, xAxis, yAxis, time.Now())
+	if reason != "" {
+		correctionMsg := &protocol.PositionCorrection{
+			Type:   protocol.TypePositionCorrection,
+			AreaID: userLocation.AreaID,
+			XAxis:  x,
+			YAxis:  y,
+			Reason: reason,
+		}
+		if err := userLocation.Conn.Send(correctionMsg); err != nil {
+			return err
+		}
+		if x == userLocation.XAxis && y == userLocation.YAxis {
+			// 動けなかった場合は他のユーザーに送る必要はない
+			return nil
+		}
+	}
+	userLocation.XAxis = x
+	userLocation.YAxis = y
+	log.Printf("XAxis: %d, YAxis: %d", x, y)
+	if uc.positionWriter != nil {
+		uc.positionWriter.SaveUserLocation(userLocation)
+	} else {
+		err := uc.userLocationRepo.UpdateUserLocation(userLocation)
+		if err != nil {
+			return err
+		}
 	}
 	uc.inMemoryUserLocationRepo.Store(userLocation)
+	uc.updateVoice(userLocation)
+	if uc.positions != nil {
+		uc.positions.Set(userLocation.AreaID, uc.playerPosition(userLocation))
+		uc.broadcastState(userLocation.AreaID, uc.updateView(userLocation))
+		return nil
+	}
 	userLocations, err := uc.GetSerializedConnectedUserLocations(userLocation.AreaID)
 	if err != nil {
 		return err
 	}
-	moveMsg := map[string]interface{}{
-		"type":          "move",
-		"areaID":        userLocation.AreaID,
-		"fromUserID":    userLocation.UserID,
-		"userLocations": userLocations,
-		"xAxis":         userLocation.XAxis,
-		"yAxis":         userLocation.YAxis,
+	moveMsg := &protocol.AreaMoved{
+		Type:          protocol.TypeMove,
+		FromUserID:    userLocation.UserID,
+		AreaID:        userLocation.AreaID,
+		XAxis:         userLocation.XAxis,
+		YAxis:         userLocation.YAxis,
+		UserLocations: userLocations,
 	}
 
 	msg := model.NewMessage(moveMsg)
 	return uc.SendMessageToSameArea(userLocation, msg)
 }
 
+// 退出時に呼び、まだ書き込んでいない位置も含めて現在の状態を DB に書き込む
+func (uc *UserLocationUsecase) flushPosition(userLocation *model.UserLocation) error {
+	if uc.positionWriter != nil {
+		return uc.positionWriter.FlushUserLocation(userLocation)
+	}
+	return uc.userLocationRepo.UpdateUserLocation(userLocation)
+}
+
+func (uc *UserLocationUsecase) playerPosition(userLocation *model.UserLocation) protocol.PlayerPosition {
+	return protocol.PlayerPosition{
+		UserID: userLocation.UserID,
+		XAxis:  userLocation.XAxis,
+		YAxis:  userLocation.YAxis,
+	}
+}
+
+// 視界の半径内にいるユーザーを求め直し、視界に出入りしたユーザーの双方に enter-view / leave-view を送る
+// 移動を知らせるべきユーザー (本人を含む) を返す。視界を使わない場合は nil を返し、エリア全員に送る
+func (uc *UserLocationUsecase) updateView(userLocation *model.UserLocation) []*model.UserLocation {
+	if uc.areaOfInterest == nil {
+		return nil
+	}
+	nearby := uc.inMemoryUserLocationRepo.GetUserLocationsInRange(userLocation.AreaID, userLocation.XAxis, userLocation.YAxis, uc.areaOfInterest.radius)
+	nearbyIDs := make([]uint, 0, len(nearby))
+	nearbyByID := make(map[uint]*model.UserLocation, len(nearby))
+	for _, other := range nearby {
+		nearbyIDs = append(nearbyIDs, other.UserID)
+		nearbyByID[other.UserID] = other
+	}
+	entered, left := uc.areaOfInterest.Update(userLocation.UserID, nearbyIDs, nearbyIDs)
+	for _, otherID := range entered {
+		other := nearbyByID[otherID]
+		uc.sendEvent(userLocation, &protocol.EnterView{Type: protocol.TypeEnterView, AreaID: userLocation.AreaID, UserID: other.UserID, XAxis: other.XAxis, YAxis: other.YAxis})
+		uc.sendEvent(other, &protocol.EnterView{Type: protocol.TypeEnterView, AreaID: userLocation.AreaID, UserID: userLocation.UserID, XAxis: userLocation.XAxis, YAxis: userLocation.YAxis})
+	}
+	for _, otherID := range left {
+		uc.sendEvent(userLocation, &protocol.LeaveView{Type: protocol.TypeLeaveView, AreaID: userLocation.AreaID, UserID: otherID})
+		if other, ok := uc.inMemoryUserLocationRepo.Find(otherID); ok {
+			uc.sendEvent(other, &protocol.LeaveView{Type: protocol.TypeLeaveView, AreaID: other.AreaID, UserID: userLocation.UserID})
+		}
+	}
+	return nearby
+}
+
+// エリアから外れたユーザーが視界に入っていたユーザーに leave-view を送り、そのユーザーたちを返す
+func (uc *UserLocationUsecase) removeFromView(userID uint, areaID uint) []*model.UserLocation {
+	if uc.areaOfInterest == nil {
+		return nil
+	}
+	viewers := []*model.UserLocation{}
+	for _, otherID := range uc.areaOfInterest.Remove(userID) {
+		other, ok := uc.inMemoryUserLocationRepo.Find(otherID)
+		if !ok {
+			continue
+		}
+		uc.sendEvent(other, &protocol.LeaveView{Type: protocol.TypeLeaveView, AreaID: areaID, UserID: userID})
+		viewers = append(viewers, other)
+	}
+	return viewers
+}
+
+// 音声の範囲に出入りしたユーザーの双方に peer-in-range / peer-out-of-range を送る
+// WebRTC の offer / answer は、受け取ったクライアント同士が既存のシグナリングで行う
+func (uc *UserLocationUsecase) updateVoice(userLocation *model.UserLocation) {
+	if uc.voiceProximity == nil || userLocation.AreaID == 0 {
+		return
+	}
+	nearby := uc.inMemoryUserLocationRepo.GetUserLocationsInRange(userLocation.AreaID, userLocation.XAxis, userLocation.YAxis, uc.voiceProximity.exitRadius)
+	inRange := make([]uint, 0, len(nearby))
+	inExitRange := make([]uint, 0, len(nearby))
+	for _, other := range nearby {
+		inExitRange = append(inExitRange, other.UserID)
+		// 大きな座標でも桁あふれしないよう int64 で比べる
+		dx := int64(other.XAxis) - int64(userLocation.XAxis)
+		dy := int64(other.YAxis) - int64(userLocation.YAxis)
+		radius := int64(uc.voiceProximity.radius)
+		if dx*dx+dy*dy <= radius*radius {
+			inRange = append(inRange, other.UserID)
+		}
+	}
+	entered, left := uc.voiceProximity.Update(userLocation.UserID, inRange, inExitRange)
+	for _, otherID := range entered {
+		other, ok := uc.inMemoryUserLocationRepo.Find(otherID)
+		if !ok {
+			continue
+		}
+		// 両方から offer を送り合わないよう、userID の小さい側だけを initiator にする
+		uc.sendEvent(userLocation, &protocol.PeerInRange{Type: protocol.TypePeerInRange, AreaID: userLocation.AreaID, UserID: otherID, Initiator: userLocation.UserID < otherID})
+		uc.sendEvent(other, &protocol.PeerInRange{Type: protocol.TypePeerInRange, AreaID: userLocation.AreaID, UserID: userLocation.UserID, Initiator: otherID < userLocation.UserID})
+	}
+	for _, otherID := range left {
+		uc.sendEvent(userLocation, &protocol.PeerOutOfRange{Type: protocol.TypePeerOutOfRange, AreaID: userLocation.AreaID, UserID: otherID})
+		if other, ok := uc.inMemoryUserLocationRepo.Find(otherID); ok {
+			uc.sendEvent(other, &protocol.PeerOutOfRange{Type: protocol.TypePeerOutOfRange, AreaID: other.AreaID, UserID: userLocation.UserID})
+		}
+	}
+}
+
+func (uc *UserLocationUsecase) removeFromVoice(userLocation *model.UserLocation) {
+	if uc.voiceProximity == nil {
+		return
+	}
+	for _, otherID := range uc.voiceProximity.Remove(userLocation.UserID) {
+		if other, ok := uc.inMemoryUserLocationRepo.Find(otherID); ok {
+			uc.sendEvent(other, &protocol.PeerOutOfRange{Type: protocol.TypePeerOutOfRange, AreaID: other.AreaID, UserID: userLocation.UserID})
+		}
+	}
+}
+
+func (uc *UserLocationUsecase) sendEvent(userLocation *model.UserLocation, msg interface{}) {
+	if err := userLocation.Conn.Send(msg); err != nil {
+		log.Printf("Error sending event to client: %v", err)
+	}
+}
+
+// 各ユーザーに、ack したスナップショットからの差分を送る。recipients が nil の場合はエリア全員に送る
+// 視界を使う場合は、それぞれの視界に入っているユーザーの位置だけを送る
+func (uc *UserLocationUsecase) broadcastState(areaID uint, recipients []*model.UserLocation) {
+	connectedUserLocations := recipients
+	if connectedUserLocations == nil {
+		connectedUserLocations = uc.inMemoryUserLocationRepo.GetAllUserLocationsByAreaId(areaID)
+	}
+	userIDs := make([]uint, 0, len(connectedUserLocations))
+	for _, userLocation := range connectedUserLocations {
+		userIDs = append(userIDs, userLocation.UserID)
+	}
+	// 視界を使う場合は、知らせる相手の位置だけを確定して差分を作る
+	var playerIDs []uint
+	if recipients != nil {
+		playerIDs = userIDs
+	}
+	states, changed := uc.positions.Commit(areaID, userIDs, playerIDs)
+	if len(connectedUserLocations) == 0 {
+		if recipients == nil {
+			uc.positions.Drop(areaID)
+		}
+		return
+	}
+	if !changed {
+		return
+	}
+	for _, otherClient := range connectedUserLocations {
+		stateMsg := uc.filterState(otherClient.UserID, states[otherClient.UserID])
+		stateMsg.AreaID = areaID
+		err := otherClient.Conn.Send(&stateMsg)
+		if err != nil {
+			log.Printf("Error sending message to client: %v", err)
+			uc.DisconnectUserLocation(otherClient)
+		}
+	}
+}
+
+func (uc *UserLocationUsecase) filterState(userID uint, state protocol.State) protocol.State {
+	if uc.areaOfInterest == nil {
+		return state
+	}
+	players := make([]protocol.PlayerPosition, 0, len(state.Players))
+	for _, player := range state.Players {
+		if uc.areaOfInterest.CanSee(userID, player.UserID) {
+			players = append(players, player)
+		}
+	}
+	state.Players = players
+	return state
+}
+
+// クライアントが受け取った state の seq を記録する。以降はこの seq からの差分を送る
+func (uc *UserLocationUsecase) AckState(userLocation *model.UserLocation, seq uint64) error {
+	if _, ok := uc.inMemoryUserLocationRepo.Find(userLocation.UserID); !ok {
+		return fmt.Errorf("%w: user %d has not joined area %d", ErrNotInRoom, userLocation.UserID, userLocation.AreaID)
+	}
+	if uc.positions != nil {
+		uc.positions.Ack(userLocation.UserID, seq)
+	}
+	return nil
+}
+
+// エリア全員の位置をキーフレームとしてすぐに送る
+func (uc *UserLocationUsecase) SendKeyframe(userLocation *model.UserLocation) error {
+	if uc.positions == nil {
+		return fmt.Errorf("%w: state updates are disabled", ErrInvalidArgument)
+	}
+	stateMsg, ok := uc.positions.Keyframe(userLocation.UserID)
+	if !ok {
+		return fmt.Errorf("%w: user %d has not joined area %d", ErrNotInRoom, userLocation.UserID, userLocation.AreaID)
+	}
+	stateMsg = uc.filterState(userLocation.UserID, stateMsg)
+	stateMsg.AreaID = userLocation.AreaID
+	return userLocation.Conn.Send(&stateMsg)
+}
+
 func (uc *UserLocationUsecase) SendMessageToSameArea(userLocation *model.UserLocation, msg *model.Message) error {
 	msgPayload := msg.Payload
-	msgPayload["fromUserID"] = userLocation.UserID
-	msgPayload["areaID"] = userLocation.AreaID
 	connectedUserLocations := uc.inMemoryUserLocationRepo.GetAllUserLocationsByAreaId(userLocation.AreaID)
 	for _, otherClient := range connectedUserLocations {
-		err := otherClient.Conn.WriteJSON(msgPayload)
+		// キューに積むだけなので遅いクライアントがいても他のクライアントへの送信は止まらない
+		err := otherClient.Conn.Send(msgPayload)
 		if err != nil {
 			log.Printf("Error sending message to client: %v", err)
 			uc.DisconnectUserLocation(otherClient)
-			return err
 		}
 	}
 	return nil
 }
 func (uc *UserLocationUsecase) SendMessageToSameRoom(userLocation *model.UserLocation, msg *model.Message) error {
 	msgPayload := msg.Payload
-	msgPayload["fromUserID"] = userLocation.UserID
-	msgPayload["roomID"] = userLocation.RoomID
 	connectedUserLocations := uc.inMemoryUserLocationRepo.GetAllUserLocationsByRoomId(userLocation.RoomID)
 	for _, otherClient := range connectedUserLocations {
 		if otherClient.UserID != userLocation.UserID {
-			otherClient.Mutex.Lock()
-			defer otherClient.Mutex.Unlock()
-			err := otherClient.Conn.WriteJSON(msgPayload)
+			err := otherClient.Conn.Send(msgPayload)
 			if err != nil {
 				log.Printf("Error sending message to client: %v", err)
 				uc.DisconnectUserLocation(otherClient)
-				return err
 			}
 		}
 	}
@@ -194,19 +509,12 @@ func (uc *UserLocationUsecase) SendMessageToSameRoom(userLocation *model.UserLoc
 }
 func (uc *UserLocationUsecase) SendMessageToSpecificUser(userLocation *model.UserLocation, msg *model.Message, targetUserID uint) error {
 	msgPayload := msg.Payload
-	msgPayload["fromUserID"] = userLocation.UserID
-	msgPayload["areaID"] = userLocation.AreaID
-	msgPayload["roomID"] = userLocation.RoomID
-	msgPayload["toUserID"] = targetUserID
 
 	targetUserLocation, ok := uc.inMemoryUserLocationRepo.Find(targetUserID)
 	if !ok {
-		return fmt.Errorf("target user location not found for UserID: %d", targetUserID)
+		return fmt.Errorf("%w: target user location not found for UserID: %d", ErrNotInRoom, targetUserID)
 	}
-
-	targetUserLocation.Mutex.Lock()
-	defer targetUserLocation.Mutex.Unlock()
-	err := targetUserLocation.Conn.WriteJSON(msgPayload)
+	err := targetUserLocation.Conn.Send(msgPayload)
 	if err != nil {
 		log.Printf("Error sending message to client: %v", err)
 		uc.DisconnectUserLocation(targetUserLocation)
@@ -222,21 +530,24 @@ func (uc *UserLocationUsecase) LeaveInArea(userLocation *model.UserLocation) err
 		return err
 	}
 	if !ok {
-		return errors.New("user location not found")
+		return fmt.Errorf("%w: user location not found", ErrNotInRoom)
 	}
 	userLocations, err := uc.GetSerializedConnectedUserLocations(userLocation.AreaID)
 	if err != nil {
 		return err
 	}
-	leaveMsg := map[string]interface{}{
-		"type":          "leave-area",
-		"areaID":        userLocation.AreaID,
-		"roomID":        userLocation.RoomID,
-		"fromUserID":    userLocation.UserID,
-		"userLocations": userLocations,
+	leaveMsg := &protocol.AreaLeft{
+		Type:          protocol.TypeLeaveArea,
+		FromUserID:    userLocation.UserID,
+		AreaID:        userLocation.AreaID,
+		RoomID:        userLocation.RoomID,
+		UserLocations: userLocations,
 	}
 	msg := model.NewMessage(leaveMsg)
 	uc.DisconnectUserLocation(userLocation)
+	if uc.positionWriter != nil {
+		uc.positionWriter.DiscardUserLocation(userLocation.UserID)
+	}
 	err = uc.userLocationRepo.RemoveUserLocation(userLocation.UserID)
 	if err != nil {
 		return fmt.Errorf("failed to remove user location: %w", err)
@@ -245,25 +556,8 @@ func (uc *UserLocationUsecase) LeaveInArea(userLocation *model.UserLocation) err
 }
 
 func (uc *UserLocationUsecase) LeaveInRoom(userLocation *model.UserLocation, roomID uint) error {
-	connectedUserLocations := uc.inMemoryUserLocationRepo.GetAllUserLocationsByRoomId(roomID)
-	for _, otherClient := range connectedUserLocations {
-		if otherClient.UserID != userLocation.UserID {
-			leaveMsg := map[string]interface{}{
-				"type":       "leave-room",
-				"areaID":     userLocation.AreaID,
-				"roomID":     roomID,
-				"fromUserID": userLocation.UserID,
-				"toUserID":   otherClient.UserID,
-			}
-			msg := model.NewMessage(leaveMsg)
-			err := uc.SendMessageToSpecificUser(userLocation, msg, otherClient.UserID)
-			if err != nil {
-				return err
-			}
-
-		}
-	}
-	err := uc.userLocationRepo.UpdateUserLocation(userLocation)
+	uc.notifyRoomLeft(userLocation, roomID, protocol.TypeLeaveRoom)
+	err := uc.flushPosition(userLocation)
 	if err != nil {
 		return err
 	}
@@ -276,23 +570,8 @@ func (uc *UserLocationUsecase) LeaveInRoom(userLocation *model.UserLocation, roo
 }
 
 func (uc *UserLocationUsecase) DisconnectInRoom(userLocation *model.UserLocation, roomID uint) error {
-	connectedUserLocations := uc.inMemoryUserLocationRepo.GetAllUserLocationsByRoomId(roomID)
-	for _, otherClient := range connectedUserLocations {
-		if otherClient.UserID != userLocation.UserID {
-			leaveMsg := map[string]interface{}{
-				"type":       "disconnect-room",
-				"areaID":     userLocation.AreaID,
-				"roomID":     roomID,
-				"fromUserID": userLocation.UserID,
-			}
-			msg := model.NewMessage(leaveMsg)
-			err := uc.SendMessageToSpecificUser(userLocation, msg, otherClient.UserID)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	err := uc.userLocationRepo.UpdateUserLocation(userLocation)
+	uc.notifyRoomLeft(userLocation, roomID, protocol.TypeDisconnectRoom)
+	err := uc.flushPosition(userLocation)
 	if err != nil {
 		return err
 	}
@@ -304,35 +583,70 @@ func (uc *UserLocationUsecase) DisconnectInRoom(userLocation *model.UserLocation
 	return nil
 }
 
-func (uc *UserLocationUsecase) GetSerializedConnectedUserLocations(ariaID uint) ([]map[string]interface{}, error) {
-	connectedUserLocations := uc.inMemoryUserLocationRepo.GetAllUserLocationsByAreaId(ariaID)
-	userLocations := []map[string]interface{}{}
-	for _, otherUserLocation := range connectedUserLocations {
-		userLocation, exists, err := uc.userLocationRepo.GetUserLocation(otherUserLocation.UserID)
-
-		if err != nil {
-			uc.DisconnectUserLocation(userLocation)
-			return nil, err
-		}
-		if !exists {
-			log.Printf("user location does not exist for user ID: %d", otherUserLocation.UserID)
-			err := uc.userLocationRepo.AddUserLocation(userLocation)
-			if err != nil {
-				uc.DisconnectUserLocation(userLocation)
-				return nil, fmt.Errorf("failed to add user location: %w", err)
-			}
+// ルームからの退出や切断をルームの他のユーザーに知らせる。エリアにだけいるユーザーの場合は何もしない
+func (uc *UserLocationUsecase) notifyRoomLeft(userLocation *model.UserLocation, roomID uint, msgType string) {
+	if roomID == 0 {
+		return
+	}
+	connectedUserLocations := uc.inMemoryUserLocationRepo.GetAllUserLocationsByRoomId(roomID)
+	for _, otherClient := range connectedUserLocations {
+		if otherClient.UserID == userLocation.UserID {
+			continue
 		}
-		userLocationJSON, err := userLocation.MarshalJSON()
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal user location from JSON: %w", err)
-
+		leaveMsg := &protocol.RoomLeft{
+			Type:       msgType,
+			FromUserID: userLocation.UserID,
+			ToUserID:   otherClient.UserID,
+			AreaID:     userLocation.AreaID,
+			RoomID:     roomID,
 		}
-		var userLocationMap map[string]interface{}
-		err = json.Unmarshal(userLocationJSON, &userLocationMap)
+		err := otherClient.Conn.Send(leaveMsg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal user location from JSON: %w", err)
+			log.Printf("Error sending message to client: %v", err)
+			uc.DisconnectUserLocation(otherClient)
 		}
-		userLocations = append(userLocations, userLocationMap)
+	}
+}
+
+// インメモリの状態だけから組み立てる。DB の位置は書き込みが遅れることがあるため読まない
+func (uc *UserLocationUsecase) GetSerializedConnectedUserLocations(ariaID uint) ([]protocol.UserLocation, error) {
+	connectedUserLocations := uc.inMemoryUserLocationRepo.GetAllUserLocationsByAreaId(ariaID)
+	userLocations := make([]protocol.UserLocation, 0, len(connectedUserLocations))
+	for _, userLocation := range connectedUserLocations {
+		userLocations = append(userLocations, protocol.UserLocation{
+			UserID: userLocation.UserID,
+			AreaID: userLocation.AreaID,
+			RoomID: userLocation.RoomID,
+			XAxis:  userLocation.XAxis,
+			YAxis:  userLocation.YAxis,
+		})
 	}
 	return userLocations, nil
 }
+
+// offer / answer / ice-candidate を送信元を付けて特定のユーザーに転送する
+func (uc *UserLocationUsecase) ForwardSignal(userLocation *model.UserLocation, signal *protocol.Signal) error {
+	err := checkSignalBan(uc.banRepo, signal, userLocation.UserID, userLocation.AreaID, userLocation.RoomID)
+	if err != nil {
+		return err
+	}
+	if signal.Target == protocol.SignalTargetServer {
+		return handleServerSignal(uc.sfu, userLocation.UserID, signal)
+	}
+	// 同じルームか、距離で音声がつながっているユーザーにしか送れない
+	target, ok := uc.inMemoryUserLocationRepo.Find(signal.ToUserID)
+	sameRoom := ok && userLocation.RoomID != 0 && userLocation.RoomID == target.RoomID
+	inVoiceRange := uc.voiceProximity != nil && uc.voiceProximity.CanSee(userLocation.UserID, signal.ToUserID)
+	if _, joined := uc.inMemoryUserLocationRepo.Find(userLocation.UserID); !joined || (!sameRoom && !inVoiceRange) {
+		return fmt.Errorf("%w: user %d is not in the same room or audio group as user %d", ErrNotInRoom, signal.ToUserID, userLocation.UserID)
+	}
+	forwardMsg := &protocol.SignalForward{
+		Signal:     signal,
+		FromUserID: userLocation.UserID,
+		ToUserID:   signal.ToUserID,
+		AreaID:     userLocation.AreaID,
+		RoomID:     userLocation.RoomID,
+	}
+	msg := model.NewMessage(forwardMsg)
+	return uc.SendMessageToSpecificUser(userLocation, msg, signal.ToUserID)
+}
@@ -0,0 +1,31 @@
+package usecase
+
+import (
+	"fmt"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+)
+
+// 名前や説明などの文字列の長さを確認する。DB のカラムは varchar(255)
+func validateLength(field string, value string, required bool) error {
+	if required && value == "" {
+		return fmt.Errorf("%w: %s must not be empty", ErrInvalidArgument, field)
+	}
+	if len([]rune(value)) > 255 {
+		return fmt.Errorf("%w: %s must be at most 255 characters", ErrInvalidArgument, field)
+	}
+	return nil
+}
+
+func validateMovementRule(rule *model.MovementRule) error {
+	if rule.MapWidth < 0 || rule.MapHeight < 0 {
+		return fmt.Errorf("%w: map size must not be negative", ErrInvalidArgument)
+	}
+	if rule.MaxSpeed < 0 {
+		return fmt.Errorf("%w: maxSpeed must not be negative", ErrInvalidArgument)
+	}
+	if rule.TileSize < 0 {
+		return fmt.Errorf("%w: tileSize must not be negative", ErrInvalidArgument)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/protocol"
+	"github.com/sako0/minigame-space-api/app/sfu"
+)
+
+// SFU からの送信先。再接続で接続が差し替わった後は新しい接続に送る
+type userGameLocationSender struct {
+	userGameLocation *model.UserGameLocation
+}
+
+func (s userGameLocationSender) Send(msg interface{}) error {
+	return s.userGameLocation.Connection().Send(msg)
+}
+
+// ルームタイプが SFU を使う場合は SFU に参加させる。使うことになった音声のつなぎ方を返す
+func joinVoice(s *sfu.SFU, room *model.Room, userID uint, sender sfu.Sender) (string, error) {
+	if !room.RoomType.UsesSFU() {
+		return model.VoiceModeMesh, nil
+	}
+	if s == nil {
+		return "", fmt.Errorf("%w: room %d requires an SFU but it is not available", ErrInvalidArgument, room.ID)
+	}
+	if err := s.Join(room.ID, userID, sender); err != nil {
+		return "", err
+	}
+	return model.VoiceModeSFU, nil
+}
+
+func leaveVoice(s *sfu.SFU, userID uint) {
+	if s != nil {
+		s.Leave(userID)
+	}
+}
+
+// target が "server" の offer / answer / ice-candidate を SFU に渡す
+func handleServerSignal(s *sfu.SFU, userID uint, signal *protocol.Signal) error {
+	if s == nil {
+		return fmt.Errorf("%w: SFU is not available", ErrInvalidArgument)
+	}
+	err := s.HandleSignal(userID, signal)
+	if errors.Is(err, sfu.ErrNotJoined) {
+		return fmt.Errorf("%w: %v", ErrNotInRoom, err)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+	}
+	return nil
+}
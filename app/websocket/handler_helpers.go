@@ -1,21 +1,116 @@
 package handler
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
 
-var retryInterval = 500 * time.Millisecond
+	"github.com/gorilla/websocket"
+	"github.com/sako0/minigame-space-api/app/protocol"
+	"github.com/sako0/minigame-space-api/app/usecase"
+	"github.com/sako0/minigame-space-api/app/wsconn"
+)
 
-func isValidRoomId(roomId uint) bool {
-	return roomId != 0
-}
+var retryInterval = 500 * time.Millisecond
 
-func isValidUserId(fromUserID uint) bool {
-	return fromUserID != 0
-}
+// ブラウザの WebSocket はヘッダーを付けられないため、サブプロトコルでもトークンを受け付ける
+// new WebSocket(url, ["bearer", idToken])
+const bearerSubprotocol = "bearer"
 
 // 一時的なエラーかどうかを判定する
 func isTemporary(err error) bool {
+	// 読み込みデッドラインを超えた接続は再試行しても復帰しない
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
 	te, ok := err.(interface {
 		Temporary() bool
 	})
 	return ok && te.Temporary()
 }
+
+// クエリパラメータ token または Sec-WebSocket-Protocol から ID トークンを取り出す
+// サブプロトコルで受け取った場合はアップグレード時に返すヘッダーも返す
+func extractIDToken(r *http.Request) (string, http.Header) {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token, nil
+	}
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == bearerSubprotocol && i+1 < len(protocols) {
+			header := http.Header{}
+			header.Set("Sec-WebSocket-Protocol", bearerSubprotocol)
+			return protocols[i+1], header
+		}
+	}
+	return "", nil
+}
+
+// クライアントが送ってきた fromUserID が接続に紐づくユーザーと一致するかを確認する
+// fromUserID が省略されている場合は接続のユーザーとして扱う
+func checkFromUserID(msg protocol.Inbound, authenticatedUserID uint) error {
+	fromUserID := msg.Header().FromUserID
+	if fromUserID == nil {
+		return nil
+	}
+	if *fromUserID != authenticatedUserID {
+		return fmt.Errorf("%w: fromUserID %d does not match authenticated user %d", usecase.ErrUnauthorized, *fromUserID, authenticatedUserID)
+	}
+	return nil
+}
+
+// エラーをクライアントに返すエラーコードに変換する
+func errorCode(err error) protocol.ErrorCode {
+	var decodeErr *protocol.DecodeError
+	switch {
+	case errors.As(err, &decodeErr), errors.Is(err, usecase.ErrInvalidArgument):
+		return protocol.CodeInvalidPayload
+	case errors.Is(err, usecase.ErrRoomFull):
+		return protocol.CodeRoomFull
+	case errors.Is(err, usecase.ErrNotInRoom):
+		return protocol.CodeNotInRoom
+	case errors.Is(err, usecase.ErrUnauthorized):
+		return protocol.CodeUnauthorized
+	case errors.Is(err, usecase.ErrRateLimited):
+		return protocol.CodeRateLimited
+	case errors.Is(err, usecase.ErrBanned):
+		return protocol.CodeBanned
+	case errors.Is(err, usecase.ErrAccessDenied):
+		return protocol.CodeAccessDenied
+	case errors.Is(err, usecase.ErrRoomBusy):
+		return protocol.CodeRoomBusy
+	case errors.Is(err, usecase.ErrConflict):
+		return protocol.CodeConflict
+	default:
+		return protocol.CodeInternal
+	}
+}
+
+// 処理に失敗したリクエストについてクライアントにエラーフレームを返す
+func writeError(conn *wsconn.Conn, requestType string, err error) {
+	var decodeErr *protocol.DecodeError
+	if errors.As(err, &decodeErr) && requestType == "" {
+		requestType = decodeErr.RequestType
+	}
+	code := errorCode(err)
+	message := err.Error()
+	if code == protocol.CodeInternal {
+		// 内部エラーの詳細はクライアントに返さない
+		message = "internal server error"
+	}
+	if err := conn.Send(protocol.NewError(code, requestType, message)); err != nil {
+		log.Printf("Error sending error message to client: %v", err)
+	}
+}
+
+// 1 つの接続で panic が起きてもサーバー全体を落とさない
+func recoverConnection() {
+	if r := recover(); r != nil {
+		log.Printf("Recovered from panic in connection: %v\n%s", r, debug.Stack())
+	}
+}
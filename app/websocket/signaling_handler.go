@@ -8,30 +8,50 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/protocol"
 	"github.com/sako0/minigame-space-api/app/usecase"
+	"github.com/sako0/minigame-space-api/app/wsconn"
 )
 
 type WebSocketHandler struct {
 	userLocationUsecase usecase.UserLocationUsecase
+	lobbyUsecase        *usecase.LobbyUsecase
+	authUsecase         *usecase.AuthUsecase
+	moderationUsecase   *usecase.ModerationUsecase
+	iceServerUsecase    *usecase.ICEServerUsecase
 	upgrader            websocket.Upgrader
+	connOptions         wsconn.Options
 }
 
-func NewWebSocketHandler(userLocationUsecase usecase.UserLocationUsecase, upgrader websocket.Upgrader) *WebSocketHandler {
-	return &WebSocketHandler{userLocationUsecase: userLocationUsecase, upgrader: upgrader}
+func NewWebSocketHandler(userLocationUsecase usecase.UserLocationUsecase, lobbyUsecase *usecase.LobbyUsecase, authUsecase *usecase.AuthUsecase, moderationUsecase *usecase.ModerationUsecase, iceServerUsecase *usecase.ICEServerUsecase, upgrader websocket.Upgrader, connOptions wsconn.Options) *WebSocketHandler {
+	return &WebSocketHandler{userLocationUsecase: userLocationUsecase, lobbyUsecase: lobbyUsecase, authUsecase: authUsecase, moderationUsecase: moderationUsecase, iceServerUsecase: iceServerUsecase, upgrader: upgrader, connOptions: connOptions}
 }
 
 func (h *WebSocketHandler) HandleConnections(w http.ResponseWriter, r *http.Request) {
-	conn, err := h.upgrader.Upgrade(w, r, nil)
+	idToken, responseHeader := extractIDToken(r)
+	user, err := h.authUsecase.Authenticate(idToken)
+	if err != nil {
+		log.Printf("Error authenticating connection: %v", err)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ws, err := h.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Printf("Error upgrading connection: %v", err)
 		return
 	}
+	conn := wsconn.New(ws, h.connOptions)
 	defer conn.Close()
+	defer recoverConnection()
 
 	userLocation := model.NewUserLocationByConn(conn)
+	// 以降この接続のユーザーは ID トークンで検証したユーザーに固定する
+	userLocation.UserID = user.ID
 
 	defer func() {
 		// クリーンアップ処理
+		h.lobbyUsecase.Unsubscribe(userLocation)
 		err := h.userLocationUsecase.DisconnectInRoom(userLocation, userLocation.RoomID)
 		if err != nil {
 			log.Printf("Error disconnecting user: %v", err)
@@ -39,12 +59,19 @@ func (h *WebSocketHandler) HandleConnections(w http.ResponseWriter, r *http.Requ
 	}()
 
 	for {
-		msg, err := h.readMessage(conn)
+		data, err := h.readMessage(conn)
 		if err != nil {
 			log.Printf("Error reading message: %v", err)
 			break
 		}
 
+		msg, err := protocol.DecodeAreaMessage(data)
+		if err != nil {
+			log.Printf("Error decoding message: %v", err)
+			writeError(conn, "", err)
+			continue
+		}
+
 		err = h.processMessage(userLocation, msg)
 		if err != nil {
 			log.Printf("Error processing message: %v", err)
@@ -53,38 +80,51 @@ func (h *WebSocketHandler) HandleConnections(w http.ResponseWriter, r *http.Requ
 	}
 }
 
-func (h *WebSocketHandler) readMessage(conn *websocket.Conn) (map[string]interface{}, error) {
-	var msg = map[string]interface{}{}
-	err := conn.ReadJSON(&msg)
+func (h *WebSocketHandler) readMessage(conn *wsconn.Conn) ([]byte, error) {
+	data, err := conn.ReadMessage()
 	if err != nil {
 		// 一時的なエラーの場合はリトライ
 		if isTemporary(err) {
 			time.Sleep(retryInterval)
 			return h.readMessage(conn)
 		}
-		log.Printf("Error reading JSON: %v", err)
+		log.Printf("Error reading message: %v", err)
 		return nil, err
 	}
-	return msg, nil
+	return data, nil
 }
 
-func (h *WebSocketHandler) processMessage(client *model.UserLocation, msg map[string]interface{}) error {
-	var err error
-	switch msg["type"].(string) {
-	case "join-area":
-		err = h.handleJoinArea(client, msg)
-	case "join-audio":
-		err = h.handleJoinRoom(client, msg)
-	case "leave-area":
-		err = h.handleLeaveArea(client, msg)
-	case "leave-audio":
-		err = h.handleLeaveRoom(client, msg)
-	case "move":
-		err = h.handleMove(client, msg)
-	case "offer", "answer", "ice-candidate":
-		err = h.handleSignalingMessage(client, msg)
-	default:
-		err = fmt.Errorf("unknown message type")
+func (h *WebSocketHandler) processMessage(client *model.UserLocation, msg protocol.Inbound) error {
+	err := checkFromUserID(msg, client.UserID)
+	if err == nil {
+		switch m := msg.(type) {
+		case *protocol.JoinArea:
+			err = h.handleJoinArea(client, m)
+		case *protocol.JoinAudio:
+			err = h.handleJoinRoom(client, m)
+		case *protocol.LeaveArea:
+			err = h.handleLeaveArea(client, m)
+		case *protocol.LeaveAudio:
+			err = h.handleLeaveRoom(client, m)
+		case *protocol.MoveInArea:
+			err = h.handleMove(client, m)
+		case *protocol.Signal:
+			err = h.handleSignalingMessage(client, m)
+		case *protocol.SubscribeLobby:
+			err = h.lobbyUsecase.Subscribe(client, m.AreaID)
+		case *protocol.UnsubscribeLobby:
+			h.lobbyUsecase.Unsubscribe(client)
+		case *protocol.Ack:
+			err = h.userLocationUsecase.AckState(client, m.Seq)
+		case *protocol.RequestKeyframe:
+			err = h.userLocationUsecase.SendKeyframe(client)
+		case *protocol.Chat:
+			err = h.userLocationUsecase.SendChatMessage(client, m)
+		case *protocol.Moderate:
+			err = h.moderationUsecase.ModerateRoom(client.UserID, client.RoomID, m)
+		default:
+			err = fmt.Errorf("%w: unsupported message type %q", usecase.ErrInvalidArgument, msg.Header().Type)
+		}
 	}
 	if err != nil {
 		// 一時的なエラーの場合はリトライ
@@ -93,22 +133,19 @@ func (h *WebSocketHandler) processMessage(client *model.UserLocation, msg map[st
 			return h.processMessage(client, msg)
 		}
 		log.Printf("Error processing message: %v", err)
+		writeError(client.Conn, msg.Header().Type, err)
 	}
 	return nil
 }
 
-func (h *WebSocketHandler) handleJoinArea(userLocation *model.UserLocation, msg map[string]interface{}) error {
-	areaID := uint(msg["areaID"].(float64))
-	userLocation.AreaID = areaID
-
-	fromUserID := uint(msg["fromUserID"].(float64))
-	if !isValidUserId(fromUserID) {
-		return fmt.Errorf("invalid fromUserID")
-	}
-	userLocation.UserID = fromUserID
+func (h *WebSocketHandler) handleJoinArea(userLocation *model.UserLocation, msg *protocol.JoinArea) error {
+	previousAreaID := userLocation.AreaID
+	userLocation.AreaID = msg.AreaID
 
 	err := h.userLocationUsecase.ConnectUserLocationForArea(userLocation)
 	if err != nil {
+		// 参加できなかった場合は元のエリアに戻す
+		userLocation.AreaID = previousAreaID
 		log.Printf("Error connecting client to area: %v", err)
 		return err
 	}
@@ -122,24 +159,23 @@ func (h *WebSocketHandler) handleJoinArea(userLocation *model.UserLocation, msg
 	return nil
 }
 
-func (h *WebSocketHandler) handleJoinRoom(userLocation *model.UserLocation, msg map[string]interface{}) error {
-	roomId := uint(msg["roomID"].(float64))
-	if !isValidRoomId(roomId) {
-		return fmt.Errorf("invalid roomID")
-	}
-	userLocation.RoomID = roomId
-
-	fromUserID := uint(msg["fromUserID"].(float64))
-	if !isValidUserId(fromUserID) {
-		return fmt.Errorf("invalid fromUserID")
-	}
-	userLocation.UserID = fromUserID
+func (h *WebSocketHandler) handleJoinRoom(userLocation *model.UserLocation, msg *protocol.JoinAudio) error {
+	previousRoomID := userLocation.RoomID
+	userLocation.RoomID = msg.RoomID
 
-	err := h.userLocationUsecase.ConnectUserLocationForRoom(userLocation)
+	access := usecase.RoomAccess{Passcode: msg.Passcode, InviteCode: msg.InviteCode}
+	err := h.userLocationUsecase.ConnectUserLocationForRoom(userLocation, access)
 	if err != nil {
+		userLocation.RoomID = previousRoomID
 		log.Printf("Error connecting client to room: %v", err)
 		return err
 	}
+	// offer を送る前に使う ICE サーバーを知らせる
+	err = userLocation.Conn.Send(h.iceServerUsecase.GetICEConfig(userLocation.UserID))
+	if err != nil {
+		h.userLocationUsecase.DisconnectUserLocation(userLocation)
+		return err
+	}
 	err = h.userLocationUsecase.SendRoomJoinedEvent(userLocation)
 	if err != nil {
 		log.Printf("Error sending room joined event: %v", err)
@@ -149,29 +185,19 @@ func (h *WebSocketHandler) handleJoinRoom(userLocation *model.UserLocation, msg
 
 	return nil
 }
-func (h *WebSocketHandler) handleLeaveArea(userLocation *model.UserLocation, msg map[string]interface{}) error {
+func (h *WebSocketHandler) handleLeaveArea(userLocation *model.UserLocation, msg *protocol.LeaveArea) error {
 	return h.userLocationUsecase.LeaveInArea(userLocation)
 }
-func (h *WebSocketHandler) handleLeaveRoom(userLocation *model.UserLocation, msg map[string]interface{}) error {
-	roomID := uint(msg["roomID"].(float64))
-	if !isValidRoomId(roomID) {
-		return fmt.Errorf("invalid roomID")
-	}
-	return h.userLocationUsecase.LeaveInRoom(userLocation, roomID)
+func (h *WebSocketHandler) handleLeaveRoom(userLocation *model.UserLocation, msg *protocol.LeaveAudio) error {
+	return h.userLocationUsecase.LeaveInRoom(userLocation, msg.RoomID)
 }
 
-func (h *WebSocketHandler) handleMove(userLocation *model.UserLocation, msg map[string]interface{}) error {
-	fromUserID := uint(msg["fromUserID"].(float64))
-	if !isValidUserId(fromUserID) {
-		return fmt.Errorf("invalid fromUserID")
+func (h *WebSocketHandler) handleMove(userLocation *model.UserLocation, msg *protocol.MoveInArea) error {
+	if msg.AreaID != userLocation.AreaID {
+		return fmt.Errorf("%w: areaID %d does not match joined area %d", usecase.ErrNotInRoom, msg.AreaID, userLocation.AreaID)
 	}
-	areaID := uint(msg["areaID"].(float64))
-	userLocation.UserID = fromUserID
-	userLocation.AreaID = areaID
-	xAxis := int(msg["xAxis"].(float64))
-	yAxis := int(msg["yAxis"].(float64))
 
-	err := h.userLocationUsecase.MoveInArea(userLocation, xAxis, yAxis)
+	err := h.userLocationUsecase.MoveInArea(userLocation, int(msg.XAxis), int(msg.YAxis))
 	if err != nil {
 		log.Printf("Error updating and broadcasting user location: %v", err)
 		return err
@@ -180,14 +206,9 @@ func (h *WebSocketHandler) handleMove(userLocation *model.UserLocation, msg map[
 	return nil
 }
 
-func (h *WebSocketHandler) handleSignalingMessage(userLocation *model.UserLocation, msg map[string]interface{}) error {
-	toUserID := uint(msg["toUserID"].(float64))
-	if !isValidUserId(toUserID) {
-		return fmt.Errorf("invalid toUserID")
-	}
-	msgPayload := &model.Message{Payload: msg}
+func (h *WebSocketHandler) handleSignalingMessage(userLocation *model.UserLocation, msg *protocol.Signal) error {
 	// 特定のユーザーにメッセージを送信する(ここでルーム全員に送信するとブラウザ側でメモリエラーになる)
-	err := h.userLocationUsecase.SendMessageToSpecificUser(userLocation, msgPayload, toUserID)
+	err := h.userLocationUsecase.ForwardSignal(userLocation, msg)
 	if err != nil {
 		log.Printf("handleSignalingMessage: Error sending message to specific user: %v", err)
 		return err
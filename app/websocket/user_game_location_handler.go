@@ -8,58 +8,107 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/sako0/minigame-space-api/app/domain/model"
+	"github.com/sako0/minigame-space-api/app/protocol"
 	"github.com/sako0/minigame-space-api/app/usecase"
+	"github.com/sako0/minigame-space-api/app/wsconn"
 )
 
 type UserGameLocationHandler struct {
 	userGameLocationUsecase usecase.UserGameLocationUsecase
+	matchmakingUsecase      *usecase.MatchmakingUsecase
+	authUsecase             *usecase.AuthUsecase
+	moderationUsecase       *usecase.ModerationUsecase
+	iceServerUsecase        *usecase.ICEServerUsecase
 	upgrader                websocket.Upgrader
+	connOptions             wsconn.Options
 }
 
-func NewUserGameLocationHandler(userGameLocationUsecase usecase.UserGameLocationUsecase, upgrader websocket.Upgrader) *UserGameLocationHandler {
-	return &UserGameLocationHandler{userGameLocationUsecase: userGameLocationUsecase, upgrader: upgrader}
+func NewUserGameLocationHandler(userGameLocationUsecase usecase.UserGameLocationUsecase, matchmakingUsecase *usecase.MatchmakingUsecase, authUsecase *usecase.AuthUsecase, moderationUsecase *usecase.ModerationUsecase, iceServerUsecase *usecase.ICEServerUsecase, upgrader websocket.Upgrader, connOptions wsconn.Options) *UserGameLocationHandler {
+	return &UserGameLocationHandler{userGameLocationUsecase: userGameLocationUsecase, matchmakingUsecase: matchmakingUsecase, authUsecase: authUsecase, moderationUsecase: moderationUsecase, iceServerUsecase: iceServerUsecase, upgrader: upgrader, connOptions: connOptions}
 }
 
-const PingTimeout = 20 * time.Second
-
 func (h *UserGameLocationHandler) HandleConnections(w http.ResponseWriter, r *http.Request) {
-	conn, err := h.upgrader.Upgrade(w, r, nil)
+	idToken, responseHeader := extractIDToken(r)
+	user, err := h.authUsecase.Authenticate(idToken)
+	if err != nil {
+		log.Printf("Error authenticating connection: %v", err)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ws, err := h.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Printf("Error upgrading connection: %v", err)
 		return
 	}
+	conn := wsconn.New(ws, h.connOptions)
 	defer conn.Close()
+	defer recoverConnection()
 
 	userGameLocation := model.NewUserGameLocationByConn(conn)
+	// 以降この接続のユーザーは ID トークンで検証したユーザーに固定する
+	userGameLocation.UserID = user.ID
 
 	defer func() {
 		// クリーンアップ処理
 		log.Println("disconnect userGameLocation:", userGameLocation.UserID)
-		h.cleanUp(userGameLocation)
+		h.cleanUp(userGameLocation, conn)
 
 	}()
 
-	// ゴルーチンを起動してピングの監視を行う
-	lastPingTime := time.Now()
+	// マッチングで割り当てられたルームへの参加も、RoomID を書き換えるためこのゴルーチンで行う
+	matched := make(chan *model.MatchTicket, 1)
+	messages := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
+		defer close(messages)
+		// 応答のない接続は wsconn の読み込みデッドラインでエラーになりループを抜ける
 		for {
-			time.Sleep(time.Second)
-			if time.Since(lastPingTime) > PingTimeout {
-				log.Printf("Ping timeout for user: %v", userGameLocation.UserID)
-				h.cleanUp(userGameLocation)
-				conn.Close()
-				break
+			data, err := h.readMessage(conn)
+			if err != nil {
+				log.Printf("Error reading message: %v", err)
+				return
+			}
+			select {
+			case messages <- data:
+			case <-done:
+				return
 			}
 		}
 	}()
+
 	for {
-		msg, err := h.readMessage(conn)
+		var data []byte
+		select {
+		case ticket := <-matched:
+			h.matchmakingUsecase.JoinMatchedRoom(ticket)
+			continue
+		case received, ok := <-messages:
+			if !ok {
+				return
+			}
+			data = received
+		}
+		msg, err := protocol.DecodeGameMessage(data)
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
-			break
+			log.Printf("Error decoding message: %v", err)
+			writeError(conn, "", err)
+			continue
+		}
+		// 再接続の場合はこの接続で扱う UserGameLocation を既存のものに切り替える
+		if resume, ok := msg.(*protocol.Resume); ok {
+			resumed, err := h.handleResume(userGameLocation, conn, resume)
+			if err != nil {
+				log.Printf("Error resuming session: %v", err)
+				writeError(conn, resume.Type, err)
+				continue
+			}
+			userGameLocation = resumed
+			continue
 		}
-		if msg["type"].(string) == "ping" {
-			lastPingTime = time.Now()
+		// 旧クライアント向けの JSON の ping
+		if _, ok := msg.(*protocol.Ping); ok {
 			err := h.handlePing(conn, userGameLocation)
 			if err != nil {
 				log.Printf("Error handling ping: %v", err)
@@ -67,104 +116,117 @@ func (h *UserGameLocationHandler) HandleConnections(w http.ResponseWriter, r *ht
 			}
 			continue
 		}
-		err = h.processMessage(userGameLocation, msg)
+		err = h.processMessage(userGameLocation, msg, matched)
 		if err != nil {
 			log.Printf("Error processing message: %v", err)
-			break
+			return
 		}
 	}
 }
 
-func (h *UserGameLocationHandler) readMessage(conn *websocket.Conn) (map[string]interface{}, error) {
-
-	var msg = map[string]interface{}{}
-	err := conn.ReadJSON(&msg)
+func (h *UserGameLocationHandler) readMessage(conn *wsconn.Conn) ([]byte, error) {
+	data, err := conn.ReadMessage()
 	if err != nil {
 		// 一時的なエラーの場合はリトライ
 		if isTemporary(err) {
 			time.Sleep(retryInterval)
 			return h.readMessage(conn)
 		}
-		log.Printf("Error reading JSON: %v", err)
+		log.Printf("Error reading message: %v", err)
 		return nil, err
 	}
-	return msg, nil
+	return data, nil
 }
 
-func (h *UserGameLocationHandler) processMessage(userGameLocation *model.UserGameLocation, msg map[string]interface{}) error {
-	var err error
-	switch msg["type"].(string) {
-	case "join-game":
-		err = h.handleJoinGame(userGameLocation, msg)
-	case "join-audio":
-		err = h.handleJoinAudio(userGameLocation, msg)
-	case "leave-game":
-		err = h.handleLeaveGame(userGameLocation, msg)
-	case "leave-audio":
-		err = h.handleLeaveAudio(userGameLocation, msg)
-	case "move":
-		err = h.handleMoveGame(userGameLocation, msg)
-	case "offer", "answer", "ice-candidate":
-		err = h.handleSignalingMessage(userGameLocation, msg)
-	default:
-		err = fmt.Errorf("unknown message type")
+func (h *UserGameLocationHandler) processMessage(userGameLocation *model.UserGameLocation, msg protocol.Inbound, matched chan<- *model.MatchTicket) error {
+	err := checkFromUserID(msg, userGameLocation.UserID)
+	if err == nil {
+		switch m := msg.(type) {
+		case *protocol.JoinGame:
+			err = h.handleJoinGame(userGameLocation, m)
+		case *protocol.JoinAudio:
+			err = h.handleJoinAudio(userGameLocation, m)
+		case *protocol.LeaveGame:
+			err = h.handleLeaveGame(userGameLocation, m)
+		case *protocol.LeaveAudio:
+			err = h.handleLeaveAudio(userGameLocation, m)
+		case *protocol.MoveInGame:
+			err = h.handleMoveGame(userGameLocation, m)
+		case *protocol.Signal:
+			err = h.handleSignalingMessage(userGameLocation, m)
+		case *protocol.FindMatch:
+			err = h.matchmakingUsecase.FindMatch(userGameLocation, m.AreaID, m.RoomTypeID, matched)
+		case *protocol.CancelMatch:
+			err = h.matchmakingUsecase.CancelMatch(userGameLocation)
+		case *protocol.Ack:
+			err = h.userGameLocationUsecase.AckState(userGameLocation, m.Seq)
+		case *protocol.RequestKeyframe:
+			err = h.userGameLocationUsecase.SendKeyframe(userGameLocation)
+		case *protocol.Chat:
+			err = h.userGameLocationUsecase.SendChatMessage(userGameLocation, m)
+		case *protocol.Moderate:
+			err = h.moderationUsecase.ModerateRoom(userGameLocation.UserID, userGameLocation.RoomID, m)
+		case *protocol.ReadyCheck:
+			err = h.userGameLocationUsecase.StartReadyCheck(userGameLocation)
+		case *protocol.Ready:
+			err = h.userGameLocationUsecase.SetReady(userGameLocation, m.Ready)
+		case *protocol.EndGame:
+			err = h.userGameLocationUsecase.EndGame(userGameLocation)
+		case *protocol.GameInput:
+			err = h.userGameLocationUsecase.SendGameInput(userGameLocation, m)
+		default:
+			err = fmt.Errorf("%w: unsupported message type %q", usecase.ErrInvalidArgument, msg.Header().Type)
+		}
 	}
 	if err != nil {
 		// 一時的なエラーの場合はリトライ
 		if isTemporary(err) {
 			time.Sleep(retryInterval)
-			return h.processMessage(userGameLocation, msg)
+			return h.processMessage(userGameLocation, msg, matched)
 		}
 		log.Printf("Error processing message: %v", err)
+		writeError(userGameLocation.Connection(), msg.Header().Type, err)
 	}
 	return nil
 }
 
-func (h *UserGameLocationHandler) handleJoinGame(userGameLocation *model.UserGameLocation, msg map[string]interface{}) error {
-
-	roomID := uint(msg["roomID"].(float64))
-	if !isValidRoomId(roomID) {
-		return fmt.Errorf("invalid roomID")
-	}
-	userGameLocation.RoomID = roomID
-
-	fromUserID := uint(msg["fromUserID"].(float64))
-	if !isValidUserId(fromUserID) {
-		return fmt.Errorf("invalid fromUserID")
-	}
-
-	userGameLocation.UserID = fromUserID
+func (h *UserGameLocationHandler) handleJoinGame(userGameLocation *model.UserGameLocation, msg *protocol.JoinGame) error {
+	// ルームを指定して参加する場合はマッチング待ちをやめる
+	h.matchmakingUsecase.Withdraw(userGameLocation.UserID)
+	previousRoomID := userGameLocation.RoomID
+	userGameLocation.RoomID = msg.RoomID
 
-	err := h.userGameLocationUsecase.ConnectUserGameLocation(userGameLocation)
+	access := usecase.RoomAccess{Passcode: msg.Passcode, InviteCode: msg.InviteCode}
+	err := h.userGameLocationUsecase.JoinGame(userGameLocation, access)
 	if err != nil {
-		return fmt.Errorf("error connecting client to game: %v", err)
-	}
-
-	err = h.userGameLocationUsecase.SendGameJoinedEvent(userGameLocation)
-	if err != nil {
-		log.Printf("handleJoinGame: Error joining game: %v", err)
-		return err
+		// 参加できなかった場合は元のルームに戻す
+		userGameLocation.RoomID = previousRoomID
+		return fmt.Errorf("error joining game: %w", err)
 	}
 	return nil
 }
-func (h *UserGameLocationHandler) handleJoinAudio(userGameLocation *model.UserGameLocation, msg map[string]interface{}) error {
-	roomId := uint(msg["roomID"].(float64))
-	if !isValidRoomId(roomId) {
-		return fmt.Errorf("invalid roomID")
-	}
-	userGameLocation.RoomID = roomId
 
-	fromUserID := uint(msg["fromUserID"].(float64))
-	if !isValidUserId(fromUserID) {
-		return fmt.Errorf("invalid fromUserID")
+func (h *UserGameLocationHandler) handleResume(userGameLocation *model.UserGameLocation, conn *wsconn.Conn, msg *protocol.Resume) (*model.UserGameLocation, error) {
+	if err := checkFromUserID(msg, userGameLocation.UserID); err != nil {
+		return nil, err
 	}
-	userGameLocation.UserID = fromUserID
+	return h.userGameLocationUsecase.ResumeUserGameLocation(userGameLocation.UserID, msg.ResumeToken, conn)
+}
+func (h *UserGameLocationHandler) handleJoinAudio(userGameLocation *model.UserGameLocation, msg *protocol.JoinAudio) error {
+	previousRoomID := userGameLocation.RoomID
+	userGameLocation.RoomID = msg.RoomID
 
 	err := h.userGameLocationUsecase.ConnectUserGameLocation(userGameLocation)
 	if err != nil {
-		return fmt.Errorf("error connecting client to audio: %v", err)
+		userGameLocation.RoomID = previousRoomID
+		return fmt.Errorf("error connecting client to audio: %w", err)
 	}
 
+	// offer を送る前に使う ICE サーバーを知らせる
+	err = userGameLocation.Connection().Send(h.iceServerUsecase.GetICEConfig(userGameLocation.UserID))
+	if err != nil {
+		return err
+	}
 	err = h.userGameLocationUsecase.SendAudioJoinedEvent(userGameLocation)
 	if err != nil {
 		log.Printf("handleJoinAudio: Error joining audio: %v", err)
@@ -173,7 +235,7 @@ func (h *UserGameLocationHandler) handleJoinAudio(userGameLocation *model.UserGa
 	return nil
 }
 
-func (h *UserGameLocationHandler) handleLeaveGame(userGameLocation *model.UserGameLocation, msg map[string]interface{}) error {
+func (h *UserGameLocationHandler) handleLeaveGame(userGameLocation *model.UserGameLocation, msg *protocol.LeaveGame) error {
 	err := h.userGameLocationUsecase.LeaveInGame(userGameLocation, userGameLocation.RoomID)
 	if err != nil {
 		log.Printf("handleLeaveGame: Error leaving game: %v", err)
@@ -183,18 +245,11 @@ func (h *UserGameLocationHandler) handleLeaveGame(userGameLocation *model.UserGa
 	return nil
 }
 
-func (h *UserGameLocationHandler) handleLeaveAudio(userGameLocation *model.UserGameLocation, msg map[string]interface{}) error {
-	roomId := uint(msg["roomID"].(float64))
-	if !isValidRoomId(roomId) {
-		return fmt.Errorf("invalid roomID")
-	}
-	userGameLocation.RoomID = roomId
-
-	fromUserID := uint(msg["fromUserID"].(float64))
-	if !isValidUserId(fromUserID) {
-		return fmt.Errorf("invalid fromUserID")
+func (h *UserGameLocationHandler) handleLeaveAudio(userGameLocation *model.UserGameLocation, msg *protocol.LeaveAudio) error {
+	// 参加していないルームの ID に書き換えられると、そのルームのユーザーに送れてしまう
+	if msg.RoomID != userGameLocation.RoomID {
+		return fmt.Errorf("%w: roomID %d does not match joined room %d", usecase.ErrNotInRoom, msg.RoomID, userGameLocation.RoomID)
 	}
-	userGameLocation.UserID = fromUserID
 
 	err := h.userGameLocationUsecase.LeaveInAudio(userGameLocation, userGameLocation.RoomID)
 	if err != nil {
@@ -205,22 +260,12 @@ func (h *UserGameLocationHandler) handleLeaveAudio(userGameLocation *model.UserG
 	return nil
 }
 
-func (h *UserGameLocationHandler) handleMoveGame(userGameLocation *model.UserGameLocation, msg map[string]interface{}) error {
-	fromUserID := uint(msg["fromUserID"].(float64))
-	if !isValidUserId(fromUserID) {
-		return fmt.Errorf("invalid fromUserID")
-	}
-	roomID := uint(msg["roomID"].(float64))
-	if !isValidRoomId(roomID) {
-		return fmt.Errorf("invalid roomID")
+func (h *UserGameLocationHandler) handleMoveGame(userGameLocation *model.UserGameLocation, msg *protocol.MoveInGame) error {
+	if msg.RoomID != userGameLocation.RoomID {
+		return fmt.Errorf("%w: roomID %d does not match joined room %d", usecase.ErrNotInRoom, msg.RoomID, userGameLocation.RoomID)
 	}
 
-	userGameLocation.UserID = fromUserID
-	userGameLocation.RoomID = roomID
-	xAxis := int(msg["xAxis"].(float64))
-	yAxis := int(msg["yAxis"].(float64))
-
-	err := h.userGameLocationUsecase.MoveInGame(userGameLocation, xAxis, yAxis)
+	err := h.userGameLocationUsecase.MoveInGame(userGameLocation, int(msg.XAxis), int(msg.YAxis))
 	if err != nil {
 		log.Printf("Error updating and broadcasting user location: %v", err)
 		return err
@@ -228,14 +273,9 @@ func (h *UserGameLocationHandler) handleMoveGame(userGameLocation *model.UserGam
 	return nil
 }
 
-func (h *UserGameLocationHandler) handleSignalingMessage(userGameLocation *model.UserGameLocation, msg map[string]interface{}) error {
-	toUserID := uint(msg["toUserID"].(float64))
-	if !isValidUserId(toUserID) {
-		return fmt.Errorf("invalid toUserID")
-	}
-	msgPayload := &model.Message{Payload: msg}
+func (h *UserGameLocationHandler) handleSignalingMessage(userGameLocation *model.UserGameLocation, msg *protocol.Signal) error {
 	// 特定のユーザーにメッセージを送信する(ここでルーム全員に送信するとブラウザ側でメモリエラーになる)
-	err := h.userGameLocationUsecase.SendMessageToSpecificUser(userGameLocation, msgPayload, toUserID)
+	err := h.userGameLocationUsecase.ForwardSignal(userGameLocation, msg)
 	if err != nil {
 		log.Printf("handleSignalingMessage: Error sending message to specific user: %v", err)
 		return err
@@ -243,25 +283,19 @@ func (h *UserGameLocationHandler) handleSignalingMessage(userGameLocation *model
 	return nil
 }
 
-func (h UserGameLocationHandler) handlePing(conn *websocket.Conn, userGameLocation *model.UserGameLocation) error {
+func (h UserGameLocationHandler) handlePing(conn *wsconn.Conn, userGameLocation *model.UserGameLocation) error {
 	// ユーザーの接続状態を確認する
 	err := h.userGameLocationUsecase.PingUserGameLocation(userGameLocation)
 	if err != nil {
 		log.Printf("handlePing: Error pinging user: %v", err)
-		h.cleanUp(userGameLocation)
 		return err
 	}
 	return nil
 }
 
-func (h UserGameLocationHandler) cleanUp(userGameLocation *model.UserGameLocation) {
-	err := h.userGameLocationUsecase.DisconnectInAudio(userGameLocation, userGameLocation.RoomID)
-	if err != nil {
-		log.Printf("cleanUp: Error disconnecting audio: %v", err)
+func (h UserGameLocationHandler) cleanUp(userGameLocation *model.UserGameLocation, conn *wsconn.Conn) {
+	if userGameLocation.Connection() == conn {
+		h.matchmakingUsecase.Withdraw(userGameLocation.UserID)
 	}
-	err = h.userGameLocationUsecase.DisconnectInGame(userGameLocation, userGameLocation.RoomID)
-	if err != nil {
-		log.Printf("cleanUp: Error disconnecting game: %v", err)
-	}
-
+	h.userGameLocationUsecase.HandleConnectionLost(userGameLocation, conn)
 }
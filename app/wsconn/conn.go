@@ -0,0 +1,268 @@
+package wsconn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrClosed       = errors.New("connection closed")
+	ErrSlowConsumer = errors.New("send queue overflow")
+)
+
+// 送信キューが溢れた時の振る舞い
+type OverflowPolicy string
+
+const (
+	// 一番古いフレームを捨てて新しいフレームを積む
+	DropOldest OverflowPolicy = "drop-oldest"
+	// 未送信の move は最新のものだけを残す。それでも溢れた場合は一番古いフレームを捨てる
+	CoalesceMove OverflowPolicy = "coalesce-move"
+	// 送信が追いつかないクライアントは切断する
+	Disconnect OverflowPolicy = "disconnect"
+)
+
+func ParseOverflowPolicy(value string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(value); policy {
+	case DropOldest, CoalesceMove, Disconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy: %q", value)
+	}
+}
+
+type Options struct {
+	QueueSize      int
+	OverflowPolicy OverflowPolicy
+	WriteTimeout   time.Duration
+	// この時間内に pong もメッセージも届かなければ切断する
+	PongWait time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		QueueSize:      64,
+		OverflowPolicy: CoalesceMove,
+		WriteTimeout:   10 * time.Second,
+		PongWait:       20 * time.Second,
+	}
+}
+
+// pong が返ってくるまでの余裕を残して ping を送る
+func (o Options) pingPeriod() time.Duration {
+	return o.PongWait * 9 / 10
+}
+
+// 未送信のものは最新の 1 件だけ送ればよいメッセージ
+type Coalescer interface {
+	CoalesceKey() string
+}
+
+type frame struct {
+	key  string
+	data []byte
+	// true の場合はここまでを送り終えたことを示し、接続を閉じる
+	close bool
+}
+
+// WebSocket 接続ごとの書き込み専用ゴルーチンと送信キュー
+// Send はキューに積むだけなので、遅いクライアントがいても呼び出し元はブロックしない
+// また ping/pong と読み込みのデッドラインで応答のない接続を検出する
+type Conn struct {
+	ws   *websocket.Conn
+	opts Options
+	send chan *frame
+	done chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]*frame
+	closed  bool
+	closing bool
+}
+
+func New(ws *websocket.Conn, opts Options) *Conn {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultOptions().QueueSize
+	}
+	if opts.OverflowPolicy == "" {
+		opts.OverflowPolicy = DefaultOptions().OverflowPolicy
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultOptions().WriteTimeout
+	}
+	if opts.PongWait <= 0 {
+		opts.PongWait = DefaultOptions().PongWait
+	}
+	c := &Conn{
+		ws:      ws,
+		opts:    opts,
+		send:    make(chan *frame, opts.QueueSize),
+		done:    make(chan struct{}),
+		pending: make(map[string]*frame),
+	}
+	c.extendReadDeadline()
+	ws.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+	go c.writePump()
+	return c
+}
+
+func (c *Conn) extendReadDeadline() {
+	c.ws.SetReadDeadline(time.Now().Add(c.opts.PongWait))
+}
+
+func (c *Conn) Send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Send: %v", err)
+	}
+	key := ""
+	if coalescer, ok := v.(Coalescer); ok && c.opts.OverflowPolicy == CoalesceMove {
+		key = coalescer.CoalesceKey()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.closing {
+		return ErrClosed
+	}
+	if key != "" {
+		if f, ok := c.pending[key]; ok {
+			f.data = data
+			return nil
+		}
+	}
+
+	f := &frame{key: key, data: data}
+	if c.enqueueLocked(f) {
+		return nil
+	}
+
+	switch c.opts.OverflowPolicy {
+	case Disconnect:
+		log.Printf("wsconn: send queue overflow, disconnecting %s", c.ws.RemoteAddr())
+		c.closeLocked()
+		return ErrSlowConsumer
+	default:
+		c.dropOldestLocked()
+		if !c.enqueueLocked(f) {
+			return ErrSlowConsumer
+		}
+		return nil
+	}
+}
+
+func (c *Conn) enqueueLocked(f *frame) bool {
+	select {
+	case c.send <- f:
+		if f.key != "" {
+			c.pending[f.key] = f
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Conn) dropOldestLocked() {
+	select {
+	case old := <-c.send:
+		if old.key != "" && c.pending[old.key] == old {
+			delete(c.pending, old.key)
+		}
+	default:
+	}
+}
+
+func (c *Conn) writePump() {
+	ticker := time.NewTicker(c.opts.pingPeriod())
+	defer func() {
+		ticker.Stop()
+		c.Close()
+	}()
+	for {
+		select {
+		case f := <-c.send:
+			c.mu.Lock()
+			data := f.data
+			if f.key != "" && c.pending[f.key] == f {
+				delete(c.pending, f.key)
+			}
+			c.mu.Unlock()
+
+			if f.close {
+				c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(c.opts.WriteTimeout))
+				return
+			}
+			c.ws.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+			if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Printf("wsconn: error writing message: %v", err)
+				return
+			}
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.opts.WriteTimeout)); err != nil {
+				log.Printf("wsconn: error writing ping: %v", err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// 読み込みは接続ごとに 1 つのゴルーチンからだけ呼び出すこと
+func (c *Conn) ReadMessage() ([]byte, error) {
+	_, data, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	// アプリケーションレベルの ping などメッセージが届いている間も生存とみなす
+	c.extendReadDeadline()
+	return data, nil
+}
+
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeLocked()
+}
+
+// 送信キューに積まれているメッセージを送り終えてから閉じる。以降の Send は ErrClosed になる
+func (c *Conn) CloseAfterFlush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.closing {
+		return
+	}
+	c.closing = true
+	if !c.enqueueLocked(&frame{close: true}) {
+		c.closeLocked()
+	}
+}
+
+func (c *Conn) closeLocked() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	return c.ws.Close()
+}
+
+func (c *Conn) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
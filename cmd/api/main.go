@@ -1,18 +1,32 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
+	"github.com/pion/webrtc/v3"
+	"github.com/sako0/minigame-space-api/app/auth"
 	"github.com/sako0/minigame-space-api/app/config"
 	"github.com/sako0/minigame-space-api/app/database"
+	"github.com/sako0/minigame-space-api/app/domain/repository"
 	"github.com/sako0/minigame-space-api/app/infra/gorm"
 	"github.com/sako0/minigame-space-api/app/infra/in_memory"
+	"github.com/sako0/minigame-space-api/app/minigame"
+	"github.com/sako0/minigame-space-api/app/minigame/tileclaim"
+	"github.com/sako0/minigame-space-api/app/rest"
+	"github.com/sako0/minigame-space-api/app/sfu"
 
 	"github.com/sako0/minigame-space-api/app/usecase"
 	handler "github.com/sako0/minigame-space-api/app/websocket"
+	"github.com/sako0/minigame-space-api/app/wsconn"
 )
 
 func main() {
@@ -28,20 +42,103 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	err = cfg.ValidateForAPI()
+	if err != nil {
+		panic(err)
+	}
 	// データベース接続
 	db, err := database.NewSQLConnection(cfg.AppInfo.DatabaseURL)
 	if err != nil {
 		panic(err)
 	}
 
+	// Firebase ID トークン検証用の公開鍵
+	var keySet auth.KeySet = auth.NewRemoteKeySet(auth.FirebaseJWKSURL, nil)
+	if cfg.Firebase.JWKSFile != "" {
+		keySet, err = auth.NewFileKeySet(cfg.Firebase.JWKSFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	verifier := auth.NewFirebaseVerifier(cfg.Firebase.ProjectID, keySet)
+
+	userRepo := gorm.NewUserRepository(db)
 	userLocationRepo := gorm.NewUserLocationRepository(db)
 	userGameLocation := gorm.NewUserGameLocationRepository(db)
 	inMemoryUserLocationRepo := in_memory.NewInMemoryUserLocationRepository()
 	inMemoryUserGameLocationRepo := in_memory.NewInMemoryUserGameLocationRepository()
-	roomUsecase := usecase.NewUserLocationUsecase(userLocationRepo, inMemoryUserLocationRepo)
-	userGameLocationUsecase := usecase.NewUserGameLocationUsecase(userGameLocation, inMemoryUserGameLocationRepo)
-	wsHandler := handler.NewWebSocketHandler(*roomUsecase, upgrader)
-	wsGameHandler := handler.NewUserGameLocationHandler(*userGameLocationUsecase, upgrader)
+	inMemoryGameSessionRepo := in_memory.NewInMemoryGameSessionRepository()
+	areaRepo := gorm.NewAreaRepository(db)
+	roomRepo := gorm.NewRoomRepository(db)
+	roomTypeRepo := gorm.NewRoomTypeRepository(db)
+	chatMessageRepo := gorm.NewChatMessageRepository(db)
+	banRepo := gorm.NewBanRepository(db)
+	roomInviteRepo := gorm.NewRoomInviteRepository(db)
+	var inMemoryWaitingQueueRepo repository.InMemoryWaitingQueueRepository
+	if cfg.Capacity.WaitingQueue {
+		inMemoryWaitingQueueRepo = in_memory.NewInMemoryWaitingQueueRepository()
+	}
+	// 位置は一定間隔でまとめて DB に書き込む
+	var positionWriter *usecase.PositionWriter
+	if cfg.Persistence.FlushInterval > 0 {
+		positionWriter = usecase.NewPositionWriter(userLocationRepo, userGameLocation, cfg.Persistence.FlushInterval)
+		positionWriter.Start()
+	}
+	// voiceMode が sfu のルームタイプの音声を中継する
+	var voiceSFU *sfu.SFU
+	if cfg.SFU.Enabled {
+		sfuOptions := sfu.Options{
+			PublicIPs: cfg.SFU.PublicIPs,
+			PortMin:   uint16(cfg.SFU.UDPPortMin),
+			PortMax:   uint16(cfg.SFU.UDPPortMax),
+		}
+		if len(cfg.SFU.STUNURLs) > 0 {
+			sfuOptions.ICEServers = []webrtc.ICEServer{{URLs: cfg.SFU.STUNURLs}}
+		}
+		voiceSFU, err = sfu.New(sfuOptions)
+		if err != nil {
+			panic(err)
+		}
+	}
+	chatUsecase := usecase.NewChatUsecase(chatMessageRepo, cfg.Chat.MaxLength, cfg.Chat.HistoryLimit, cfg.Chat.RateBurst, cfg.Chat.RateInterval)
+	roomAccessChecker := usecase.NewRoomAccessChecker(userRepo, roomInviteRepo)
+	inMemoryLobbySubscriptionRepo := in_memory.NewInMemoryLobbySubscriptionRepository()
+	lobbyUsecase := usecase.NewLobbyUsecase(areaRepo, roomRepo, inMemoryUserGameLocationRepo, inMemoryLobbySubscriptionRepo)
+	userLocationUsecase := usecase.NewUserLocationUsecase(userLocationRepo, inMemoryUserLocationRepo, areaRepo, roomRepo, cfg.Game.AreaDeltaUpdates, cfg.Game.AreaViewRadius, cfg.Voice.ProximityRadius, cfg.Voice.ExitMargin, positionWriter, voiceSFU, chatUsecase, banRepo, roomAccessChecker)
+	// ルームタイプの minigame に指定できるゲーム
+	minigames := minigame.NewRegistry()
+	minigames.Register(tileclaim.Name, tileclaim.New(tileclaim.Options{
+		TileSize:      cfg.TileClaim.TileSize,
+		RoundDuration: cfg.TileClaim.RoundDuration,
+		Teams:         cfg.TileClaim.Teams,
+	}))
+	lifecycleOptions := usecase.RoomLifecycleOptions{
+		ReadyCheckTimeout: cfg.Lifecycle.ReadyCheckTimeout,
+		Countdown:         cfg.Lifecycle.Countdown,
+		Results:           cfg.Lifecycle.Results,
+		EmptyRoomTimeout:  cfg.Lifecycle.EmptyRoomTimeout,
+	}
+	userGameLocationUsecase := usecase.NewUserGameLocationUsecase(userGameLocation, inMemoryUserGameLocationRepo, inMemoryGameSessionRepo, roomRepo, inMemoryWaitingQueueRepo, lobbyUsecase, cfg.Session.GracePeriod, cfg.Game.TickRate, positionWriter, voiceSFU, chatUsecase, banRepo, roomAccessChecker, lifecycleOptions, minigames, cfg.Game.MinigameTickRate)
+	inMemoryMatchTicketRepo := in_memory.NewInMemoryMatchTicketRepository()
+	matchmakingUsecase := usecase.NewMatchmakingUsecase(areaRepo, roomRepo, roomTypeRepo, inMemoryUserGameLocationRepo, inMemoryMatchTicketRepo, userGameLocationUsecase, lobbyUsecase, cfg.Matchmaking.Timeout, cfg.Matchmaking.MinPlayers)
+	matchmakingUsecase.Start()
+	authUsecase := usecase.NewAuthUsecase(verifier, userRepo)
+	moderationUsecase := usecase.NewModerationUsecase(banRepo, userRepo, roomRepo, inMemoryUserLocationRepo, inMemoryUserGameLocationRepo, userLocationUsecase, userGameLocationUsecase)
+	iceServerUsecase := usecase.NewICEServerUsecase(cfg.ICE.STUNURLs, cfg.ICE.TURNURLs, cfg.ICE.TURNSecret, cfg.ICE.TURNCredentialTTL)
+	areaUsecase := usecase.NewAreaUsecase(areaRepo, roomRepo)
+	roomTypeUsecase := usecase.NewRoomTypeUsecase(roomTypeRepo, roomRepo, minigames)
+	roomUsecase := usecase.NewRoomUsecase(roomRepo, areaRepo, roomTypeRepo, inMemoryUserGameLocationRepo, lobbyUsecase, userRepo)
+	roomAccessUsecase := usecase.NewRoomAccessUsecase(roomRepo, roomInviteRepo, userRepo, userGameLocationUsecase, lobbyUsecase)
+	overflowPolicy, err := wsconn.ParseOverflowPolicy(cfg.Connection.OverflowPolicy)
+	if err != nil {
+		panic(err)
+	}
+	connOptions := wsconn.DefaultOptions()
+	connOptions.QueueSize = cfg.Connection.SendQueueSize
+	connOptions.OverflowPolicy = overflowPolicy
+	connOptions.PongWait = cfg.Connection.PongWait
+	wsHandler := handler.NewWebSocketHandler(*userLocationUsecase, lobbyUsecase, authUsecase, moderationUsecase, iceServerUsecase, upgrader, connOptions)
+	wsGameHandler := handler.NewUserGameLocationHandler(*userGameLocationUsecase, matchmakingUsecase, authUsecase, moderationUsecase, iceServerUsecase, upgrader, connOptions)
 
 	e := echo.New()
 
@@ -54,13 +151,40 @@ func main() {
 		return nil
 	})
 
+	// エリア・ルームタイプ・ルームの管理用 API
+	api := e.Group("")
+	requireAuth := rest.RequireAuth(authUsecase)
+	rest.NewAreaHandler(areaUsecase, roomUsecase).Register(api, requireAuth)
+	rest.NewRoomTypeHandler(roomTypeUsecase).Register(api, requireAuth)
+	rest.NewRoomHandler(roomUsecase, roomAccessUsecase).Register(api, requireAuth)
+	rest.NewICEServerHandler(iceServerUsecase).Register(api, requireAuth)
+	rest.NewModerationHandler(moderationUsecase).Register(api, requireAuth)
+
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "ok")
 	})
 
-	log.Println("Starting server on :5500")
-	err = e.Start(":5500")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		log.Println("Starting server on :5500")
+		err := e.Start(":5500")
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("ListenAndServe: ", err)
+		}
+	}()
+	<-ctx.Done()
+
+	// 新しい接続を止めてから、溜まっている位置を書き込んで終了する
+	log.Println("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = e.Shutdown(shutdownCtx)
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		log.Printf("Error shutting down server: %v", err)
+	}
+	matchmakingUsecase.Stop()
+	if positionWriter != nil {
+		positionWriter.Stop()
 	}
 }
@@ -27,6 +27,9 @@ func main() {
 		&model.UserGameLocation{},
 		&model.RoomType{},
 		&model.Area{},
+		&model.ChatMessage{},
+		&model.Ban{},
+		&model.RoomInvite{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to drop tables: %v", err)
@@ -41,6 +44,9 @@ func main() {
 		&model.UserGameLocation{},
 		&model.RoomType{},
 		&model.Area{},
+		&model.ChatMessage{},
+		&model.Ban{},
+		&model.RoomInvite{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to migrate: %v", err)